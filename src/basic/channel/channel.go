@@ -1,6 +1,9 @@
 package main
 
 import (
+	"basic/channel/pipeline"
+	"basic/sync/workerpool"
+	"context"
 	"fmt"
 	"time"
 )
@@ -92,49 +95,36 @@ func fetchPerson(origs chan<- Person) {
 	origsCap := cap(origs)
 	// 通过容量判断当前通道的类型是缓冲通道还是非缓冲通道
 	buffered := origsCap > 0
-	goTicketTotal := origsCap / 2
-	fmt.Println("goTicketTotal=", goTicketTotal)
-	goTicket := initGoTicket(goTicketTotal)
-	fmt.Println("buffered start=", len(goTicket))
+	/**
+	原来用goTicket通道来限制启动Goroutine的数量，但是只能通过不停地检查len(goTicket)来等待所有的Goroutine结束，然后才能关闭origs。
+	如果不等待就关闭origs，还没有发送完的Goroutine会向已经关闭的通道发送元素值而引发panic；如果不关闭origs，range origs的地方就会死锁。
+	现在用工作池来限制同时发送的Goroutine的数量，pool.Wait()在最后一个发送的Goroutine结束时返回，不需要轮询
+	*/
+	var pool *workerpool.Pool
+	if buffered {
+		// 容量为1时origsCap/2是0，而池的大小必须大于0
+		pool = workerpool.New(max(1, origsCap/2))
+	}
 	go func() {
 		for {
 			p, ok := fetchPerson1()
-			// 下面注释处这种写法会导致origs不能被关闭，那么在其它地方使用origs和dests通道的地方就会导致死锁
-			/*if !ok {
-				if !buffered || len(goTicket) == goTicketTotal {
-					break
-				}
-				time.Sleep(time.Nanosecond)
-				fmt.Println("All the information has been fetched.")
-				close(origs)
-				break
-			}
-			origs <- p*/
-			fmt.Println("ok=", ok)
 			if !ok {
-				for {
-					fmt.Println("bufferedxxxx=", len(goTicket))
-					if !buffered || len(goTicket) == goTicketTotal {
-						fmt.Println("buffered=", len(goTicket))
-						break
-					}
-					time.Sleep(time.Nanosecond)
+				if buffered {
+					pool.Wait()
 				}
 				fmt.Println("All the information has been fetched.")
 				close(origs)
 				break
 			}
-			/**
-			通过goTicket通道来限制启动Goroutine的数量，只有接收元素值<-goTicket不被阻塞了，才能继续下面的新建Goroutine的动作
-			*/
 			if buffered {
-				// 每次循环都会从goTicket接收元素值
-				<-goTicket
-				go func() {
+				// 没有空闲的Goroutine时阻塞，直到有发送的Goroutine结束。
+				// Submit只会因为ctx被取消、权重超过池的大小或者池被关闭而失败：Background永远不会被取消，
+				// 权重1不会超过大小至少为1的池，这个池也从不关闭，所以这里的错误总是nil
+				if err := pool.Submit(context.Background(), func() {
 					origs <- p
-					// 每个goroutine执行完之前又向goTicket发送元素值，只有所有的goroutine执行完毕才能保证上面的len(goTicket) == goTicketTotal 条件成立
-					goTicket <- 1
-				}()
+				}); err != nil {
+					panic(err)
+				}
 			} else { // 如果origs是非缓冲通道就没必要并发地发送人员信息了，因为非缓冲通道只能同步地传递元素值。在接收完成之前，发送操作是无法完成的
 				origs <- p
 			}
@@ -151,18 +141,6 @@ func fetchPerson1() (Person, bool) {
 	return Person{}, false
 }
 
-func initGoTicket(total int) chan byte {
-	var goTicket chan byte
-	if total == 0 {
-		return goTicket
-	}
-	goTicket = make(chan byte, total)
-	for i := 0; i < total; i++ {
-		goTicket <- 1
-	}
-	return goTicket
-}
-
 func savePerson1(p Person) bool {
 	return true
 }
@@ -225,4 +203,41 @@ func main() {
 	fetchPerson(origs)
 	sign := savePerson(dets)
 	<-sign
+
+	pipelineDemo()
+}
+
+/**
+用pipeline实现同样的流程：从persons获取人员信息，并发地处理，每50个保存一次。
+数据源结束之后它的输出通道就被关闭，不需要goTicket来等待发送的Goroutine，也不需要全局的personCount
+*/
+func pipelineDemo() {
+	handler := getPersonHandler()
+	saved := 0
+	pl := pipeline.New()
+	fetched := pipeline.Source(pl, "fetch", func(ctx context.Context, emit func(v Person) error) error {
+		for _, p := range persons {
+			if err := emit(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	handled := pipeline.Map(fetched, "handle", func(ctx context.Context, p Person) (Person, error) {
+		handler.Handle(&p)
+		return p, nil
+	}, pipeline.Workers(4), pipeline.Ordered())
+	batches := pipeline.Batch(handled, "batch", 50, 100*time.Millisecond)
+	err := pipeline.Sink(batches, "save", func(ctx context.Context, batch []Person) error {
+		for _, p := range batch {
+			savePerson1(p)
+			saved++
+		}
+		return nil
+	}).Run(context.Background())
+	if err != nil {
+		fmt.Println("Pipeline error:", err)
+		return
+	}
+	fmt.Printf("All the information has been saved by the pipeline: %d persons.\n", saved)
 }
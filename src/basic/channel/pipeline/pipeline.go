@@ -0,0 +1,465 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/**
+由通道连接的多级流水线。
+channel.go中的fetchPerson、PersonHandler.Batch和savePerson就是一条流水线：获取人员信息，处理，保存。但是它们只能处理Person，
+依赖全局的persons和personCount，并且要靠goTicket的忙等待来判断什么时候可以关闭通道。
+Pipeline把每一级抽象成一个阶段：第一级是Source，最后一级是Sink，中间可以有任意多个Map、Filter和Batch。
+这些函数都是泛型的，每个函数接收上一个阶段的*Stage[In]，返回下一个阶段的*Stage[Out]，所以阶段之间的类型在编译时检查，
+处理函数直接拿到它的输入类型，不需要类型断言。Go的方法不能有类型参数，所以它们是包级的函数而不是Pipeline的方法。每个阶段在自己的Goroutine中运行，
+可以有多个工作Goroutine，并且在所有的工作Goroutine都结束之后关闭它的输出通道，所以下游总能通过通道的关闭知道上游已经结束了。
+任何一个阶段返回错误（或者panic）时，共享的ctx被取消，所有的阶段都会尽快停止；Run在所有的阶段都结束之后才返回第一个错误。
+*/
+
+// 流水线的一个阶段返回错误时，Run返回的错误
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("pipeline: stage %s: %s", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+type kind int
+
+const (
+	sourceStage kind = iota
+	mapStage
+	filterStage
+	batchStage
+	sinkStage
+)
+
+// 阶段的默认输出通道的容量
+const DefaultBuffer = 16
+
+type stage struct {
+	name    string
+	kind    kind
+	workers int
+	ordered bool
+	buffer  int
+	// 上一个阶段，数据源没有上一个阶段
+	input *stage
+
+	// 通道中传递的是interface{}，类型由泛型的构造函数保证，它们在这些闭包中把元素转换回具体的类型
+	source  func(ctx context.Context, emit func(v interface{}) error) error
+	process func(ctx context.Context, v interface{}) (interface{}, bool, error)
+
+	batchSize int
+	batchWait time.Duration
+	// 把一批元素转换成[]T
+	makeBatch func(items []interface{}) interface{}
+}
+
+// 阶段的可选项
+type StageOption func(s *stage)
+
+// 设置阶段的工作Goroutine的数量，默认是1。它对Source和Batch无效
+func Workers(n int) StageOption {
+	return func(s *stage) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+/**
+有多个工作Goroutine时，按照输入的顺序输出。默认的顺序是处理完成的顺序，它的吞吐量更高。
+有序输出需要等待排在前面的元素，所以最多只有2倍于工作Goroutine数量的元素在处理中
+*/
+func Ordered() StageOption {
+	return func(s *stage) {
+		s.ordered = true
+	}
+}
+
+// 设置阶段的输出通道的容量，默认是DefaultBuffer
+func Buffer(n int) StageOption {
+	return func(s *stage) {
+		if n >= 0 {
+			s.buffer = n
+		}
+	}
+}
+
+type Pipeline struct {
+	stages []*stage
+}
+
+func New() *Pipeline {
+	return &Pipeline{}
+}
+
+// 流水线中输出T类型的元素的阶段，它是下一个阶段的输入
+type Stage[T any] struct {
+	p *Pipeline
+	s *stage
+}
+
+func (p *Pipeline) add(s *stage, opts []StageOption) *stage {
+	s.workers, s.buffer = 1, DefaultBuffer
+	for _, opt := range opts {
+		opt(s)
+	}
+	p.stages = append(p.stages, s)
+	return s
+}
+
+/**
+添加数据源，它必须是第一个阶段。fn通过emit发送每一个元素，emit在下游被取消时返回ctx的错误，此时fn应该尽快返回。
+fn返回之后数据源的输出通道被关闭
+*/
+func Source[T any](p *Pipeline, name string, fn func(ctx context.Context, emit func(v T) error) error, opts ...StageOption) *Stage[T] {
+	s := p.add(&stage{name: name, kind: sourceStage, source: func(ctx context.Context, emit func(v interface{}) error) error {
+		return fn(ctx, func(v T) error { return emit(v) })
+	}}, opts)
+	return &Stage[T]{p, s}
+}
+
+// 添加一个把每个元素转换成另一个元素的阶段
+func Map[In, Out any](in *Stage[In], name string, fn func(ctx context.Context, v In) (Out, error), opts ...StageOption) *Stage[Out] {
+	s := in.p.add(&stage{name: name, kind: mapStage, input: in.s, process: func(ctx context.Context, v interface{}) (interface{}, bool, error) {
+		out, err := fn(ctx, v.(In))
+		return out, true, err
+	}}, opts)
+	return &Stage[Out]{in.p, s}
+}
+
+// 添加一个只保留fn返回true的元素的阶段
+func Filter[T any](in *Stage[T], name string, fn func(ctx context.Context, v T) (bool, error), opts ...StageOption) *Stage[T] {
+	s := in.p.add(&stage{name: name, kind: filterStage, input: in.s, process: func(ctx context.Context, v interface{}) (interface{}, bool, error) {
+		keep, err := fn(ctx, v.(T))
+		return v, keep, err
+	}}, opts)
+	return &Stage[T]{in.p, s}
+}
+
+/**
+添加一个把元素分批的阶段。一批有size个元素时立即输出；maxWait大于0时，
+一批的第一个元素到达之后最多等待maxWait，即使不满也输出。输入结束时输出最后不满的一批
+*/
+func Batch[T any](in *Stage[T], name string, size int, maxWait time.Duration, opts ...StageOption) *Stage[[]T] {
+	if size <= 0 {
+		size = 1
+	}
+	s := in.p.add(&stage{name: name, kind: batchStage, input: in.s, batchSize: size, batchWait: maxWait,
+		makeBatch: func(items []interface{}) interface{} {
+			batch := make([]T, len(items))
+			for i, v := range items {
+				batch[i] = v.(T)
+			}
+			return batch
+		}}, opts)
+	return &Stage[[]T]{in.p, s}
+}
+
+// 添加数据的终点，它必须是最后一个阶段。返回所属的流水线，以便直接调用Run
+func Sink[T any](in *Stage[T], name string, fn func(ctx context.Context, v T) error, opts ...StageOption) *Pipeline {
+	in.p.add(&stage{name: name, kind: sinkStage, input: in.s, process: func(ctx context.Context, v interface{}) (interface{}, bool, error) {
+		return nil, false, fn(ctx, v.(T))
+	}}, opts)
+	return in.p
+}
+
+func (p *Pipeline) validate() error {
+	if len(p.stages) < 2 || p.stages[0].kind != sourceStage || p.stages[len(p.stages)-1].kind != sinkStage {
+		return errors.New("pipeline: a pipeline must start with a source and end with a sink")
+	}
+	names := make(map[string]bool)
+	for i, s := range p.stages {
+		if (s.kind == sourceStage && i != 0) || (s.kind == sinkStage && i != len(p.stages)-1) {
+			return fmt.Errorf("pipeline: stage %s is in the middle of the pipeline", s.name)
+		}
+		// 阶段按照添加的顺序连接，一个阶段的输出只能被下一个阶段使用，流水线不能分叉
+		if i > 0 && s.input != p.stages[i-1] {
+			return fmt.Errorf("pipeline: stage %s does not follow the stage added before it", s.name)
+		}
+		if names[s.name] {
+			return fmt.Errorf("pipeline: duplicate stage %s", s.name)
+		}
+		names[s.name] = true
+	}
+	return nil
+}
+
+/**
+运行流水线，直到数据源结束并且所有的元素都流过了所有的阶段，或者某个阶段返回了错误，或者ctx被取消。
+返回时所有的阶段都已经结束，它们的输出通道都已经关闭。返回的错误是第一个失败的阶段的*StageError，或者ctx的错误。
+同一个Pipeline不能同时运行多次
+*/
+func (p *Pipeline) Run(ctx context.Context) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r := &run{ctx: runCtx, cancel: cancel}
+	var wg sync.WaitGroup
+	var in <-chan interface{}
+	for _, s := range p.stages {
+		var out chan interface{}
+		if s.kind != sinkStage {
+			out = make(chan interface{}, s.buffer)
+		}
+		wg.Add(1)
+		go func(s *stage, in <-chan interface{}, out chan interface{}) {
+			defer wg.Done()
+			if out != nil {
+				defer close(out)
+			}
+			r.runStage(s, in, out)
+			// 提前停止时，丢弃上游剩下的元素，直到上游关闭它的输出通道
+			if in != nil {
+				for range in {
+				}
+			}
+		}(s, in, out)
+		in = out
+	}
+	wg.Wait()
+	if r.err != nil {
+		return r.err
+	}
+	return ctx.Err()
+}
+
+// 一次运行的状态
+type run struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// 记录第一个错误并取消所有的阶段。被取消导致的ctx的错误不是阶段自己的错误
+func (r *run) fail(s *stage, err error) {
+	if r.ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return
+	}
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = &StageError{Stage: s.name, Err: err}
+	}
+	r.mu.Unlock()
+	r.cancel()
+}
+
+// 发送一个元素，在被取消时返回ctx的错误
+func (r *run) emit(out chan<- interface{}, v interface{}) error {
+	select {
+	case out <- v:
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
+
+// 接收一个元素，上游结束或者被取消时ok为false
+func (r *run) receive(in <-chan interface{}) (v interface{}, ok bool) {
+	select {
+	case v, ok = <-in:
+		return v, ok
+	case <-r.ctx.Done():
+		return nil, false
+	}
+}
+
+func (r *run) runStage(s *stage, in <-chan interface{}, out chan interface{}) {
+	switch {
+	case s.kind == sourceStage:
+		err := call(func() error {
+			return s.source(r.ctx, func(v interface{}) error { return r.emit(out, v) })
+		})
+		if err != nil {
+			r.fail(s, err)
+		}
+	case s.kind == batchStage:
+		r.runBatch(s, in, out)
+	case s.ordered && s.workers > 1:
+		r.runOrdered(s, in, out)
+	default:
+		r.runUnordered(s, in, out)
+	}
+}
+
+// 调用用户的函数，把panic转换成错误
+func call(fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn()
+}
+
+func (r *run) process(s *stage, v interface{}) (out interface{}, keep bool, err error) {
+	err = call(func() error {
+		var err error
+		out, keep, err = s.process(r.ctx, v)
+		return err
+	})
+	return out, keep, err
+}
+
+func (r *run) runUnordered(s *stage, in <-chan interface{}, out chan interface{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, ok := r.receive(in)
+				if !ok {
+					return
+				}
+				result, keep, err := r.process(s, v)
+				if err != nil {
+					r.fail(s, err)
+					return
+				}
+				if keep && out != nil && r.emit(out, result) != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type job struct {
+	seq int
+	v   interface{}
+}
+
+type result struct {
+	seq  int
+	v    interface{}
+	keep bool
+}
+
+/**
+有序地并发处理：分发的Goroutine给每个元素编号，工作Goroutine并发地处理，当前Goroutine按照编号的顺序输出结果。
+window限制了已经分发但是还没有输出的元素的数量，以免排在前面的元素处理得慢时，等待它的结果无限地堆积
+*/
+func (r *run) runOrdered(s *stage, in <-chan interface{}, out chan interface{}) {
+	jobs := make(chan job)
+	results := make(chan result, s.workers)
+	window := make(chan struct{}, 2*s.workers)
+	go func() {
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			v, ok := r.receive(in)
+			if !ok {
+				return
+			}
+			select {
+			case window <- struct{}{}:
+			case <-r.ctx.Done():
+				return
+			}
+			select {
+			case jobs <- job{seq, v}:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				v, keep, err := r.process(s, j.v)
+				if err != nil {
+					r.fail(s, err)
+					return
+				}
+				select {
+				case results <- result{j.seq, v, keep}:
+				case <-r.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]result)
+	next := 0
+	for res := range results {
+		pending[res.seq] = res
+		for {
+			res, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-window
+			if res.keep && out != nil {
+				// 被取消时丢弃结果，但是继续接收，直到工作Goroutine都结束为止
+				r.emit(out, res.v)
+			}
+		}
+	}
+}
+
+func (r *run) runBatch(s *stage, in <-chan interface{}, out chan interface{}) {
+	batch := make([]interface{}, 0, s.batchSize)
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	flush := func() bool {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return true
+		}
+		full := s.makeBatch(batch)
+		batch = batch[:0]
+		return r.emit(out, full) == nil
+	}
+	for {
+		select {
+		case v, ok := <-in:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, v)
+			if len(batch) == 1 && s.batchWait > 0 {
+				timer = time.NewTimer(s.batchWait)
+				timeout = timer.C
+			}
+			if len(batch) == s.batchSize && !flush() {
+				return
+			}
+		case <-timeout:
+			if !flush() {
+				return
+			}
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 依次发送0到n-1的数据源
+func numbers(n int) func(ctx context.Context, emit func(v int) error) error {
+	return func(ctx context.Context, emit func(v int) error) error {
+		for i := 0; i < n; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// 并发安全地收集元素的终点
+type collector[T any] struct {
+	mu    sync.Mutex
+	items []T
+}
+
+func (c *collector[T]) sink(ctx context.Context, v T) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = append(c.items, v)
+	return nil
+}
+
+// 随机地休眠一段时间，打乱并发处理完成的顺序
+func jitter() {
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+}
+
+func double(ctx context.Context, v int) (int, error) {
+	jitter()
+	return v * 2, nil
+}
+
+func TestOrdered(t *testing.T) {
+	var c collector[int]
+	p := New()
+	doubled := Map(Source(p, "numbers", numbers(200)), "double", double, Workers(8), Ordered())
+	kept := Filter(doubled, "multiple of 3", func(ctx context.Context, v int) (bool, error) {
+		jitter()
+		return v%3 == 0, nil
+	}, Workers(4), Ordered())
+	err := Sink(kept, "collect", c.sink).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	var expected []int
+	for i := 0; i < 200; i++ {
+		if i*2%3 == 0 {
+			expected = append(expected, i*2)
+		}
+	}
+	if !reflect.DeepEqual(c.items, expected) {
+		t.Fatalf("ERROR: The output is %v", c.items)
+	}
+}
+
+func TestUnordered(t *testing.T) {
+	var c collector[int]
+	p := New()
+	doubled := Map(Source(p, "numbers", numbers(200)), "double", double, Workers(8))
+	err := Sink(doubled, "collect", c.sink, Workers(3)).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	got := c.items
+	sort.Ints(got)
+	for i, v := range got {
+		if v != i*2 {
+			t.Fatalf("ERROR: The output is %v", got)
+		}
+	}
+	if len(got) != 200 {
+		t.Fatalf("ERROR: %d items are output", len(got))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var c collector[[]int]
+	err := Sink(Batch(Source(New(), "numbers", numbers(10)), "batch", 4, 0), "collect", c.sink).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	expected := [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}}
+	if !reflect.DeepEqual(c.items, expected) {
+		t.Fatalf("ERROR: The batches are %v", c.items)
+	}
+
+	// 不满的一批在maxWait之后输出
+	c = collector[[]int]{}
+	release := make(chan struct{})
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		close(release)
+	}()
+	slow := Source(New(), "slow", func(ctx context.Context, emit func(v int) error) error {
+		emit(1)
+		emit(2)
+		<-release
+		return emit(3)
+	})
+	err = Sink(Batch(slow, "batch", 100, 50*time.Millisecond), "collect", func(ctx context.Context, v []int) error {
+		// 在release之前输出的一批用-1标记
+		select {
+		case <-release:
+		default:
+			v = append(v, -1)
+		}
+		return c.sink(ctx, v)
+	}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	expected = [][]int{{1, 2, -1}, {3}}
+	if !reflect.DeepEqual(c.items, expected) {
+		t.Fatalf("ERROR: The batches are %v", c.items)
+	}
+}
+
+// 一个阶段失败时，上游和下游都被取消，Run返回这个阶段的错误
+func TestError(t *testing.T) {
+	boom := errors.New("boom")
+	var emitted, sunk int64
+	endless := Source(New(), "endless", func(ctx context.Context, emit func(v int) error) error {
+		for i := 0; ; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+			atomic.AddInt64(&emitted, 1)
+		}
+	})
+	failed := Map(endless, "fail", func(ctx context.Context, v int) (int, error) {
+		if v == 100 {
+			return 0, boom
+		}
+		return v, nil
+	}, Workers(4), Ordered())
+	err := Sink(failed, "count", func(ctx context.Context, v int) error {
+		atomic.AddInt64(&sunk, 1)
+		return nil
+	}).Run(context.Background())
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != "fail" || !errors.Is(err, boom) {
+		t.Fatalf("ERROR: Run returns %v", err)
+	}
+	if n := atomic.LoadInt64(&sunk); n > 100 {
+		t.Fatalf("ERROR: %d items after the failed one are output", n)
+	}
+	// Run返回之后数据源已经停止
+	n := atomic.LoadInt64(&emitted)
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt64(&emitted) != n {
+		t.Fatal("ERROR: The source is still running!")
+	}
+
+	err = Sink(Source(New(), "numbers", numbers(10)), "panic", func(ctx context.Context, v int) error {
+		panic("oops")
+	}).Run(context.Background())
+	if !errors.As(err, &stageErr) || stageErr.Stage != "panic" || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("ERROR: Run returns %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	endless := Source(New(), "endless", func(ctx context.Context, emit func(v int) error) error {
+		for {
+			if err := emit(0); err != nil {
+				return err
+			}
+		}
+	})
+	err := Sink(Batch(endless, "batch", 10, time.Second), "slow", func(ctx context.Context, v []int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}, Workers(2)).Run(ctx)
+	if err != context.DeadlineExceeded || time.Since(begin) > time.Second {
+		t.Fatalf("ERROR: Run returns %v after %v", err, time.Since(begin))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sink := func(ctx context.Context, v int) error { return nil }
+	noSink := New()
+	Batch(Source(noSink, "source", numbers(1)), "batch", 1, 0)
+	branch := New()
+	source := Source(branch, "source", numbers(1))
+	Sink(source, "sink", sink)
+	Sink(source, "sink2", sink)
+	twoSources := New()
+	Source(twoSources, "source", numbers(1))
+	Sink(Source(twoSources, "source2", numbers(1)), "sink", sink)
+	for name, p := range map[string]*Pipeline{
+		"empty":       New(),
+		"no sink":     noSink,
+		"branch":      branch,
+		"two sources": twoSources,
+		"duplicate":   Sink(Source(New(), "stage", numbers(1)), "stage", sink),
+	} {
+		if err := p.Run(context.Background()); err == nil {
+			t.Fatalf("ERROR: The %s pipeline is run!", name)
+		}
+	}
+}
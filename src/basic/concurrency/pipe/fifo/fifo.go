@@ -0,0 +1,193 @@
+package fifo
+
+import (
+	"basic/concurrency/pipe/pipes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+命名管道（FIFO）。
+pipe.go中的fileBasedPipe使用的os.Pipe是匿名管道，只能在创建它的进程和它的子进程之间使用。命名管道在文件系统中有一个路径，
+没有亲缘关系的进程也可以通过这个路径打开它进行通讯。
+打开命名管道会阻塞：只读打开要等待有进程以写方式打开它，反之亦然。OpenReader和OpenWriter在另一个Goroutine中打开，
+ctx被取消时以O_NONBLOCK的方式打开另一端来解除阻塞（非阻塞的只读打开总是成功，非阻塞的只写打开在已经有读取者时成功），然后返回ctx的错误。
+打开之后的文件由Go的运行时轮询，所以可以使用SetReadDeadline和SetWriteDeadline。
+*/
+
+// 创建命名管道。path已经是命名管道时不返回错误，已经是其它类型的文件时返回错误
+func Create(path string, perm os.FileMode) error {
+	err := syscall.Mkfifo(path, uint32(perm.Perm()))
+	if err == nil {
+		return nil
+	}
+	if err != syscall.EEXIST {
+		return &os.PathError{Op: "mkfifo", Path: path, Err: err}
+	}
+	if !IsFIFO(path) {
+		return fmt.Errorf("fifo: %s exists and is not a named pipe", path)
+	}
+	return nil
+}
+
+// path是否是命名管道
+func IsFIFO(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// 删除命名管道，path不存在时不返回错误，path不是命名管道时不删除它并返回错误
+func Remove(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("fifo: %s is not a named pipe", path)
+	}
+	return os.Remove(path)
+}
+
+// 以只读方式打开命名管道，等待有进程以写方式打开它
+func OpenReader(ctx context.Context, path string) (*os.File, error) {
+	return open(ctx, path, os.O_RDONLY, os.O_WRONLY)
+}
+
+// 以只写方式打开命名管道，等待有进程以读方式打开它
+func OpenWriter(ctx context.Context, path string) (*os.File, error) {
+	return open(ctx, path, os.O_WRONLY, os.O_RDONLY)
+}
+
+// 解除阻塞的重试间隔：只写打开在读取者进入open之前会失败（ENXIO），此时需要重试
+const unblockInterval = 10 * time.Millisecond
+
+func open(ctx context.Context, path string, flag, peerFlag int) (*os.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !IsFIFO(path) {
+		return nil, fmt.Errorf("fifo: %s is not a named pipe", path)
+	}
+	type result struct {
+		f   *os.File
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		f, err := os.OpenFile(path, flag, 0)
+		opened <- result{f, err}
+	}()
+	select {
+	case r := <-opened:
+		return r.f, r.err
+	case <-ctx.Done():
+	}
+	ticker := time.NewTicker(unblockInterval)
+	defer ticker.Stop()
+	for {
+		if peer, err := os.OpenFile(path, peerFlag|syscall.O_NONBLOCK, 0); err == nil {
+			peer.Close()
+		}
+		select {
+		case r := <-opened:
+			// 即使在此期间真正的另一端到来了，也按照取消处理
+			if r.f != nil {
+				r.f.Close()
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+/**
+以PIPE_BUF为单位的写入是原子的：多个进程同时写入同一个命名管道时，不超过这个大小的写入不会被其它进程的写入打断。
+Linux上它是4096字节
+*/
+const AtomicWriteSize = 4096
+
+// 多个进程同时写入时消息的最大长度，消息和8个字节的帧头一起写入，也是Writer默认允许的消息的最大长度
+const MaxAtomicMessage = AtomicWriteSize - 8
+
+// 只有一个写入者时消息的最大长度，也是Reader允许的消息的最大长度
+const MaxMessageSize = pipes.DefaultMaxFrameSize
+
+// Writer的可选项
+type WriterOption func(w *Writer)
+
+/**
+声明当前Writer是命名管道唯一的写入者，此时消息的最大长度是MaxMessageSize。超过PIPE_BUF的写入不是原子的，
+如果实际上还有其它的写入者，它们的消息会与当前Writer的消息互相穿插，读取端会读到损坏的消息
+*/
+func SingleWriter() WriterOption {
+	return func(w *Writer) {
+		w.maxSize = MaxMessageSize
+	}
+}
+
+// 消息写入端
+type Writer struct {
+	maxSize int
+	stream  io.WriteCloser
+}
+
+/**
+创建一个向w写入消息的Writer，消息的格式与pipes.Mux的帧相同，流ID是当前进程的ID，所以读取端知道每条消息来自哪个进程。
+空的消息不会被写入。默认情况下消息不能超过MaxAtomicMessage，这样多个进程同时写入时每条消息都是完整的，参见SingleWriter
+*/
+func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
+	fw := &Writer{maxSize: MaxAtomicMessage}
+	for _, opt := range opts {
+		opt(fw)
+	}
+	fw.stream = pipes.NewMux(w, fw.maxSize).Stream(uint32(os.Getpid()))
+	return fw
+}
+
+// 写入一条消息，它是并发安全的。消息超过最大长度（MaxAtomicMessage，参见SingleWriter）时返回pipes.ErrFrameTooLarge
+func (w *Writer) WriteMessage(msg []byte) error {
+	_, err := w.stream.Write(msg)
+	return err
+}
+
+// 消息读取端
+type Reader struct {
+	mu    sync.Mutex
+	demux *pipes.Demux
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{demux: pipes.NewDemux(r, MaxMessageSize)}
+}
+
+// 一条消息
+type Message struct {
+	// 发送消息的进程的ID
+	PID  int
+	Data []byte
+}
+
+// 读取下一条消息。所有的写入端都关闭之后返回io.EOF，在消息的中间关闭时返回io.ErrUnexpectedEOF
+func (r *Reader) ReadMessage() (Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		f, err := r.demux.ReadFrame()
+		if err != nil {
+			return Message{}, err
+		}
+		// 流结束的帧不是消息
+		if f.EOF {
+			continue
+		}
+		return Message{PID: int(f.Stream), Data: f.Data}, nil
+	}
+}
@@ -0,0 +1,326 @@
+package fifo
+
+import (
+	"basic/concurrency/pipe/pipes"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+子进程是重新执行的测试程序本身，环境变量FIFO_TEST_ROLE决定它的角色，FIFO_TEST_PATH是命名管道的路径：
+writer写入FIFO_TEST_COUNT条消息，reader把读到的消息逐行输出到标准输出
+*/
+func TestMain(m *testing.M) {
+	switch os.Getenv("FIFO_TEST_ROLE") {
+	case "writer":
+		os.Exit(runWriter(os.Getenv("FIFO_TEST_PATH")))
+	case "reader":
+		os.Exit(runReader(os.Getenv("FIFO_TEST_PATH")))
+	}
+	os.Exit(m.Run())
+}
+
+func runWriter(path string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, err := OpenWriter(ctx, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	count, _ := strconv.Atoi(os.Getenv("FIFO_TEST_COUNT"))
+	w := NewWriter(f)
+	for i := 0; i < count; i++ {
+		if err := w.WriteMessage(message(os.Getpid(), i)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func runReader(path string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, err := OpenReader(ctx, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	r := NewReader(f)
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("%d %s\n", msg.PID, msg.Data)
+	}
+}
+
+// 第i条消息，长度接近MaxAtomicMessage，以便检查并发写入的原子性
+func message(pid, i int) []byte {
+	prefix := fmt.Sprintf("message %d from %d ", i, pid)
+	return append([]byte(prefix), bytes.Repeat([]byte{byte('a' + i%26)}, MaxAtomicMessage-len(prefix))...)
+}
+
+func child(role, path string, count int) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), "FIFO_TEST_ROLE="+role, "FIFO_TEST_PATH="+path, "FIFO_TEST_COUNT="+strconv.Itoa(count))
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func makeFIFO(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "test.fifo")
+	if err := Create(path, 0600); err != nil {
+		t.Fatalf("Create Error: %s", err)
+	}
+	t.Cleanup(func() { Remove(path) })
+	return path
+}
+
+// 打开path的一个读取端和n个写入端
+func openPipe(t *testing.T, path string, n int) (*os.File, []*os.File) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	opened := make(chan *os.File, 1)
+	go func() {
+		f, err := OpenReader(ctx, path)
+		if err != nil {
+			t.Errorf("OpenReader Error: %s", err)
+		}
+		opened <- f
+	}()
+	writers := make([]*os.File, n)
+	for i := range writers {
+		f, err := OpenWriter(ctx, path)
+		if err != nil {
+			t.Fatalf("OpenWriter Error: %s", err)
+		}
+		t.Cleanup(func() { f.Close() })
+		writers[i] = f
+	}
+	r := <-opened
+	if r == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { r.Close() })
+	r.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return r, writers
+}
+
+// 多个子进程同时写入同一个命名管道，每条消息都是完整的
+func TestChildWriters(t *testing.T) {
+	path := makeFIFO(t)
+	// 测试进程自己也打开一个写入端，直到所有的子进程都退出之后才关闭它，
+	// 否则一个子进程在另一个子进程打开之前就写完并关闭时，读取端会过早地读到EOF
+	f, keepers := openPipe(t, path, 1)
+	keeper := keepers[0]
+
+	const writers, count = 3, 50
+	cmds := make([]*exec.Cmd, writers)
+	for i := range cmds {
+		cmds[i] = child("writer", path, count)
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := make(chan error, writers)
+	go func() {
+		for _, cmd := range cmds {
+			if err := cmd.Wait(); err != nil {
+				failed <- err
+			}
+		}
+		keeper.Close()
+	}()
+
+	next := make(map[int]int)
+	r := NewReader(f)
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadMessage Error: %s", err)
+		}
+		if expected := message(msg.PID, next[msg.PID]); !bytes.Equal(msg.Data, expected) {
+			t.Fatalf("ERROR: The message from %d is corrupted: %.40q...", msg.PID, msg.Data)
+		}
+		next[msg.PID]++
+	}
+	select {
+	case err := <-failed:
+		t.Fatalf("ERROR: The writer fails: %s", err)
+	default:
+	}
+	for _, cmd := range cmds {
+		if n := next[cmd.Process.Pid]; n != count {
+			t.Fatalf("ERROR: %d messages from %d are read", n, cmd.Process.Pid)
+		}
+	}
+}
+
+func TestChildReader(t *testing.T) {
+	path := makeFIFO(t)
+	cmd := child("reader", path, 0)
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, err := OpenWriter(ctx, path)
+	if err != nil {
+		t.Fatalf("OpenWriter Error: %s", err)
+	}
+	w := NewWriter(f)
+	for _, s := range []string{"hello", "named", "pipe"} {
+		if err := w.WriteMessage([]byte(s)); err != nil {
+			t.Fatalf("WriteMessage Error: %s", err)
+		}
+	}
+	f.Close()
+	wg.Wait()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("ERROR: The reader fails: %s", err)
+	}
+	prefix := strconv.Itoa(os.Getpid()) + " "
+	if got := strings.Join(lines, ","); got != prefix+"hello,"+prefix+"named,"+prefix+"pipe" {
+		t.Fatalf("ERROR: The reader reads %s", got)
+	}
+}
+
+// 两个写入端同时写入时，超过MaxAtomicMessage的消息被拒绝，其它的消息都是完整的
+func TestOversizeMessages(t *testing.T) {
+	r, files := openPipe(t, makeFIFO(t), 2)
+	const count = 50
+	var wg sync.WaitGroup
+	for i, f := range files {
+		wg.Add(1)
+		go func(fill byte, f *os.File) {
+			defer wg.Done()
+			defer f.Close()
+			w := NewWriter(f)
+			for j := 0; j < count; j++ {
+				if err := w.WriteMessage(bytes.Repeat([]byte{fill}, MaxAtomicMessage+1)); err != pipes.ErrFrameTooLarge {
+					t.Errorf("ERROR: Writing an oversize message returns %v", err)
+					return
+				}
+				if err := w.WriteMessage(bytes.Repeat([]byte{fill}, MaxAtomicMessage)); err != nil {
+					t.Errorf("WriteMessage Error: %s", err)
+					return
+				}
+			}
+		}(byte('a'+i), f)
+	}
+
+	read := make(map[byte]int)
+	reader := NewReader(r)
+	for {
+		msg, err := reader.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadMessage Error: %s", err)
+		}
+		if len(msg.Data) != MaxAtomicMessage || !bytes.Equal(msg.Data, bytes.Repeat(msg.Data[:1], len(msg.Data))) {
+			t.Fatalf("ERROR: The message is corrupted: %d bytes, %.40q...", len(msg.Data), msg.Data)
+		}
+		read[msg.Data[0]]++
+	}
+	wg.Wait()
+	if read['a'] != count || read['b'] != count {
+		t.Fatalf("ERROR: The messages read are %v", read)
+	}
+}
+
+// 唯一的写入者可以写入超过MaxAtomicMessage的消息
+func TestSingleWriter(t *testing.T) {
+	r, files := openPipe(t, makeFIFO(t), 1)
+	large := bytes.Repeat([]byte("large message "), MaxAtomicMessage)
+	go func() {
+		defer files[0].Close()
+		if err := NewWriter(files[0], SingleWriter()).WriteMessage(large); err != nil {
+			t.Errorf("WriteMessage Error: %s", err)
+		}
+	}()
+	msg, err := NewReader(r).ReadMessage()
+	if err != nil || !bytes.Equal(msg.Data, large) {
+		t.Fatalf("ERROR: The message is (%d bytes, %v)", len(msg.Data), err)
+	}
+}
+
+// 没有另一端时，打开在ctx被取消之后返回
+func TestOpenCancel(t *testing.T) {
+	path := makeFIFO(t)
+	for name, open := range map[string]func(context.Context, string) (*os.File, error){
+		"reader": OpenReader,
+		"writer": OpenWriter,
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		begin := time.Now()
+		f, err := open(ctx, path)
+		cancel()
+		if f != nil || err != context.DeadlineExceeded || time.Since(begin) > time.Second {
+			t.Fatalf("ERROR: The %s returns %v after %v", name, err, time.Since(begin))
+		}
+	}
+}
+
+func TestCreateRemove(t *testing.T) {
+	path := makeFIFO(t)
+	if err := Create(path, 0600); err != nil {
+		t.Fatalf("ERROR: Creating an existing FIFO returns %s", err)
+	}
+	regular := filepath.Join(t.TempDir(), "regular")
+	os.WriteFile(regular, []byte("data"), 0644)
+	if err := Create(regular, 0600); err == nil {
+		t.Fatal("ERROR: A regular file is treated as a FIFO!")
+	}
+	if err := Remove(regular); err == nil {
+		t.Fatal("ERROR: Removing a regular file returns no error!")
+	}
+	if _, err := os.Stat(regular); err != nil {
+		t.Fatal("ERROR: A regular file is removed!")
+	}
+	if _, err := OpenReader(context.Background(), regular); err == nil {
+		t.Fatal("ERROR: A regular file is opened!")
+	}
+	if err := Remove(path); err != nil || IsFIFO(path) {
+		t.Fatalf("ERROR: Remove returns %v", err)
+	}
+	if err := Remove(path); err != nil {
+		t.Fatalf("ERROR: Removing a missing FIFO returns %s", err)
+	}
+}
@@ -0,0 +1,332 @@
+package linecmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/**
+逐行处理命令的输出。
+pipe.go中的第一个例子用bufio.Reader.ReadLine只读了一行，并且忽略了它的第二个返回值isPrefix：一行比缓冲区长时，ReadLine只返回它的前一部分。
+Run分别读取命令的标准输出和标准错误，把每一行交给回调函数；比MaxLineSize长的行被拆分成多段，除了最后一段之外的每一段的More为true，
+回调函数可以选择拼接、截断或者直接使用它们。输出还可以原样写入文件，并且可以限制输出的总量以免失控的命令耗尽内存或者磁盘。
+*/
+
+// 输出的一行或者超长的行的一段，不包含行尾的"\n"或者"\r\n"
+type Line struct {
+	Text string
+	// 这一行还没有结束，下一段是它的后续部分
+	More bool
+}
+
+const (
+	DefaultMaxLineSize = 64 << 10
+	DefaultTailLines   = 20
+)
+
+// 输出的总量超过了WithMaxOutput的限制，此时命令已经被杀死了
+var ErrOutputLimit = errors.New("linecmd: output limit exceeded")
+
+// 命令的运行结果
+type Result struct {
+	// 退出码，命令没有启动或者被信号终止时是-1
+	ExitCode int
+	// 从启动到退出的时间
+	Duration time.Duration
+	// 标准输出和标准错误的字节数，超过输出的限制时只计算限制以内的部分
+	StdoutBytes int64
+	StderrBytes int64
+	// 标准输出和标准错误的最后几行（超长的行的每一段算作一行）
+	StdoutTail []string
+	StderrTail []string
+}
+
+type options struct {
+	onStdout    func(Line)
+	onStderr    func(Line)
+	teeStdout   io.Writer
+	teeStderr   io.Writer
+	stdoutFile  string
+	stderrFile  string
+	maxLineSize int
+	maxOutput   int64
+	tailLines   int
+}
+
+// Run的可选项
+type Option func(o *options)
+
+// 设置标准输出的每一行的回调函数，它在读取标准输出的Goroutine中被依次调用
+func OnStdout(fn func(Line)) Option {
+	return func(o *options) {
+		o.onStdout = fn
+	}
+}
+
+// 设置标准错误的每一行的回调函数，它在读取标准错误的Goroutine中被依次调用，与标准输出的回调函数是并发的
+func OnStderr(fn func(Line)) Option {
+	return func(o *options) {
+		o.onStderr = fn
+	}
+}
+
+// 把标准输出和标准错误原样写入给定的Writer，为nil的不写入
+func WithTee(stdout, stderr io.Writer) Option {
+	return func(o *options) {
+		o.teeStdout, o.teeStderr = stdout, stderr
+	}
+}
+
+// 把标准输出和标准错误原样写入给定的文件，文件已经存在时被覆盖，为空的路径不写入
+func WithTeeFiles(stdoutPath, stderrPath string) Option {
+	return func(o *options) {
+		o.stdoutFile, o.stderrFile = stdoutPath, stderrPath
+	}
+}
+
+// 设置一段的最大长度，默认是DefaultMaxLineSize
+func WithMaxLineSize(n int) Option {
+	return func(o *options) {
+		o.maxLineSize = n
+	}
+}
+
+// 限制标准输出和标准错误的总字节数，超过时杀死命令并返回ErrOutputLimit，默认不限制
+func WithMaxOutput(n int64) Option {
+	return func(o *options) {
+		o.maxOutput = n
+	}
+}
+
+// 设置Result中保留的最后几行的行数，默认是DefaultTailLines
+func WithTailLines(n int) Option {
+	return func(o *options) {
+		o.tailLines = n
+	}
+}
+
+/**
+运行命令并等待它退出，cmd的Stdout和Stderr必须为nil。
+返回的Result总是不为nil。命令以非0的退出码退出时错误是*exec.ExitError；ctx被取消时命令被杀死，错误是ctx的错误；
+输出超过限制时命令被杀死，错误是ErrOutputLimit。
+命令在它自己的进程组中运行，杀死命令时杀死整个进程组：命令启动的子进程（比如sh -c中的sleep）继承了输出管道，
+只杀死命令本身时管道要等到子进程都退出才会关闭，Run也要等到那时才能返回。自己离开了进程组的子进程（比如调用了setsid）不会被杀死。
+*/
+func Run(ctx context.Context, cmd *exec.Cmd, opts ...Option) (*Result, error) {
+	o := &options{maxLineSize: DefaultMaxLineSize, tailLines: DefaultTailLines}
+	for _, opt := range opts {
+		opt(o)
+	}
+	result := &Result{ExitCode: -1}
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return result, errors.New("linecmd: Stdout or Stderr already set")
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, tee := range []struct {
+		path string
+		w    *io.Writer
+	}{{o.stdoutFile, &o.teeStdout}, {o.stderrFile, &o.teeStderr}} {
+		if tee.path == "" {
+			continue
+		}
+		f, err := os.Create(tee.path)
+		if err != nil {
+			return result, err
+		}
+		files = append(files, f)
+		if *tee.w != nil {
+			*tee.w = io.MultiWriter(*tee.w, f)
+		} else {
+			*tee.w = f
+		}
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return result, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return result, err
+	}
+	setpgid(cmd)
+	begin := time.Now()
+	if err := cmd.Start(); err != nil {
+		return result, err
+	}
+
+	// 超过输出的限制或者ctx被取消时杀死命令的进程组
+	var limited atomic.Bool
+	var killOnce sync.Once
+	kill := func() { killOnce.Do(func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }) }
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill()
+		case <-done:
+		}
+	}()
+	counter := &counter{max: o.maxOutput, exceeded: func() {
+		limited.Store(true)
+		kill()
+	}}
+	outStream := &stream{onLine: o.onStdout, tee: o.teeStdout, tail: newTail(o.tailLines)}
+	errStream := &stream{onLine: o.onStderr, tee: o.teeStderr, tail: newTail(o.tailLines)}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		result.StdoutBytes = outStream.read(stdout, counter, o.maxLineSize)
+	}()
+	go func() {
+		defer wg.Done()
+		result.StderrBytes = errStream.read(stderr, counter, o.maxLineSize)
+	}()
+	// 必须在读完输出之后才能调用Wait，因为Wait会关闭管道
+	wg.Wait()
+	err = cmd.Wait()
+	result.Duration = time.Since(begin)
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	result.StdoutTail = outStream.tail.lines()
+	result.StderrTail = errStream.tail.lines()
+	switch {
+	case limited.Load():
+		err = ErrOutputLimit
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+// 让命令成为一个新的进程组的组长，进程组ID等于它的PID。不修改调用方的SysProcAttr
+func setpgid(cmd *exec.Cmd) {
+	attr := &syscall.SysProcAttr{}
+	if cmd.SysProcAttr != nil {
+		*attr = *cmd.SysProcAttr
+	}
+	// 新的会话的首进程也是一个新的进程组的组长
+	if !attr.Setsid {
+		attr.Setpgid, attr.Pgid = true, 0
+	}
+	cmd.SysProcAttr = attr
+}
+
+type stream struct {
+	onLine func(Line)
+	tee    io.Writer
+	tail   *tail
+}
+
+// 逐行读取r直到EOF或者超过输出的限制，返回读取的字节数
+func (s *stream) read(r io.Reader, c *counter, maxLineSize int) int64 {
+	cr := &countingReader{r: r, c: c}
+	var src io.Reader = cr
+	if s.tee != nil {
+		src = io.TeeReader(cr, s.tee)
+	}
+	br := bufio.NewReaderSize(src, maxLineSize)
+	for {
+		// isPrefix为true时这一行比缓冲区长，line只是它的一部分，下一次调用返回后续的部分
+		// ReadLine不会同时返回数据和错误，最后一行没有换行符时也会被返回
+		line, isPrefix, err := br.ReadLine()
+		if err != nil {
+			break
+		}
+		s.tail.add(string(line))
+		if s.onLine != nil {
+			s.onLine(Line{Text: string(line), More: isPrefix})
+		}
+	}
+	// 超过限制之后继续读取并丢弃剩下的输出，以免命令在被杀死之前阻塞在写入上
+	io.Copy(io.Discard, r)
+	return cr.n
+}
+
+// 标准输出和标准错误共用的字节计数器
+type counter struct {
+	max      int64
+	total    atomic.Int64
+	exceeded func()
+}
+
+// 读取了n个字节之后调用，返回其中没有超过限制的字节数，超过限制时调用exceeded
+func (c *counter) take(n int) int {
+	if c.max <= 0 {
+		return n
+	}
+	total := c.total.Add(int64(n))
+	if total <= c.max {
+		return n
+	}
+	c.exceeded()
+	allowed := c.max - (total - int64(n))
+	if allowed < 0 {
+		allowed = 0
+	}
+	return int(allowed)
+}
+
+type countingReader struct {
+	r io.Reader
+	c *counter
+	n int64
+	// 已经超过了限制
+	done bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	n, err := cr.r.Read(p)
+	if allowed := cr.c.take(n); allowed < n {
+		n, err, cr.done = allowed, nil, true
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+	cr.n += int64(n)
+	return n, err
+}
+
+// 保留最后max行的环形缓冲区
+type tail struct {
+	max   int
+	buf   []string
+	start int
+}
+
+func newTail(max int) *tail {
+	return &tail{max: max}
+}
+
+func (t *tail) add(line string) {
+	if t.max <= 0 {
+		return
+	}
+	if len(t.buf) < t.max {
+		t.buf = append(t.buf, line)
+		return
+	}
+	t.buf[t.start] = line
+	t.start = (t.start + 1) % t.max
+}
+
+func (t *tail) lines() []string {
+	return append(append([]string(nil), t.buf[t.start:]...), t.buf[:t.start]...)
+}
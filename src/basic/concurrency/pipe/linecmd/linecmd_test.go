@@ -0,0 +1,126 @@
+package linecmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 并发安全地记录回调函数收到的行
+type recorder struct {
+	mu    sync.Mutex
+	lines []Line
+}
+
+func (r *recorder) add(l Line) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, l)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	var stdout, stderr recorder
+	var tee bytes.Buffer
+	cmd := exec.Command("sh", "-c", `printf 'first\nsecond\r\n\nno newline'; echo oops >&2; exit 3`)
+	result, err := Run(context.Background(), cmd,
+		OnStdout(stdout.add), OnStderr(stderr.add),
+		WithTee(&tee, nil), WithTeeFiles("", filepath.Join(dir, "stderr.log")),
+		WithTailLines(2))
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || result.ExitCode != 3 {
+		t.Fatalf("ERROR: Run returns %v, exit code %d", err, result.ExitCode)
+	}
+	expected := []Line{{Text: "first"}, {Text: "second"}, {Text: ""}, {Text: "no newline"}}
+	if !reflect.DeepEqual(stdout.lines, expected) {
+		t.Fatalf("ERROR: The stdout lines are %+v", stdout.lines)
+	}
+	if !reflect.DeepEqual(stderr.lines, []Line{{Text: "oops"}}) {
+		t.Fatalf("ERROR: The stderr lines are %+v", stderr.lines)
+	}
+	if tee.String() != "first\nsecond\r\n\nno newline" || result.StdoutBytes != int64(tee.Len()) || result.StderrBytes != 5 {
+		t.Fatalf("ERROR: The tee is %q, the result is %+v", tee.String(), result)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "stderr.log")); string(data) != "oops\n" {
+		t.Fatalf("ERROR: The stderr file is %q", data)
+	}
+	if !reflect.DeepEqual(result.StdoutTail, []string{"", "no newline"}) || !reflect.DeepEqual(result.StderrTail, []string{"oops"}) {
+		t.Fatalf("ERROR: The tails are %q and %q", result.StdoutTail, result.StderrTail)
+	}
+	if result.Duration <= 0 {
+		t.Fatalf("ERROR: The duration is %v", result.Duration)
+	}
+}
+
+// 超长的行被拆分成多段，除了最后一段之外的每一段的More为true
+func TestLongLine(t *testing.T) {
+	var stdout recorder
+	cmd := exec.Command("sh", "-c", `printf '%040d\nshort\n' 0`)
+	if _, err := Run(context.Background(), cmd, OnStdout(stdout.add), WithMaxLineSize(16)); err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	zeros := strings.Repeat("0", 16)
+	expected := []Line{{zeros, true}, {zeros, true}, {"00000000", false}, {"short", false}}
+	if !reflect.DeepEqual(stdout.lines, expected) {
+		t.Fatalf("ERROR: The lines are %v", stdout.lines)
+	}
+}
+
+// 输出超过限制时命令被杀死，回调函数和Writer只收到限制以内的部分
+func TestMaxOutput(t *testing.T) {
+	var tee bytes.Buffer
+	cmd := exec.Command("sh", "-c", `while true; do echo 0123456789; done`)
+	begin := time.Now()
+	result, err := Run(context.Background(), cmd, WithMaxOutput(25), WithTee(&tee, nil))
+	if err != ErrOutputLimit || time.Since(begin) > 5*time.Second {
+		t.Fatalf("ERROR: Run returns %v after %v", err, time.Since(begin))
+	}
+	if tee.String() != "0123456789\n0123456789\n012" || result.StdoutBytes != 25 {
+		t.Fatalf("ERROR: The output is %q, %d bytes", tee.String(), result.StdoutBytes)
+	}
+	if !reflect.DeepEqual(result.StdoutTail, []string{"0123456789", "0123456789", "012"}) || result.ExitCode != -1 {
+		t.Fatalf("ERROR: The result is %+v", result)
+	}
+	// 产生输出的是sh的子进程，它也要被杀死
+	begin = time.Now()
+	_, err = Run(context.Background(), exec.Command("sh", "-c", "yes & sleep 3"), WithMaxOutput(1000))
+	if err != ErrOutputLimit || time.Since(begin) > time.Second {
+		t.Fatalf("ERROR: Run returns %v after %v", err, time.Since(begin))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err := Run(ctx, exec.Command("sleep", "30"))
+	if err != context.DeadlineExceeded || result.Duration > 5*time.Second {
+		t.Fatalf("ERROR: Run returns %v after %v", err, result.Duration)
+	}
+
+	// 只杀死sh时sleep不会退出，它继承的输出管道也不会关闭，所以必须杀死整个进程组
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err = Run(ctx, exec.Command("sh", "-c", "sleep 3; echo done"))
+	if err != context.DeadlineExceeded || time.Since(begin) > time.Second {
+		t.Fatalf("ERROR: Run returns %v after %v", err, time.Since(begin))
+	}
+
+	result, err = Run(context.Background(), exec.Command("/no/such/command"))
+	if err == nil || result.ExitCode != -1 {
+		t.Fatalf("ERROR: Run returns %v, exit code %d", err, result.ExitCode)
+	}
+	cmd := exec.Command("true")
+	cmd.Stdout = os.Stdout
+	if _, err := Run(context.Background(), cmd); err == nil {
+		t.Fatal("ERROR: A command with Stdout is run!")
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"basic/concurrency/pipe/fifo"
+	"basic/concurrency/pipe/linecmd"
+	"basic/concurrency/pipe/pipes"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -55,6 +59,8 @@ func main() {
 		return
 	}
 	fmt.Printf("%s\n", string(outoutO))
+	// 这里只读了一行，也没有处理isPrefix，逐行读取全部输出参见lineCallbackDemo
+	lineCallbackDemo()
 
 	fmt.Println("-----------------------------")
 
@@ -99,21 +105,43 @@ func main() {
 	// fmt.Printf("%s\n", outputBuf2.Bytes())
 
 	// fileBasedPipe()
+	namedPipeDemo()
 	inMemorySyncPipe()
+	bufferedPipeDemo()
+}
+
+/**
+使用linecmd包逐行读取命令的标准输出和标准错误。超过16个字节的行被拆分成多段，More为true表示这一行还没有结束
+*/
+func lineCallbackDemo() {
+	cmd := exec.Command("sh", "-c", "echo 'My second command from golang.'; echo short; echo 'a warning' >&2")
+	result, err := linecmd.Run(context.Background(), cmd,
+		linecmd.OnStdout(func(line linecmd.Line) {
+			fmt.Printf("stdout: %q (more: %v)\n", line.Text, line.More)
+		}),
+		linecmd.OnStderr(func(line linecmd.Line) {
+			fmt.Printf("stderr: %q\n", line.Text)
+		}),
+		linecmd.WithMaxLineSize(16))
+	if err != nil {
+		fmt.Printf("Error: The command failed: %s\n", err)
+	}
+	fmt.Printf("Exit code: %d, duration: %v, stdout: %d byte(s), stderr: %d byte(s)\n",
+		result.ExitCode, result.Duration, result.StdoutBytes, result.StderrBytes)
 }
 
 func fileBasedPipe() {
-	// 创建命名管道，命名管道默认会在其中一端还未就绪的时候阻塞另一端的进程
+	// 创建匿名管道，它只能在当前进程和它的子进程之间使用，真正的命名管道参见namedPipeDemo
 	reader, writer, err := os.Pipe()
 	if err != nil {
-		fmt.Printf("Error: Can not create the named pipe: %s\n", err)
+		fmt.Printf("Error: Can not create the file-based pipe: %s\n", err)
 	}
 	go func() {
 		output := make([]byte, 100)
 		// 从管道读取数据
 		n, err := reader.Read(output)
 		if err != nil {
-			fmt.Printf("Error: Can not read data from the named pipe: %s\n", err)
+			fmt.Printf("Error: Can not read data from the file-based pipe: %s\n", err)
 		}
 		fmt.Printf("Read %d byte(s). [file-based pipe]\n", n)
 		fmt.Println(string(output[:n])) // ABCDEFGHIJKLMNOPQRSTUVWXYZ
@@ -126,12 +154,56 @@ func fileBasedPipe() {
 	fmt.Println(string(input)) // ABCDEFGHIJKLMNOPQRSTUVWXYZ
 	n, err := writer.Write(input)
 	if err != nil {
-		fmt.Printf("Error: Can not write data to the named pipe: %s\n", err)
+		fmt.Printf("Error: Can not write data to the file-based pipe: %s\n", err)
 	}
 	fmt.Printf("Written %d byte(s). [file-based pipe]\n", n)
 	time.Sleep(200 * time.Millisecond)
 }
 
+/**
+命名管道：在临时目录中创建一个FIFO，由一个独立的cat进程读取它，当前进程写入之后关闭写入端，cat读到EOF之后退出。
+两个进程之间只通过FIFO的路径联系
+*/
+func namedPipeDemo() {
+	dir, err := os.MkdirTemp("", "fifo")
+	if err != nil {
+		fmt.Printf("Error: Can not create the temporary directory: %s\n", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/demo.fifo"
+	if err := fifo.Create(path, 0600); err != nil {
+		fmt.Printf("Error: Can not create the named pipe: %s\n", err)
+		return
+	}
+	var output bytes.Buffer
+	cat := exec.Command("cat", path)
+	cat.Stdout = &output
+	if err := cat.Start(); err != nil {
+		fmt.Printf("Error: The command can not be startup: %s\n", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	writer, err := fifo.OpenWriter(ctx, path)
+	if err != nil {
+		fmt.Printf("Error: Can not open the named pipe: %s\n", err)
+		cat.Process.Kill()
+		cat.Wait()
+		return
+	}
+	n, err := writer.Write([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	if err != nil {
+		fmt.Printf("Error: Can not write data to the named pipe: %s\n", err)
+	}
+	writer.Close()
+	fmt.Printf("Written %d byte(s). [named pipe]\n", n)
+	if err := cat.Wait(); err != nil {
+		fmt.Printf("Error: Can not wait for the command: %s\n", err)
+	}
+	fmt.Printf("Read by cat: %s [named pipe]\n", output.String())
+}
+
 func inMemorySyncPipe() {
 	/**
 	命名管道可以被多路复用。所以， 当多个输入端同时写入数据的时候我们就不得不需要考虑操作原子性的问题。操作系统提供的管道是不提供原子操作支持的。
@@ -158,3 +230,36 @@ func inMemorySyncPipe() {
 	fmt.Printf("Written %d byte(s). [in-memory pipe]\n", n)
 	time.Sleep(200 * time.Millisecond)
 }
+
+/**
+使用pipes包中的带缓冲的管道：写入不需要等待读取，写入端关闭之后读取端读到io.EOF，所以不再需要用time.Sleep等待另一个Goroutine。
+多个写入者通过Mux共用这个管道，读取端按照帧还原每一次写入
+*/
+func bufferedPipeDemo() {
+	reader, writer := pipes.New(1024)
+	mux := pipes.NewMux(writer, 0)
+	upper, lower := mux.Stream(1), mux.Stream(2)
+	for i := 0; i < 26; i++ {
+		upper.Write([]byte{byte('A' + i)})
+		lower.Write([]byte{byte('a' + i)})
+	}
+	upper.Close()
+	lower.Close()
+	writer.Close()
+	fmt.Printf("Buffered %d byte(s). [buffered pipe]\n", reader.Buffered())
+
+	streams := make(map[uint32][]byte)
+	demux := pipes.NewDemux(reader, 0)
+	for {
+		frame, err := demux.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error: Can not read a frame from the pipe: %s\n", err)
+			return
+		}
+		streams[frame.Stream] = append(streams[frame.Stream], frame.Data...)
+	}
+	fmt.Printf("Stream 1: %s\nStream 2: %s\n", streams[1], streams[2])
+}
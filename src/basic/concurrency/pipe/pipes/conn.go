@@ -0,0 +1,55 @@
+package pipes
+
+import (
+	"net"
+	"time"
+)
+
+/**
+双向的内存连接。
+net.Pipe也返回一对net.Conn，但是它没有缓冲区，每次写入都要等待对方读取，一方先写再读的协议（比如先发送请求再等待响应的客户端）
+在对方也在写入时会死锁。Pair由两个带缓冲的管道组成，一个用于每个方向，所以它的行为更接近于真正的TCP连接。
+*/
+
+// 内存连接的地址
+type Addr string
+
+func (a Addr) Network() string { return "pipe" }
+func (a Addr) String() string  { return string(a) }
+
+type conn struct {
+	r      *Reader
+	w      *Writer
+	local  Addr
+	remote Addr
+}
+
+/**
+创建一对互相连接的net.Conn，每个方向的缓冲区的容量都是capacity字节。
+一端关闭之后，另一端读完已经收到的数据之后读到io.EOF，写入返回io.ErrClosedPipe
+*/
+func Pair(capacity int) (net.Conn, net.Conn) {
+	r1, w1 := New(capacity)
+	r2, w2 := New(capacity)
+	return &conn{r: r1, w: w2, local: "pipe-a", remote: "pipe-b"},
+		&conn{r: r2, w: w1, local: "pipe-b", remote: "pipe-a"}
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.w.Write(b) }
+
+func (c *conn) Close() error {
+	c.r.Close()
+	return c.w.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.r.SetReadDeadline(t)
+	return c.w.SetWriteDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error  { return c.r.SetReadDeadline(t) }
+func (c *conn) SetWriteDeadline(t time.Time) error { return c.w.SetWriteDeadline(t) }
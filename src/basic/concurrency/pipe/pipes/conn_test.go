@@ -0,0 +1,71 @@
+package pipes
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var _ net.Conn = (*conn)(nil)
+
+// 两端同时先写再读，net.Pipe在这种情况下会死锁
+func TestPairWriteThenRead(t *testing.T) {
+	a, b := Pair(1024)
+	defer a.Close()
+	defer b.Close()
+	if a.LocalAddr().String() != b.RemoteAddr().String() || a.LocalAddr().Network() != "pipe" {
+		t.Fatalf("ERROR: The addresses are %s and %s", a.LocalAddr(), b.RemoteAddr())
+	}
+	done := make(chan string, 1)
+	go func() {
+		b.Write([]byte("hello from b\n"))
+		line, _ := bufio.NewReader(b).ReadString('\n')
+		done <- line
+	}()
+	a.SetDeadline(time.Now().Add(time.Second))
+	if _, err := a.Write([]byte("hello from a\n")); err != nil {
+		t.Fatalf("Write Error: %s", err)
+	}
+	line, err := bufio.NewReader(a).ReadString('\n')
+	if err != nil || line != "hello from b\n" {
+		t.Fatalf("ERROR: a reads %q, %v", line, err)
+	}
+	if line := <-done; line != "hello from a\n" {
+		t.Fatalf("ERROR: b reads %q", line)
+	}
+}
+
+func TestPairClose(t *testing.T) {
+	a, b := Pair(1024)
+	a.Write([]byte("bye"))
+	a.Close()
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != "bye" {
+		t.Fatalf("ERROR: ReadAll returns %q, %v", data, err)
+	}
+	if _, err := b.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Write returns %v after the peer is closed", err)
+	}
+	if _, err := a.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Read returns %v after Close", err)
+	}
+}
+
+func TestPairDeadline(t *testing.T) {
+	a, b := Pair(4)
+	defer a.Close()
+	defer b.Close()
+	a.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ERROR: Read returns %v", err)
+	}
+	// 对方不读取时，写满缓冲区之后超时
+	a.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := a.Write([]byte("abcdef")); n != 4 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ERROR: Write returns %d, %v", n, err)
+	}
+}
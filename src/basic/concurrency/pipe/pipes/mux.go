@@ -0,0 +1,151 @@
+package pipes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/**
+多路复用。
+多个写入者直接共用一个管道时，它们的数据会交错在一起，读取端无法区分。Mux把每个流的每次写入封装成一个帧，
+帧头是8个字节：大端序的流ID和数据长度，各4个字节，长度为0的帧表示这个流结束了。一个帧在持有锁时一次写完，
+所以帧不会被其它的写入者打断；Demux在另一端按照帧读取，每次读到的正好是一次写入的数据。
+*/
+
+// 帧的数据的默认最大长度
+const DefaultMaxFrameSize = 1 << 20
+
+const frameHeaderSize = 8
+
+// 写入或者读到的帧超过了最大长度
+var ErrFrameTooLarge = errors.New("pipes: frame too large")
+
+// 多路复用的写入端
+type Mux struct {
+	maxFrameSize int
+
+	mu sync.Mutex
+	w  io.Writer
+	// 写入失败之后帧的边界已经被破坏了，之后的写入都返回这个错误
+	err error
+}
+
+// 创建一个把帧写入w的Mux，maxFrameSize不大于0时使用DefaultMaxFrameSize
+func NewMux(w io.Writer, maxFrameSize int) *Mux {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Mux{w: w, maxFrameSize: maxFrameSize}
+}
+
+// 返回流id的写入端，每次Write写入一个帧，Close写入流结束的帧。同一个流可以有多个写入端
+func (m *Mux) Stream(id uint32) io.WriteCloser {
+	return &stream{m: m, id: id}
+}
+
+func (m *Mux) writeFrame(id uint32, data []byte) error {
+	if len(data) > m.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, id)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if _, err := m.w.Write(frame); err != nil {
+		m.err = err
+		return err
+	}
+	return nil
+}
+
+type stream struct {
+	m  *Mux
+	id uint32
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// 把p作为一个帧写入，空的p不写入任何帧。与Close一样在写入帧时持有锁，否则数据帧可能会出现在流结束的帧之后
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := s.m.writeFrame(s.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.m.writeFrame(s.id, nil)
+}
+
+// 一个帧
+type Frame struct {
+	Stream uint32
+	Data   []byte
+	// 流结束的帧，此时Data为空
+	EOF bool
+}
+
+// 多路复用的读取端
+type Demux struct {
+	r            io.Reader
+	maxFrameSize int
+	header       [frameHeaderSize]byte
+}
+
+// 创建一个从r读取帧的Demux，maxFrameSize不大于0时使用DefaultMaxFrameSize
+func NewDemux(r io.Reader, maxFrameSize int) *Demux {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Demux{r: r, maxFrameSize: maxFrameSize}
+}
+
+/**
+读取下一个帧，它不是并发安全的。r在帧的边界结束时返回io.EOF，在帧的中间结束时返回io.ErrUnexpectedEOF，
+帧的长度超过最大长度时返回ErrFrameTooLarge
+*/
+func (d *Demux) ReadFrame() (Frame, error) {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{Stream: binary.BigEndian.Uint32(d.header[:])}
+	size := binary.BigEndian.Uint32(d.header[4:])
+	if size == 0 {
+		f.EOF = true
+		return f, nil
+	}
+	if uint64(size) > uint64(d.maxFrameSize) {
+		return Frame{}, fmt.Errorf("%w: %d bytes in stream %d", ErrFrameTooLarge, size, f.Stream)
+	}
+	f.Data = make([]byte, size)
+	if _, err := io.ReadFull(d.r, f.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	return f, nil
+}
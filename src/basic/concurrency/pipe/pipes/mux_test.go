@@ -0,0 +1,143 @@
+package pipes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// 多个写入者并发地写入同一个管道，读取端按照流还原每一次写入
+func TestMux(t *testing.T) {
+	r, w := New(64)
+	m := NewMux(w, 0)
+	const writers, writes = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			s := m.Stream(id)
+			defer s.Close()
+			for j := 0; j < writes; j++ {
+				// 每个帧都比管道的容量大，所以写入一定会被拆分成多次
+				msg := fmt.Sprintf("stream %d message %d %s", id, j, bytes.Repeat([]byte{'.'}, 64))
+				if _, err := s.Write([]byte(msg)); err != nil {
+					t.Errorf("Write Error: %s", err)
+					return
+				}
+			}
+		}(uint32(i))
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+
+	d := NewDemux(r, 0)
+	next := make(map[uint32]int)
+	ended := 0
+	for {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame Error: %s", err)
+		}
+		if f.EOF {
+			ended++
+			continue
+		}
+		expected := fmt.Sprintf("stream %d message %d %s", f.Stream, next[f.Stream], bytes.Repeat([]byte{'.'}, 64))
+		if string(f.Data) != expected {
+			t.Fatalf("ERROR: The frame is %q, expected %q", f.Data, expected)
+		}
+		next[f.Stream]++
+	}
+	if ended != writers || len(next) != writers {
+		t.Fatalf("ERROR: %d streams ended, %d streams seen", ended, len(next))
+	}
+	for id, n := range next {
+		if n != writes {
+			t.Fatalf("ERROR: %d frames of stream %d are read", n, id)
+		}
+	}
+}
+
+// 与Close并发的写入要么在流结束的帧之前写入，要么返回io.ErrClosedPipe
+func TestMuxWriteClose(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMux(&buf, 0)
+	const streams, writers = 20, 4
+	var wg sync.WaitGroup
+	for id := uint32(0); id < streams; id++ {
+		s := m.Stream(id)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if _, err := s.Write([]byte("data")); err != nil {
+						if err != io.ErrClosedPipe {
+							t.Errorf("Write Error: %s", err)
+						}
+						return
+					}
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+
+	d := NewDemux(&buf, 0)
+	ended := make(map[uint32]bool)
+	for {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame Error: %s", err)
+		}
+		if ended[f.Stream] {
+			t.Fatalf("ERROR: A frame of stream %d follows its EOF frame!", f.Stream)
+		}
+		ended[f.Stream] = f.EOF
+	}
+	if len(ended) != streams {
+		t.Fatalf("ERROR: %d streams ended", len(ended))
+	}
+}
+
+func TestMuxErrors(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMux(&buf, 4)
+	s := m.Stream(7)
+	if _, err := s.Write([]byte("too long")); err != ErrFrameTooLarge {
+		t.Fatalf("ERROR: Write returns %v", err)
+	}
+	if n, err := s.Write(nil); n != 0 || err != nil || buf.Len() != 0 {
+		t.Fatal("ERROR: An empty write produces a frame!")
+	}
+	s.Write([]byte("abcd"))
+	s.Close()
+	if _, err := s.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Write returns %v after Close", err)
+	}
+
+	frames := buf.Bytes()
+	if _, err := NewDemux(bytes.NewReader(frames), 2).ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("ERROR: ReadFrame returns %v", err)
+	}
+	if _, err := NewDemux(bytes.NewReader(frames[:10]), 0).ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ERROR: ReadFrame returns %v for a truncated frame", err)
+	}
+}
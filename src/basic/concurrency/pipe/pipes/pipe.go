@@ -0,0 +1,273 @@
+package pipes
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+带缓冲的内存管道。
+io.Pipe没有缓冲区，每次写入都要等待读取端把数据全部读走，所以pipe.go中的inMemorySyncPipe只能在另一个Goroutine中读取。
+这里的管道有一个容量固定的缓冲区：缓冲区没有满时写入立即返回，缓冲区满了之后写入才会等待读取，缓冲区为空时读取等待写入。
+读取端和写入端都支持截止时间，超过截止时间的读写返回os.ErrDeadlineExceeded，它满足net.Error并且Timeout()返回true，
+所以管道可以在测试中代替网络连接（参见Pair）。
+*/
+
+// 管道的默认容量
+const DefaultCapacity = 64 << 10
+
+type pipe struct {
+	// 在整个Write的过程中持有，大于缓冲区的写入被分成多次放入缓冲区，并发的写入不能交错在一起
+	wmu      sync.Mutex
+	mu       sync.Mutex
+	capacity int
+	buf      []byte
+	// 读取端关闭之后，写入返回rerr
+	rclosed bool
+	rerr    error
+	// 写入端关闭之后，读完缓冲区中的数据的读取返回werr
+	wclosed bool
+	werr    error
+	// 缓冲区或者关闭状态变化时被关闭并替换，等待的一方通过它被唤醒
+	changed chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+// 管道的读取端
+type Reader struct {
+	p *pipe
+}
+
+// 管道的写入端
+type Writer struct {
+	p *pipe
+}
+
+// 创建一个容量为capacity字节的管道，capacity不大于0时使用DefaultCapacity
+func New(capacity int) (*Reader, *Writer) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	p := &pipe{
+		capacity:      capacity,
+		changed:       make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+	return &Reader{p: p}, &Writer{p: p}
+}
+
+// 唤醒所有等待的读写，调用时持有锁
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+/**
+读取缓冲区中的数据，缓冲区为空时等待写入。写入端关闭之后，先读完缓冲区中的数据，然后返回io.EOF或者CloseWithError给定的错误。
+读取端自己关闭之后返回io.ErrClosedPipe
+*/
+func (r *Reader) Read(b []byte) (int, error) {
+	p := r.p
+	for {
+		p.mu.Lock()
+		if p.rclosed {
+			p.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if isClosed(p.readDeadline.wait()) {
+			p.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			p.notify()
+			p.mu.Unlock()
+			return n, nil
+		}
+		if p.wclosed {
+			p.mu.Unlock()
+			return 0, p.werr
+		}
+		if len(b) == 0 {
+			p.mu.Unlock()
+			return 0, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-p.readDeadline.wait():
+		}
+	}
+}
+
+// 缓冲区中还没有被读取的字节数
+func (r *Reader) Buffered() int {
+	r.p.mu.Lock()
+	defer r.p.mu.Unlock()
+	return len(r.p.buf)
+}
+
+// 关闭读取端，之后的写入返回io.ErrClosedPipe
+func (r *Reader) Close() error {
+	return r.CloseWithError(nil)
+}
+
+// 关闭读取端，之后的写入返回err，err为nil时返回io.ErrClosedPipe。缓冲区中的数据被丢弃
+func (r *Reader) CloseWithError(err error) error {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+	p := r.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.rclosed {
+		p.rclosed, p.rerr = true, err
+		p.buf = nil
+		p.notify()
+	}
+	return nil
+}
+
+// 设置读取的截止时间，零值表示没有截止时间。它也影响正在等待的读取
+func (r *Reader) SetReadDeadline(t time.Time) error {
+	r.p.readDeadline.set(t)
+	return nil
+}
+
+/**
+把b写入缓冲区，缓冲区满了时等待读取，直到全部写入为止。超过截止时间或者读取端被关闭时返回已经写入的字节数和错误，
+已经写入的部分仍然可以被读取。并发的写入依次进行，每次写入的数据在缓冲区中是连续的
+*/
+func (w *Writer) Write(b []byte) (n int, err error) {
+	p := w.p
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	for {
+		p.mu.Lock()
+		if p.wclosed {
+			p.mu.Unlock()
+			return n, io.ErrClosedPipe
+		}
+		if p.rclosed {
+			p.mu.Unlock()
+			return n, p.rerr
+		}
+		if isClosed(p.writeDeadline.wait()) {
+			p.mu.Unlock()
+			return n, os.ErrDeadlineExceeded
+		}
+		if len(b) == 0 {
+			p.mu.Unlock()
+			return n, nil
+		}
+		if free := p.capacity - len(p.buf); free > 0 {
+			m := len(b)
+			if m > free {
+				m = free
+			}
+			p.buf = append(p.buf, b[:m]...)
+			b = b[m:]
+			n += m
+			p.notify()
+			p.mu.Unlock()
+			continue
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-p.writeDeadline.wait():
+		}
+	}
+}
+
+// 关闭写入端，读取端读完缓冲区中的数据之后读到io.EOF
+func (w *Writer) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// 关闭写入端，读取端读完缓冲区中的数据之后读到err，err为nil时读到io.EOF
+func (w *Writer) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	p := w.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.wclosed {
+		p.wclosed, p.werr = true, err
+		p.notify()
+	}
+	return nil
+}
+
+// 设置写入的截止时间，零值表示没有截止时间。它也影响正在等待的写入
+func (w *Writer) SetWriteDeadline(t time.Time) error {
+	w.p.writeDeadline.set(t)
+	return nil
+}
+
+/**
+截止时间，与net.Pipe的实现相同：到达截止时间时cancel被关闭，等待的一方通过select感知到它。
+重新设置截止时间时，如果cancel已经被关闭了就换一个新的
+*/
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// 定时器已经触发时，等待它关闭cancel
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	// 截止时间已经过去了
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
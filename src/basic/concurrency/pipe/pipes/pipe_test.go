@@ -0,0 +1,163 @@
+package pipes
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 缓冲区没有满时写入不等待读取
+func TestBuffered(t *testing.T) {
+	r, w := New(8)
+	if n, err := w.Write([]byte("ABCDEFGH")); n != 8 || err != nil {
+		t.Fatalf("ERROR: Write returns %d, %v", n, err)
+	}
+	if r.Buffered() != 8 {
+		t.Fatalf("ERROR: %d bytes are buffered", r.Buffered())
+	}
+	buf := make([]byte, 3)
+	if n, _ := r.Read(buf); string(buf[:n]) != "ABC" {
+		t.Fatalf("ERROR: Read %q", buf[:n])
+	}
+
+	// 超过容量的写入等待读取，直到全部写入为止
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("IJKLMNOPQRSTUVWXYZ"))
+		w.Close()
+		done <- err
+	}()
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "DEFGHIJKLMNOPQRSTUVWXYZ" {
+		t.Fatalf("ERROR: ReadAll returns %q, %v", data, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Write Error: %s", err)
+	}
+	if _, err := w.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Write returns %v after Close", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	r, w := New(4)
+	w.Write([]byte("ab"))
+	failure := errors.New("producer failed")
+	w.CloseWithError(failure)
+	// 缓冲区中的数据仍然可以被读取
+	buf := make([]byte, 4)
+	if n, err := r.Read(buf); n != 2 || err != nil {
+		t.Fatalf("ERROR: Read returns %d, %v", n, err)
+	}
+	if _, err := r.Read(buf); err != failure {
+		t.Fatalf("ERROR: Read returns %v", err)
+	}
+
+	// 读取端关闭时唤醒正在等待的写入
+	r, w = New(4)
+	done := make(chan error, 1)
+	go func() {
+		n, err := w.Write([]byte("abcdefgh"))
+		if n != 4 {
+			err = errors.New("partial write")
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	r.Close()
+	if err := <-done; err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Write returns %v", err)
+	}
+	if _, err := r.Read(buf); err != io.ErrClosedPipe {
+		t.Fatalf("ERROR: Read returns %v after Close", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	r, w := New(4)
+	r.SetReadDeadline(time.Now().Add(30 * time.Millisecond))
+	begin := time.Now()
+	_, err := r.Read(make([]byte, 4))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || time.Since(begin) < 30*time.Millisecond {
+		t.Fatalf("ERROR: Read returns %v after %v", err, time.Since(begin))
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatal("ERROR: The error is not a timeout!")
+	}
+	// 清除截止时间之后可以继续读取
+	r.SetReadDeadline(time.Time{})
+	w.Write([]byte("ab"))
+	if n, err := r.Read(make([]byte, 4)); n != 2 || err != nil {
+		t.Fatalf("ERROR: Read returns %d, %v", n, err)
+	}
+
+	// 写入在缓冲区满了之后超时，已经写入的部分被保留
+	w.SetWriteDeadline(time.Now().Add(30 * time.Millisecond))
+	if n, err := w.Write([]byte("abcdef")); n != 4 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ERROR: Write returns %d, %v", n, err)
+	}
+	// 把截止时间设置为过去的时间会唤醒正在等待的写入
+	w.SetWriteDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("x"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	w.SetWriteDeadline(time.Now().Add(-time.Second))
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("ERROR: Write returns %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ERROR: The blocked write is not woken up!")
+	}
+}
+
+// 大于缓冲区的并发写入不会交错在一起
+func TestConcurrentWrite(t *testing.T) {
+	r, w := New(16)
+	var wg sync.WaitGroup
+	for _, c := range "abcdefgh" {
+		chunk := strings.Repeat(string(c), 1000)
+		wg.Add(1)
+		go func(chunk string) {
+			defer wg.Done()
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				t.Errorf("Write Error: %s", err)
+			}
+		}(chunk)
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+	// 每次只读取一点，让等待的写入有机会交错
+	var data []byte
+	buf := make([]byte, 5)
+	for {
+		n, err := r.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read Error: %s", err)
+		}
+		time.Sleep(time.Microsecond)
+	}
+	if len(data) != 8000 {
+		t.Fatalf("ERROR: %d bytes are read", len(data))
+	}
+	for i := 0; i < len(data); i += 1000 {
+		if chunk := string(data[i : i+1000]); strings.Count(chunk, chunk[:1]) != 1000 {
+			t.Fatalf("ERROR: The writes are interleaved: %q", chunk)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package pipes
+
+import (
+	"io"
+	"sync"
+)
+
+/**
+广播管道：写入的数据被复制给所有的读取端，每个读取端有自己的缓冲区。
+写入要等待所有的读取端都有空间，所以最慢的读取端决定了写入的速度，数据不会丢失；被关闭的读取端自动退出广播。
+读取端只能读到它加入之后写入的数据。
+*/
+type Tee struct {
+	capacity int
+	// 串行化写入。写入可能要等待很久，所以不能在等待时持有mu，否则Close和NewReader也要等待最慢的读取端
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	writers []*Writer
+	closed  bool
+	err     error
+}
+
+// 创建一个广播管道，每个读取端的缓冲区的容量是capacity字节，capacity不大于0时使用DefaultCapacity
+func NewTee(capacity int) *Tee {
+	return &Tee{capacity: capacity}
+}
+
+// 添加一个读取端。广播管道已经关闭时，返回的读取端立即读到关闭时的错误
+func (t *Tee) NewReader() *Reader {
+	r, w := New(t.capacity)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		w.CloseWithError(t.err)
+	} else {
+		t.writers = append(t.writers, w)
+	}
+	return r
+}
+
+// 当前的读取端的数量
+func (t *Tee) Readers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.writers)
+}
+
+/**
+把p写入所有的读取端。写入返回错误的读取端（即已经被关闭的）被移除，没有读取端时数据被丢弃。
+写入是串行的，所以每个读取端读到的数据的顺序都相同。等待读取端的过程中广播管道被关闭时返回io.ErrClosedPipe
+*/
+func (t *Tee) Write(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	writers := append([]*Writer(nil), t.writers...)
+	t.mu.Unlock()
+
+	var dead []*Writer
+	for _, w := range writers {
+		if _, err := w.Write(p); err != nil {
+			dead = append(dead, w)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return 0, io.ErrClosedPipe
+	}
+	// 写入的过程中可能添加了新的读取端，所以逐个移除失败的写入端
+	for _, w := range dead {
+		t.remove(w)
+	}
+	return len(p), nil
+}
+
+// 移除一个写入端。调用时必须持有锁
+func (t *Tee) remove(w *Writer) {
+	for i, other := range t.writers {
+		if other == w {
+			last := len(t.writers) - 1
+			copy(t.writers[i:], t.writers[i+1:])
+			// 清除被移除的写入端的引用
+			t.writers[last] = nil
+			t.writers = t.writers[:last]
+			return
+		}
+	}
+}
+
+// 关闭广播管道，所有的读取端读完缓冲区中的数据之后读到io.EOF
+func (t *Tee) Close() error {
+	return t.CloseWithError(nil)
+}
+
+// 关闭广播管道，所有的读取端读完缓冲区中的数据之后读到err，err为nil时读到io.EOF
+func (t *Tee) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed, t.err = true, err
+	for _, w := range t.writers {
+		w.CloseWithError(err)
+	}
+	t.writers = nil
+	return nil
+}
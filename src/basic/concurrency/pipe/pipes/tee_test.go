@@ -0,0 +1,91 @@
+package pipes
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTee(t *testing.T) {
+	tee := NewTee(4)
+	fast, slow, quitter := tee.NewReader(), tee.NewReader(), tee.NewReader()
+	quitter.Close()
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 2)
+	for i, r := range []*Reader{fast, slow} {
+		wg.Add(1)
+		go func(i int, r *Reader) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			chunk := make([]byte, 3)
+			for {
+				n, err := r.Read(chunk)
+				buf.Write(chunk[:n])
+				if err != nil {
+					break
+				}
+				if i == 1 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			results[i] = buf.Bytes()
+		}(i, r)
+	}
+	for _, s := range []string{"The quick ", "brown fox ", "jumps over ", "the lazy dog"} {
+		if _, err := tee.Write([]byte(s)); err != nil {
+			t.Fatalf("Write Error: %s", err)
+		}
+	}
+	if tee.Readers() != 2 {
+		t.Fatalf("ERROR: There are %d readers", tee.Readers())
+	}
+	tee.Close()
+	wg.Wait()
+	for i, result := range results {
+		if string(result) != "The quick brown fox jumps over the lazy dog" {
+			t.Fatalf("ERROR: The reader %d reads %q", i, result)
+		}
+	}
+	if _, err := tee.NewReader().Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("ERROR: A reader added after Close reads %v", err)
+	}
+}
+
+// 一个读取端不再读取时写入会一直等待，但是Close和NewReader不能被它阻塞，Close还要唤醒等待的写入
+func TestTeeBlockedWrite(t *testing.T) {
+	tee := NewTee(4)
+	tee.NewReader()
+	written := make(chan error, 1)
+	go func() {
+		_, err := tee.Write([]byte("more than four bytes"))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("ERROR: The write to a full reader returns %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tee.NewReader()
+		tee.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ERROR: NewReader or Close is blocked by the write!")
+	}
+	select {
+	case err := <-written:
+		if err != io.ErrClosedPipe {
+			t.Fatalf("ERROR: The interrupted write returns %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ERROR: The blocked write is not woken up!")
+	}
+}
@@ -0,0 +1,334 @@
+package cmdpipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+像shell一样执行命令管道，比如ps aux | grep mysignal | awk '{print $2}'。
+相邻的两个命令通过操作系统的管道直接相连，所有的命令同时运行，前一个命令的输出一边产生一边被后一个命令读取，所以输出可以流式地被处理，
+而且不管输出有多大，内存中都不需要保存它。这与先执行完一个命令、把它的全部输出缓存在内存中再交给下一个命令的做法不同。
+ctx被取消（比如超时）时所有的命令都会被杀死。每个命令的退出状态和标准错误的最后一部分会被收集起来，
+与shell的pipefail选项一样，最后一个失败的命令决定管道的错误。
+*/
+
+// 每个命令默认保留的标准错误的最大字节数，超过的部分只保留最后的DefaultMaxStderr个字节
+const DefaultMaxStderr = 64 << 10
+
+/**
+命令退出之后等待它的标准错误被关闭的默认最长时间。命令启动的子进程会继承标准错误，命令被杀死之后它们可能还在运行，
+如果一直等待，Wait就要等到它们全部退出为止。参见exec.Cmd.WaitDelay
+*/
+const DefaultWaitDelay = time.Second
+
+// 管道中的一个命令和它的执行结果
+type Stage struct {
+	Cmd *exec.Cmd
+	// 退出码，命令没有退出或者被信号终止时是-1
+	ExitCode int
+	// exec.Cmd.Start或者Wait返回的错误，命令成功时为nil
+	Err error
+
+	stderr *tailBuffer
+}
+
+// 命令的标准错误的最后一部分
+func (s *Stage) Stderr() []byte {
+	return s.stderr.Bytes()
+}
+
+// 命令的文本形式，比如"grep -v go run"
+func (s *Stage) String() string {
+	return strings.Join(s.Cmd.Args, " ")
+}
+
+// 管道中的一个命令失败时返回的错误
+type StageError struct {
+	// 命令在管道中的序号，从0开始
+	Index int
+	Stage *Stage
+}
+
+func (e *StageError) Error() string {
+	msg := fmt.Sprintf("cmdpipe: stage %d (%s) failed: %s", e.Index, e.Stage, e.Stage.Err)
+	// 附加标准错误的最后一行，它通常说明了失败的原因
+	if stderr := bytes.TrimSpace(e.Stage.Stderr()); len(stderr) > 0 {
+		if i := bytes.LastIndexByte(stderr, '\n'); i >= 0 {
+			stderr = stderr[i+1:]
+		}
+		msg += ": " + string(stderr)
+	}
+	return msg
+}
+
+// 使errors.As可以取得*exec.ExitError
+func (e *StageError) Unwrap() error {
+	return e.Stage.Err
+}
+
+type Pipeline struct {
+	// 第一个命令的标准输入，为nil时使用第一个命令自己的Stdin，两者都为nil时是空设备
+	Stdin io.Reader
+	// 最后一个命令的标准输出，为nil时使用最后一个命令自己的Stdout，两者都为nil时是空设备，参见StdoutPipe
+	Stdout io.Writer
+	// 每个命令保留的标准错误的最大字节数，0表示DefaultMaxStderr
+	MaxStderr int
+	// 没有设置WaitDelay的命令使用的WaitDelay，0表示DefaultWaitDelay
+	WaitDelay time.Duration
+
+	stages []*Stage
+	// 父进程持有的管道的一端，在命令启动之后关闭
+	parentFiles []*os.File
+	// StdoutPipe返回的读取端，在Wait之后关闭
+	stdoutReader *os.File
+	ctx          context.Context
+	started      bool
+	// 在所有的命令退出之后被关闭，使杀死命令的Goroutine退出
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+/**
+创建一个管道，cmds按照顺序相连。除了第一个命令的标准输入和最后一个命令的标准输出以外，命令的标准输入和输出不能被设置，
+而这两者也不能与Pipeline的Stdin和Stdout同时设置；
+命令的标准错误可以被设置，此时它被同时写入设置的Writer和Stage中。
+*/
+func New(cmds ...*exec.Cmd) *Pipeline {
+	p := &Pipeline{stages: make([]*Stage, len(cmds))}
+	for i, cmd := range cmds {
+		p.stages[i] = &Stage{Cmd: cmd, ExitCode: -1}
+	}
+	return p
+}
+
+// 管道中的所有命令，在Wait返回之后可以从中得到每个命令的执行结果
+func (p *Pipeline) Stages() []*Stage {
+	return p.stages
+}
+
+/**
+返回一个连接到最后一个命令的标准输出的读取端，它必须在Start之前调用，并且不能同时设置Stdout。
+与exec.Cmd.StdoutPipe一样，Wait会关闭这个读取端，所以要在读取完所有的输出之后再调用Wait。
+*/
+func (p *Pipeline) StdoutPipe() (io.ReadCloser, error) {
+	if p.Stdout != nil {
+		return nil, errors.New("cmdpipe: Stdout already set")
+	}
+	if p.started {
+		return nil, errors.New("cmdpipe: StdoutPipe after Start")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.Stdout = w
+	p.parentFiles = append(p.parentFiles, w)
+	p.stdoutReader = r
+	return r, nil
+}
+
+/**
+连接并启动所有的命令，但是不等待它们退出。ctx被取消时所有还在运行的命令都会被杀死。
+某个命令启动失败时，已经启动的命令会被杀死并等待退出，返回的*StageError指出了启动失败的命令。
+*/
+func (p *Pipeline) Start(ctx context.Context) error {
+	if len(p.stages) == 0 {
+		return errors.New("cmdpipe: no commands")
+	}
+	if p.started {
+		return errors.New("cmdpipe: already started")
+	}
+	p.started = true
+	p.ctx = ctx
+	maxStderr := p.MaxStderr
+	if maxStderr <= 0 {
+		maxStderr = DefaultMaxStderr
+	}
+	waitDelay := p.WaitDelay
+	if waitDelay <= 0 {
+		waitDelay = DefaultWaitDelay
+	}
+	last := len(p.stages) - 1
+	for i, s := range p.stages {
+		if (i > 0 && s.Cmd.Stdin != nil) || (i < last && s.Cmd.Stdout != nil) {
+			p.closeFiles()
+			return fmt.Errorf("cmdpipe: the stdin or stdout of stage %d (%s) is already set", i, s)
+		}
+		// 第一个命令的标准输入和最后一个命令的标准输出既可以在Pipeline中设置，也可以在命令中设置，但是不能同时设置
+		if (i == 0 && p.Stdin != nil && s.Cmd.Stdin != nil) || (i == last && p.Stdout != nil && s.Cmd.Stdout != nil) {
+			p.closeFiles()
+			return fmt.Errorf("cmdpipe: the stdin or stdout of stage %d (%s) conflicts with the pipeline's", i, s)
+		}
+		if s.Cmd.WaitDelay == 0 {
+			s.Cmd.WaitDelay = waitDelay
+		}
+		s.stderr = &tailBuffer{max: maxStderr}
+		if s.Cmd.Stderr != nil {
+			s.Cmd.Stderr = io.MultiWriter(s.Cmd.Stderr, s.stderr)
+		} else {
+			s.Cmd.Stderr = s.stderr
+		}
+	}
+	if p.Stdin != nil {
+		p.stages[0].Cmd.Stdin = p.Stdin
+	}
+	if p.Stdout != nil {
+		p.stages[last].Cmd.Stdout = p.Stdout
+	}
+	for i := 0; i < last; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			p.closeFiles()
+			return err
+		}
+		p.stages[i].Cmd.Stdout = w
+		p.stages[i+1].Cmd.Stdin = r
+		p.parentFiles = append(p.parentFiles, r, w)
+	}
+
+	for i, s := range p.stages {
+		if err := s.Cmd.Start(); err != nil {
+			s.Err = err
+			p.closeFiles()
+			p.kill(p.stages[:i])
+			p.waitStages(p.stages[:i])
+			if p.stdoutReader != nil {
+				p.stdoutReader.Close()
+			}
+			return &StageError{Index: i, Stage: s}
+		}
+	}
+	// 子进程已经继承了管道，父进程必须关闭自己持有的一端，否则读取的命令永远等不到EOF
+	p.closeFiles()
+
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		select {
+		case <-ctx.Done():
+			p.kill(p.stages)
+		case <-p.done:
+		}
+	}()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.waitStages(p.stages)
+		close(p.done)
+	}()
+	return nil
+}
+
+/**
+等待所有的命令退出并返回管道的错误：ctx被取消时返回ctx的错误，否则返回最后一个失败的命令的*StageError，所有的命令都成功时返回nil。
+*/
+func (p *Pipeline) Wait() error {
+	if !p.started || p.done == nil {
+		return errors.New("cmdpipe: not started")
+	}
+	<-p.done
+	p.wg.Wait()
+	if p.stdoutReader != nil {
+		p.stdoutReader.Close()
+	}
+	for i := len(p.stages) - 1; i >= 0; i-- {
+		if s := p.stages[i]; s.Err != nil {
+			// 被杀死的命令的错误只是ctx被取消的结果
+			if err := p.ctx.Err(); err != nil {
+				return err
+			}
+			return &StageError{Index: i, Stage: s}
+		}
+	}
+	return nil
+}
+
+// 启动所有的命令并等待它们退出
+func (p *Pipeline) Run(ctx context.Context) error {
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// 执行管道并返回最后一个命令的标准输出
+func (p *Pipeline) Output(ctx context.Context) ([]byte, error) {
+	if p.Stdout != nil {
+		return nil, errors.New("cmdpipe: Stdout already set")
+	}
+	var buf bytes.Buffer
+	p.Stdout = &buf
+	err := p.Run(ctx)
+	return buf.Bytes(), err
+}
+
+func (p *Pipeline) waitStages(stages []*Stage) {
+	var wg sync.WaitGroup
+	for _, s := range stages {
+		wg.Add(1)
+		go func(s *Stage) {
+			defer wg.Done()
+			s.Err = s.Cmd.Wait()
+			if s.Cmd.ProcessState != nil {
+				s.ExitCode = s.Cmd.ProcessState.ExitCode()
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (p *Pipeline) kill(stages []*Stage) {
+	for _, s := range stages {
+		if s.Cmd.Process != nil {
+			// 命令可能已经退出了，此时的错误可以忽略
+			s.Cmd.Process.Kill()
+		}
+	}
+}
+
+func (p *Pipeline) closeFiles() {
+	for _, f := range p.parentFiles {
+		f.Close()
+	}
+	p.parentFiles = nil
+}
+
+// 只保留最后max个字节的缓冲区
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	if len(p) > b.max {
+		p = p[len(p)-b.max:]
+	}
+	if over := len(b.buf) + len(p) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
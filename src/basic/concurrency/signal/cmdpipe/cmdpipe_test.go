@@ -0,0 +1,186 @@
+package cmdpipe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutput(t *testing.T) {
+	p := New(
+		exec.Command("printf", "a\\nb\\nc\\n"),
+		exec.Command("grep", "-v", "b"),
+		exec.Command("tr", "a-z", "A-Z"),
+	)
+	out, err := p.Output(context.Background())
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	if string(out) != "A\nC\n" {
+		t.Fatalf("ERROR: The output is %q", out)
+	}
+	for i, s := range p.Stages() {
+		if s.ExitCode != 0 || s.Err != nil {
+			t.Fatalf("ERROR: The stage %d exits with %d: %v", i, s.ExitCode, s.Err)
+		}
+	}
+}
+
+func TestStdin(t *testing.T) {
+	p := New(exec.Command("sort"), exec.Command("head", "-n", "2"))
+	p.Stdin = strings.NewReader("c\nb\na\n")
+	out, err := p.Output(context.Background())
+	if err != nil || string(out) != "a\nb\n" {
+		t.Fatalf("ERROR: The output is (%q, %v)", out, err)
+	}
+}
+
+// 第一个和最后一个命令自己的标准输入和输出被保留，与Pipeline的Stdin和Stdout同时设置时启动失败
+func TestCmdStdio(t *testing.T) {
+	var out strings.Builder
+	first, last := exec.Command("sort"), exec.Command("head", "-n", "2")
+	first.Stdin, last.Stdout = strings.NewReader("c\nb\na\n"), &out
+	if err := New(first, last).Run(context.Background()); err != nil || out.String() != "a\nb\n" {
+		t.Fatalf("ERROR: The output is (%q, %v)", out.String(), err)
+	}
+
+	first, last = exec.Command("sort"), exec.Command("head")
+	first.Stdin = strings.NewReader("a\n")
+	p := New(first, last)
+	p.Stdin = strings.NewReader("b\n")
+	if err := p.Run(context.Background()); err == nil {
+		t.Fatal("ERROR: The conflicting stdin is overwritten!")
+	}
+	first, last = exec.Command("sort"), exec.Command("head")
+	last.Stdout = &out
+	if _, err := New(first, last).Output(context.Background()); err == nil {
+		t.Fatal("ERROR: The conflicting stdout is overwritten!")
+	}
+}
+
+// 输出不经过父进程的内存，大量的输出也只是在命令之间流动
+func TestLargeOutput(t *testing.T) {
+	p := New(exec.Command("head", "-c", "100000000", "/dev/zero"), exec.Command("wc", "-c"))
+	out, err := p.Output(context.Background())
+	if err != nil || strings.TrimSpace(string(out)) != "100000000" {
+		t.Fatalf("ERROR: The output is (%q, %v)", out, err)
+	}
+}
+
+// 第一个命令还在运行时就可以读到它的输出
+func TestStreaming(t *testing.T) {
+	p := New(exec.Command("sh", "-c", "echo first; exec sleep 10"), exec.Command("cat"))
+	stdout, err := p.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("Start Error: %s", err)
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	select {
+	case line := <-lines:
+		if line != "first" {
+			t.Fatalf("ERROR: The first line is %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ERROR: The output is not streamed!")
+	}
+	// 取消之后所有的命令都被杀死
+	begin := time.Now()
+	cancel()
+	if err := p.Wait(); err != context.Canceled {
+		t.Fatalf("ERROR: The error is %v, expected %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(begin); elapsed > 3*time.Second {
+		t.Fatalf("ERROR: The pipeline is stopped in %v", elapsed)
+	}
+	if _, ok := <-lines; ok {
+		t.Fatal("ERROR: The stdout is not closed!")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p := New(exec.Command("sleep", "10"), exec.Command("sleep", "10"))
+	begin := time.Now()
+	if err := p.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: The error is %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(begin); elapsed > 3*time.Second {
+		t.Fatalf("ERROR: The pipeline is stopped in %v", elapsed)
+	}
+	for i, s := range p.Stages() {
+		if s.ExitCode != -1 || s.Err == nil {
+			t.Fatalf("ERROR: The stage %d is not killed: %d, %v", i, s.ExitCode, s.Err)
+		}
+	}
+}
+
+// 与pipefail一样，最后一个失败的命令决定管道的错误
+func TestPipefail(t *testing.T) {
+	p := New(
+		exec.Command("sh", "-c", "echo first failure >&2; exit 3"),
+		exec.Command("sh", "-c", "cat; echo warning >&2; echo second failure >&2; exit 4"),
+		exec.Command("cat"),
+	)
+	err := p.Run(context.Background())
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Index != 1 || stageErr.Stage.ExitCode != 4 {
+		t.Fatalf("ERROR: The error is %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "stage 1 (sh -c cat;") || !strings.HasSuffix(msg, "exit status 4: second failure") {
+		t.Fatalf("ERROR: The error message is %s", msg)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+		t.Fatalf("ERROR: The error %v does not wrap the exit error", err)
+	}
+	stages := p.Stages()
+	if stages[0].ExitCode != 3 || string(stages[0].Stderr()) != "first failure\n" || stages[2].ExitCode != 0 {
+		t.Fatalf("ERROR: The stages are %d %q, %d", stages[0].ExitCode, stages[0].Stderr(), stages[2].ExitCode)
+	}
+}
+
+func TestStartError(t *testing.T) {
+	p := New(exec.Command("sleep", "10"), exec.Command("/nonexistent/command"))
+	begin := time.Now()
+	err := p.Run(context.Background())
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Index != 1 {
+		t.Fatalf("ERROR: The error is %v", err)
+	}
+	// 已经启动的命令被杀死了
+	if elapsed := time.Since(begin); elapsed > 3*time.Second || p.Stages()[0].Err == nil {
+		t.Fatalf("ERROR: The started stage is not killed: %v", p.Stages()[0].Err)
+	}
+	if err := New().Run(context.Background()); err == nil {
+		t.Fatal("ERROR: An empty pipeline is started!")
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 5}
+	for _, s := range []string{"ab", "cd", "efg", "hijklmn", "o"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("ERROR: Write returns (%d, %v)", n, err)
+		}
+	}
+	if got := string(b.Bytes()); got != "klmno" {
+		t.Fatalf("ERROR: The tail is %q", got)
+	}
+}
@@ -0,0 +1,415 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+信号分发器。
+mysignal.go中的signalHandleDemo直接把通道交给signal.Notify，再手动停止通知并关闭通道，每个程序都要重复这些代码。
+分发器把所有关心的信号汇集到一个通道中，由一个Goroutine按照信号把它们分发给注册的处理函数，处理函数可以随时注册和注销。
+在此之上它提供了几种常用的处理：收到SIGINT或SIGTERM时按照注册的相反顺序执行关闭钩子然后退出进程，在关闭的过程中再次收到信号时立即退出；
+收到SIGHUP时重新加载配置；收到SIGUSR1时输出所有Goroutine的调用栈。
+*/
+
+// 信号的处理函数，它在分发器的Goroutine中被调用，所以不应该阻塞太久，否则后面的信号要等待它返回
+type Handler func(sig os.Signal)
+
+// 日志记录器，*log.Logger满足这个接口
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// 分发器的可选项
+type Option func(d *Dispatcher)
+
+// 设置日志记录器，默认不记录日志
+func WithLogger(l Logger) Option {
+	return func(d *Dispatcher) {
+		d.logger = l
+	}
+}
+
+// 设置退出进程的函数，默认是os.Exit。测试时可以替换它以免测试进程退出
+func WithExitFunc(exit func(code int)) Option {
+	return func(d *Dispatcher) {
+		d.exit = exit
+	}
+}
+
+// 关闭钩子的默认超时时间
+const DefaultHookTimeout = 5 * time.Second
+
+type Dispatcher struct {
+	logger  Logger
+	exit    func(code int)
+	// 所有信号汇集到这个通道中，由分发的Goroutine接收
+	sigRecv chan os.Signal
+
+	// 保护下面的字段
+	mu       sync.Mutex
+	handlers map[os.Signal][]*registration
+	// 每个有处理函数的信号的订阅
+	subs map[os.Signal]*subscription
+	hooks    []*hook
+	// 正在关闭时为true，此时再次收到关闭的信号会立即退出
+	shuttingDown bool
+	// 关闭钩子是否已经开始执行
+	hooksRun bool
+	// 在关闭钩子都执行完之后被关闭
+	done chan struct{}
+	// 在Stop时被关闭
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type registration struct {
+	handler Handler
+}
+
+/**
+一个信号的订阅。signal.Stop只能停止一个通道的所有信号，如果所有信号共用一个通道，注销一个信号时就要先停止这个通道再重新注册剩下的信号，
+在这两步之间到达的SIGINT或者SIGTERM会执行默认的行为直接终止进程，即使它还有处理函数；先在新的通道上注册再停止旧的通道也不行，
+两个通道同时注册时到达的信号会被收到两次，第二个SIGINT会被当作强制退出。所以每个信号使用自己的通道，由一个Goroutine转发到sigRecv中，
+注销一个信号只停止它自己的通道。signal.Reset(sig)也能只注销一个信号，但是它会影响进程中其它通过signal.Notify接收这个信号的代码
+*/
+type subscription struct {
+	ch chan os.Signal
+	// 注销时被关闭，转发的Goroutine随之退出
+	quit chan struct{}
+}
+
+type hook struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// 创建一个分发器并启动分发信号的Goroutine
+func New(opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		exit:     os.Exit,
+		sigRecv:  make(chan os.Signal, 8),
+		handlers: make(map[os.Signal][]*registration),
+		subs:     make(map[os.Signal]*subscription),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.wg.Add(1)
+	go d.loop()
+	return d
+}
+
+/**
+为信号注册一个处理函数，同一个信号的多个处理函数按照注册的顺序被调用。返回的函数用来注销这个处理函数，
+一个信号的最后一个处理函数被注销之后，这个信号恢复默认的行为。
+分发器已经被Stop时什么也不做并返回一个空的注销函数：已经没有Goroutine分发信号了，如果再注册通知，信号就会被截获然后丢弃，
+比如SIGINT不再能终止进程，所以让信号保持默认的行为。
+*/
+func (d *Dispatcher) Handle(sig os.Signal, h Handler) (unregister func()) {
+	r := &registration{handler: h}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped() {
+		return func() {}
+	}
+	if len(d.handlers[sig]) == 0 {
+		d.subscribe(sig)
+	}
+	d.handlers[sig] = append(d.handlers[sig], r)
+	var once sync.Once
+	return func() {
+		once.Do(func() { d.unregister(sig, r) })
+	}
+}
+
+func (d *Dispatcher) unregister(sig os.Signal, r *registration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	regs := d.handlers[sig]
+	for i, reg := range regs {
+		if reg == r {
+			d.handlers[sig] = append(regs[:i:i], regs[i+1:]...)
+			break
+		}
+	}
+	if len(d.handlers[sig]) > 0 {
+		return
+	}
+	delete(d.handlers, sig)
+	d.unsubscribe(sig)
+}
+
+// 开始接收一个信号并把它转发到sigRecv。调用时必须持有锁
+func (d *Dispatcher) subscribe(sig os.Signal) {
+	sub := &subscription{ch: make(chan os.Signal, cap(d.sigRecv)), quit: make(chan struct{})}
+	signal.Notify(sub.ch, sig)
+	d.subs[sig] = sub
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			select {
+			case s := <-sub.ch:
+				select {
+				case d.sigRecv <- s:
+				case <-sub.quit:
+					return
+				}
+			case <-sub.quit:
+				return
+			}
+		}
+	}()
+}
+
+// 停止接收一个信号，它恢复默认的行为，其它信号的订阅不受影响。调用时必须持有锁
+func (d *Dispatcher) unsubscribe(sig os.Signal) {
+	sub, ok := d.subs[sig]
+	if !ok {
+		return
+	}
+	signal.Stop(sub.ch)
+	close(sub.quit)
+	delete(d.subs, sig)
+}
+
+/**
+注册一个关闭钩子。关闭时钩子按照注册的相反顺序依次执行，后注册的先执行，就像defer一样，因为后启动的组件通常依赖于先启动的组件。
+每个钩子最多执行timeout（0表示DefaultHookTimeout），超时之后ctx被取消，并且不再等待它而是继续执行下一个钩子。
+返回的函数用来注销这个钩子。
+*/
+func (d *Dispatcher) OnShutdown(name string, timeout time.Duration, fn func(ctx context.Context) error) (unregister func()) {
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	h := &hook{name: name, timeout: timeout, fn: fn}
+	d.mu.Lock()
+	d.hooks = append(d.hooks, h)
+	d.mu.Unlock()
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for i, other := range d.hooks {
+			if other == h {
+				d.hooks = append(d.hooks[:i:i], d.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+/**
+收到给定的信号（默认是SIGINT和SIGTERM）时执行关闭钩子，然后退出进程：所有的钩子都成功时退出码是0，否则是1。
+在执行钩子的过程中（包括直接调用Shutdown时）再次收到这些信号时不再等待，立即以128+信号值（与shell相同，比如SIGINT是130）退出。
+*/
+func (d *Dispatcher) HandleShutdown(sigs ...os.Signal) (unregister func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	return d.handleAll(sigs, func(sig os.Signal) {
+		d.mu.Lock()
+		forced := d.shuttingDown
+		d.shuttingDown = true
+		d.mu.Unlock()
+		if forced {
+			d.logf("Received %s again, exit immediately.", sig)
+			d.exit(exitCode(sig))
+			return
+		}
+		d.logf("Received %s, shutting down...", sig)
+		// 在另一个Goroutine中执行钩子，这样分发器才能收到第二个信号
+		go func() {
+			code := 0
+			if err := d.Shutdown(context.Background()); err != nil {
+				code = 1
+			}
+			d.exit(code)
+		}()
+	})
+}
+
+// 收到给定的信号（默认是SIGHUP）时调用reload，它返回的错误会被记录到日志中
+func (d *Dispatcher) HandleReload(reload func() error, sigs ...os.Signal) (unregister func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	return d.handleAll(sigs, func(sig os.Signal) {
+		if err := reload(); err != nil {
+			d.logf("Reload Error: %s (signal: %s)", err, sig)
+			return
+		}
+		d.logf("Reloaded. (signal: %s)", sig)
+	})
+}
+
+// 收到给定的信号（默认是SIGUSR1）时把所有Goroutine的调用栈写入w，w为nil时写入标准错误
+func (d *Dispatcher) HandleStackDump(w io.Writer, sigs ...os.Signal) (unregister func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGUSR1}
+	}
+	if w == nil {
+		w = os.Stderr
+	}
+	return d.handleAll(sigs, func(sig os.Signal) {
+		fmt.Fprintf(w, "=== Goroutine stacks (signal: %s) ===\n", sig)
+		// debug为2时的格式与未捕获的panic相同
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+}
+
+/**
+按照注册的相反顺序执行所有的关闭钩子，返回失败或者超时的钩子的错误。它可以被直接调用，比如在main函数返回之前；
+分发器只会执行一次钩子，之后的调用等待第一次调用完成并返回nil。ctx被取消时不再执行剩下的钩子。
+*/
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	d.shuttingDown = true
+	first := !d.hooksRun
+	d.hooksRun = true
+	hooks := d.hooks
+	d.hooks = nil
+	d.mu.Unlock()
+	if !first {
+		select {
+		case <-d.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer close(d.done)
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if err := d.runHook(ctx, hooks[i]); err != nil {
+			d.logf("Shutdown hook %s failed: %s", hooks[i].name, err)
+			errs = append(errs, fmt.Errorf("hook %s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) runHook(ctx context.Context, h *hook) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				result <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		result <- h.fn(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		// 不理会ctx的钩子还在运行，但是不再等待它
+		return ctx.Err()
+	}
+}
+
+// 在所有的关闭钩子执行完之后被关闭
+func (d *Dispatcher) Done() <-chan struct{} {
+	return d.done
+}
+
+// 停止接收信号，所有注册过的信号恢复默认的行为。之后的Handle不再起作用
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if d.stopped() {
+		d.mu.Unlock()
+		return
+	}
+	for sig := range d.subs {
+		d.unsubscribe(sig)
+	}
+	d.handlers = make(map[os.Signal][]*registration)
+	close(d.stop)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// 是否已经被Stop。调用时必须持有锁
+func (d *Dispatcher) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) handleAll(sigs []os.Signal, h Handler) func() {
+	unregisters := make([]func(), len(sigs))
+	for i, sig := range sigs {
+		unregisters[i] = d.Handle(sig, h)
+	}
+	return func() {
+		for _, unregister := range unregisters {
+			unregister()
+		}
+	}
+}
+
+func (d *Dispatcher) loop() {
+	defer d.wg.Done()
+	for {
+		select {
+		case sig := <-d.sigRecv:
+			d.dispatch(sig)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(sig os.Signal) {
+	d.mu.Lock()
+	regs := append([]*registration(nil), d.handlers[sig]...)
+	d.mu.Unlock()
+	for _, r := range regs {
+		d.call(r.handler, sig)
+	}
+}
+
+// 调用处理函数，它的panic不会使分发器的Goroutine退出
+func (d *Dispatcher) call(h Handler, sig os.Signal) {
+	defer func() {
+		if p := recover(); p != nil {
+			d.logf("Recovered from panic in the handler of %s: %v", sig, p)
+		}
+	}()
+	h(sig)
+}
+
+func (d *Dispatcher) logf(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf(format, args...)
+	}
+}
+
+// 因为信号而退出的退出码
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,319 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 向测试进程自己发送信号。发送之前必须已经注册了这个信号，否则测试进程会被终止
+func kill(t *testing.T, sig syscall.Signal) {
+	if err := syscall.Kill(os.Getpid(), sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, ch <-chan os.Signal) os.Signal {
+	select {
+	case sig := <-ch:
+		return sig
+	case <-time.After(2 * time.Second):
+		t.Fatal("ERROR: The signal is not dispatched!")
+		return nil
+	}
+}
+
+func TestHandle(t *testing.T) {
+	d := New()
+	defer d.Stop()
+	first, second := make(chan os.Signal, 4), make(chan os.Signal, 4)
+	unregister := d.Handle(syscall.SIGUSR1, func(sig os.Signal) { first <- sig })
+	d.Handle(syscall.SIGUSR1, func(sig os.Signal) { second <- sig })
+	d.Handle(syscall.SIGUSR2, func(sig os.Signal) { panic("boom") })
+	d.Handle(syscall.SIGUSR2, func(sig os.Signal) { second <- sig })
+
+	kill(t, syscall.SIGUSR1)
+	if receive(t, first) != syscall.SIGUSR1 || receive(t, second) != syscall.SIGUSR1 {
+		t.Fatal("ERROR: The wrong signal is dispatched!")
+	}
+	// 一个处理函数的panic不影响其它的处理函数
+	kill(t, syscall.SIGUSR2)
+	if receive(t, second) != syscall.SIGUSR2 {
+		t.Fatal("ERROR: The wrong signal is dispatched!")
+	}
+
+	unregister()
+	unregister()
+	kill(t, syscall.SIGUSR1)
+	receive(t, second)
+	select {
+	case <-first:
+		t.Fatal("ERROR: The unregistered handler is called!")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// 最后一个处理函数被注销之后，其它信号仍然被分发
+func TestUnregisterLast(t *testing.T) {
+	d := New()
+	defer d.Stop()
+	received := make(chan os.Signal, 4)
+	unregister := d.Handle(syscall.SIGWINCH, func(sig os.Signal) { received <- sig })
+	d.Handle(syscall.SIGUSR1, func(sig os.Signal) { received <- sig })
+	unregister()
+	// SIGWINCH的默认行为是忽略，所以可以安全地发送
+	kill(t, syscall.SIGWINCH)
+	kill(t, syscall.SIGUSR1)
+	if sig := receive(t, received); sig != syscall.SIGUSR1 {
+		t.Fatalf("ERROR: The signal %s is dispatched!", sig)
+	}
+}
+
+/**
+注销一个信号的过程中，其它信号一直有人接收。这里必须使用SIGINT：没有人接收的SIGUSR1会被Go的运行时忽略，
+而没有人接收的SIGINT会终止进程，所以有问题时整个测试进程会被终止
+*/
+func TestUnregisterKeepsOthers(t *testing.T) {
+	d := New()
+	var received int64
+	d.Handle(syscall.SIGINT, func(sig os.Signal) { atomic.AddInt64(&received, 1) })
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Microsecond):
+			}
+		}
+	}()
+	for begin := time.Now(); time.Since(begin) < 100*time.Millisecond; {
+		d.Handle(syscall.SIGWINCH, func(sig os.Signal) {})()
+	}
+	close(stop)
+	<-done
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt64(&received) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("ERROR: No SIGINT is dispatched!")
+		}
+	}
+	// 在停止之前等待已经发送的信号都到达
+	time.Sleep(50 * time.Millisecond)
+	d.Stop()
+}
+
+// Stop之后注册的处理函数不起作用，信号也不会被截获
+func TestHandleAfterStop(t *testing.T) {
+	d := New()
+	d.Stop()
+	called := make(chan os.Signal, 1)
+	unregister := d.Handle(syscall.SIGWINCH, func(sig os.Signal) { called <- sig })
+	defer unregister()
+	kill(t, syscall.SIGWINCH)
+	select {
+	case <-called:
+		t.Fatal("ERROR: The handler is called after Stop!")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(d.sigRecv) != 0 || len(d.handlers) != 0 {
+		t.Fatal("ERROR: The signal is intercepted after Stop!")
+	}
+}
+
+type recorder struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (r *recorder) hook(name string, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.order = append(r.order, name)
+		return err
+	}
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.order, ",")
+}
+
+func waitExit(t *testing.T, exits <-chan int) int {
+	select {
+	case code := <-exits:
+		return code
+	case <-time.After(3 * time.Second):
+		t.Fatal("ERROR: The process does not exit!")
+		return 0
+	}
+}
+
+// 关闭钩子按照注册的相反顺序执行
+func TestShutdown(t *testing.T) {
+	exits := make(chan int, 2)
+	d := New(WithExitFunc(func(code int) { exits <- code }))
+	defer d.Stop()
+	r := new(recorder)
+	d.OnShutdown("database", 0, r.hook("database", nil))
+	unregister := d.OnShutdown("cache", 0, r.hook("cache", nil))
+	d.OnShutdown("server", 0, r.hook("server", nil))
+	unregister()
+	d.HandleShutdown(syscall.SIGTERM)
+
+	kill(t, syscall.SIGTERM)
+	if code := waitExit(t, exits); code != 0 {
+		t.Fatalf("ERROR: The exit code is %d", code)
+	}
+	if order := r.String(); order != "server,database" {
+		t.Fatalf("ERROR: The hooks are executed in order %s", order)
+	}
+	select {
+	case <-d.Done():
+	default:
+		t.Fatal("ERROR: The dispatcher is not done!")
+	}
+	// 钩子只执行一次
+	if err := d.Shutdown(context.Background()); err != nil || r.String() != "server,database" {
+		t.Fatalf("ERROR: The second shutdown returns %v and executes %s", err, r.String())
+	}
+}
+
+// 失败和超时的钩子不影响后面的钩子，但是退出码是1
+func TestShutdownHookErrors(t *testing.T) {
+	d := New()
+	defer d.Stop()
+	r := new(recorder)
+	d.OnShutdown("first", 0, r.hook("first", nil))
+	d.OnShutdown("stuck", 50*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	d.OnShutdown("panicking", 0, func(ctx context.Context) error { panic("boom") })
+	d.OnShutdown("failing", 0, r.hook("failing", errors.New("flush failed")))
+
+	begin := time.Now()
+	err := d.Shutdown(context.Background())
+	if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+		t.Fatalf("ERROR: The stuck hook is waited for %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ERROR: The error %v does not contain the timeout", err)
+	}
+	for _, s := range []string{"hook failing: flush failed", "hook panicking: panic: boom", "hook stuck: context deadline exceeded"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("ERROR: The error %q does not contain %q", err, s)
+		}
+	}
+	if order := r.String(); order != "failing,first" {
+		t.Fatalf("ERROR: The hooks are executed in order %s", order)
+	}
+}
+
+// 在关闭的过程中再次收到信号时立即退出
+func TestForceExit(t *testing.T) {
+	exits := make(chan int, 2)
+	d := New(WithExitFunc(func(code int) { exits <- code }))
+	defer d.Stop()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	d.OnShutdown("slow", 10*time.Second, func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	d.HandleShutdown(syscall.SIGTERM)
+
+	kill(t, syscall.SIGTERM)
+	<-started
+	kill(t, syscall.SIGTERM)
+	if code := waitExit(t, exits); code != 128+int(syscall.SIGTERM) {
+		t.Fatalf("ERROR: The exit code is %d", code)
+	}
+	close(release)
+	if code := waitExit(t, exits); code != 0 {
+		t.Fatalf("ERROR: The exit code is %d", code)
+	}
+}
+
+type logRecorder struct {
+	lines chan string
+}
+
+func (l logRecorder) Printf(format string, args ...interface{}) {
+	l.lines <- format
+}
+
+func TestReload(t *testing.T) {
+	logs := logRecorder{lines: make(chan string, 4)}
+	d := New(WithLogger(logs))
+	defer d.Stop()
+	reloads := 0
+	d.HandleReload(func() error {
+		if reloads++; reloads > 1 {
+			return errors.New("bad config")
+		}
+		return nil
+	}, syscall.SIGHUP)
+
+	for _, expected := range []string{"Reloaded.", "Reload Error"} {
+		kill(t, syscall.SIGHUP)
+		select {
+		case line := <-logs.lines:
+			if !strings.HasPrefix(line, expected) {
+				t.Fatalf("ERROR: The log is %q, expected %q", line, expected)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("ERROR: The reload is not logged!")
+		}
+	}
+}
+
+// 写入完成时通知的Writer
+type notifyWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	written chan struct{}
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.buf.Write(p)
+	if strings.Contains(w.buf.String(), "TestStackDump") {
+		select {
+		case w.written <- struct{}{}:
+		default:
+		}
+	}
+	return n, err
+}
+
+func TestStackDump(t *testing.T) {
+	d := New()
+	defer d.Stop()
+	w := &notifyWriter{written: make(chan struct{}, 1)}
+	d.HandleStackDump(w)
+	kill(t, syscall.SIGUSR1)
+	select {
+	case <-w.written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ERROR: The stacks are not dumped!")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !strings.HasPrefix(w.buf.String(), "=== Goroutine stacks (signal: user defined signal 1) ===\ngoroutine ") {
+		t.Fatalf("ERROR: The dump is %q", w.buf.String())
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"basic/concurrency/signal/killer"
+	"basic/concurrency/signal/proc"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+/**
+killer包的命令行工具，像kill一样发送信号，但是会等待进程退出，在宽限期过后发送SIGKILL，并且输出每个进程的结果：
+	go run ./gokill -grace 3s 1234 5678
+	go run ./gokill -group 1234
+	go run ./gokill -name mysignal -s QUIT -no-escalate
+	go run ./gokill -name mysignal -group
+所有的进程都退出时（包括被SIGKILL杀死）退出码是0，否则是1。按下Ctrl+C会停止等待
+*/
+
+var (
+	sigName    = flag.String("s", "TERM", "the first signal, a name like TERM or SIGTERM, or a number")
+	grace      = flag.Duration("grace", killer.DefaultGrace, "how long to wait before sending SIGKILL")
+	noEscalate = flag.Bool("no-escalate", false, "do not send SIGKILL after the grace period")
+	killWait   = flag.Duration("kill-wait", killer.DefaultKillWait, "how long to wait after sending SIGKILL")
+	group      = flag.Bool("group", false, "treat the arguments as process group IDs")
+	name       = flag.String("name", "", "kill the processes with this name instead of the arguments, or their process groups with -group")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gokill [flags] pid...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	sig, err := killer.ParseSignal(*sigName)
+	if err != nil {
+		fail("%s", err)
+	}
+	opts := []killer.Option{killer.WithSignal(sig), killer.WithGrace(*grace), killer.WithKillWait(*killWait)}
+	if *noEscalate {
+		opts = append(opts, killer.WithoutEscalation())
+	}
+	ids, err := targets()
+	if err != nil {
+		fail("%s", err)
+	}
+	if len(ids) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var results []killer.Result
+	if *group {
+		for _, pgid := range ids {
+			results = append(results, killer.KillGroup(ctx, pgid, opts...)...)
+		}
+	} else {
+		results = killer.Kill(ctx, ids, opts...)
+	}
+	printTable(results)
+	for _, r := range results {
+		if r.Outcome != killer.Exited && r.Outcome != killer.Killed {
+			os.Exit(1)
+		}
+	}
+}
+
+/**
+要终止的进程或者进程组，来自-name或者命令行参数。不会终止gokill自己。
+-name与-group一起使用时终止找到的进程所在的进程组，而不是把PID当作进程组ID：它们只有在进程恰好是组长时才相同，
+否则会向一个无关的进程组发送信号，或者因为找不到这个进程组而失败。多个进程属于同一个组时只终止一次，gokill自己所在的组被跳过
+*/
+func targets() ([]int, error) {
+	if *name != "" {
+		self, selfGroup := os.Getpid(), syscall.Getpgrp()
+		procs, err := proc.Find(proc.ByName(*name), func(p *proc.Process) bool { return p.PID != self })
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]int, 0, len(procs))
+		seen := make(map[int]bool)
+		for _, p := range procs {
+			id := p.PID
+			if *group {
+				id = p.PGID
+				if id == selfGroup || seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+	ids := make([]int, 0, flag.NArg())
+	for _, arg := range flag.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid pid %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func printTable(results []killer.Result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tNAME\tOUTCOME\tSIGNALS\tELAPSED\tERROR")
+	for _, r := range results {
+		signals := make([]string, len(r.Signals))
+		for i, sig := range r.Signals {
+			signals[i] = killer.SignalName(sig)
+		}
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%v\t%s\n", r.PID, r.Name, r.Outcome, strings.Join(signals, ","), r.Elapsed.Round(time.Millisecond), errText)
+	}
+	tw.Flush()
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "gokill: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,317 @@
+package killer
+
+import (
+	"basic/concurrency/signal/proc"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+终止进程并等待它们真正退出。
+mysignal.go中的sigSendingDemo向每个进程发送信号之后就不再理会它们了。这里先发送SIGTERM（或者给定的信号），
+在宽限期内通过轮询/proc等待进程退出；宽限期过后仍然存在的进程会收到SIGKILL。每个进程的结果被单独报告。
+发送信号之前先记录进程的启动时间，如果之后同一个PID的启动时间变了，说明原来的进程已经退出并且PID被重用了，
+所以不会把信号发给无关的进程，也不会一直等待一个新的进程。
+*/
+
+// 一个进程的结局
+type Outcome string
+
+const (
+	// 在宽限期内退出
+	Exited Outcome = "exited"
+	// 被SIGKILL杀死
+	Killed Outcome = "killed"
+	// 发送信号时进程已经不存在了
+	NotFound Outcome = "not found"
+	// 发送了SIGKILL之后仍然没有退出（比如处于不可中断的睡眠中），或者在等待的过程中ctx被取消了
+	Timeout Outcome = "timeout"
+	// 无法发送信号，比如没有权限
+	Failed Outcome = "failed"
+)
+
+// 一个进程的结果
+type Result struct {
+	PID int
+	// 进程名，进程不存在时为空
+	Name    string
+	Outcome Outcome
+	// 依次发送的信号
+	Signals []syscall.Signal
+	// 从发送第一个信号到确定结局的时间
+	Elapsed time.Duration
+	// Outcome为Failed或者Timeout时的错误
+	Err error
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%d %s: %s after %v", r.PID, r.Name, r.Outcome, r.Elapsed)
+	if r.Err != nil {
+		s += fmt.Sprintf(" (%s)", r.Err)
+	}
+	return s
+}
+
+const (
+	DefaultGrace        = 5 * time.Second
+	DefaultKillWait     = 2 * time.Second
+	DefaultPollInterval = 20 * time.Millisecond
+)
+
+type options struct {
+	signal       syscall.Signal
+	grace        time.Duration
+	escalate     bool
+	killWait     time.Duration
+	pollInterval time.Duration
+}
+
+// Kill和KillGroup的可选项
+type Option func(o *options)
+
+// 设置第一个信号，默认是SIGTERM
+func WithSignal(sig syscall.Signal) Option {
+	return func(o *options) {
+		o.signal = sig
+	}
+}
+
+// 设置发送SIGKILL之前的宽限期，默认是DefaultGrace
+func WithGrace(d time.Duration) Option {
+	return func(o *options) {
+		o.grace = d
+	}
+}
+
+// 宽限期过后不发送SIGKILL，仍然存在的进程的结局是Timeout
+func WithoutEscalation() Option {
+	return func(o *options) {
+		o.escalate = false
+	}
+}
+
+// 设置发送SIGKILL之后等待的时间，默认是DefaultKillWait
+func WithKillWait(d time.Duration) Option {
+	return func(o *options) {
+		o.killWait = d
+	}
+}
+
+// 设置轮询/proc的间隔，默认是DefaultPollInterval
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = d
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		signal:       syscall.SIGTERM,
+		grace:        DefaultGrace,
+		escalate:     true,
+		killWait:     DefaultKillWait,
+		pollInterval: DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.pollInterval <= 0 {
+		o.pollInterval = DefaultPollInterval
+	}
+	return o
+}
+
+// 一个要终止的进程，send向它（或者它所在的进程组）发送信号
+type target struct {
+	p    *proc.Process
+	send func(sig syscall.Signal) error
+}
+
+/**
+终止给定的进程，返回的结果与pids的顺序相同。所有的进程被同时终止，所以总的时间不超过宽限期加上发送SIGKILL之后的等待时间。
+ctx被取消时不再等待，还没有退出的进程的结局是Timeout。
+*/
+func Kill(ctx context.Context, pids []int, opts ...Option) []Result {
+	o := newOptions(opts)
+	results := make([]Result, len(pids))
+	targets := make([]*target, len(pids))
+	for i, pid := range pids {
+		results[i].PID = pid
+		p, err := proc.Get(pid)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				results[i].Outcome = NotFound
+			} else {
+				results[i].Outcome, results[i].Err = Failed, err
+			}
+			continue
+		}
+		results[i].Name = p.Name
+		pid := pid
+		targets[i] = &target{p: p, send: func(sig syscall.Signal) error { return syscall.Kill(pid, sig) }}
+	}
+	o.terminate(ctx, targets, results)
+	return results
+}
+
+/**
+终止进程组pgid中的所有进程，每个成员一个结果，按照PID排序；进程组不存在时只有一个结局为NotFound的结果。
+信号被发送给整个进程组（即kill(-pgid, sig)），所以在此期间新加入进程组的进程也会收到信号，只是不在结果中。
+*/
+func KillGroup(ctx context.Context, pgid int, opts ...Option) []Result {
+	o := newOptions(opts)
+	// 僵尸进程已经退出了，不算是成员
+	members, err := proc.Find(proc.ByGroup(pgid), func(p *proc.Process) bool { return p.State != "Z" })
+	if err != nil {
+		return []Result{{PID: pgid, Outcome: Failed, Err: err}}
+	}
+	if len(members) == 0 {
+		return []Result{{PID: pgid, Outcome: NotFound}}
+	}
+	// 每个信号只向进程组发送一次，所有的成员共用发送的结果
+	var mu sync.Mutex
+	sent := make(map[syscall.Signal]error)
+	send := func(sig syscall.Signal) error {
+		mu.Lock()
+		defer mu.Unlock()
+		err, ok := sent[sig]
+		if !ok {
+			err = syscall.Kill(-pgid, sig)
+			sent[sig] = err
+		}
+		return err
+	}
+	results := make([]Result, len(members))
+	targets := make([]*target, len(members))
+	for i, p := range members {
+		results[i] = Result{PID: p.PID, Name: p.Name}
+		targets[i] = &target{p: p, send: send}
+	}
+	o.terminate(ctx, targets, results)
+	return results
+}
+
+// 同时终止所有的目标，targets[i]为nil时results[i]已经有了结局
+func (o *options) terminate(ctx context.Context, targets []*target, results []Result) {
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		if t == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(t *target, r *Result) {
+			defer wg.Done()
+			o.terminateOne(ctx, t, r)
+		}(t, &results[i])
+	}
+	wg.Wait()
+}
+
+func (o *options) terminateOne(ctx context.Context, t *target, r *Result) {
+	begin := time.Now()
+	defer func() { r.Elapsed = time.Since(begin) }()
+	if err := t.send(o.signal); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			r.Outcome = NotFound
+		} else {
+			r.Outcome, r.Err = Failed, err
+		}
+		return
+	}
+	r.Signals = append(r.Signals, o.signal)
+	exited, err := o.wait(ctx, t.p, o.grace)
+	if exited {
+		r.Outcome = Exited
+		return
+	}
+	if err != nil || !o.escalate || o.signal == syscall.SIGKILL {
+		r.Outcome, r.Err = Timeout, err
+		return
+	}
+	if err := t.send(syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		r.Outcome, r.Err = Failed, err
+		return
+	}
+	r.Signals = append(r.Signals, syscall.SIGKILL)
+	if exited, err = o.wait(ctx, t.p, o.killWait); exited {
+		r.Outcome = Killed
+		return
+	}
+	r.Outcome, r.Err = Timeout, err
+}
+
+// 等待进程退出，最多等待d。返回进程是否已经退出，以及ctx的错误
+func (o *options) wait(ctx context.Context, p *proc.Process, d time.Duration) (bool, error) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+	for {
+		if !alive(p) {
+			return true, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return !alive(p), nil
+		case <-ctx.Done():
+			return !alive(p), ctx.Err()
+		}
+	}
+}
+
+/**
+进程是否还在运行。僵尸进程已经退出了，只是还没有被它的父进程回收，所以不算存活；
+PID相同但是启动时间不同的进程是PID被重用之后的另一个进程
+*/
+func alive(p *proc.Process) bool {
+	current, err := proc.Get(p.PID)
+	if err != nil {
+		return false
+	}
+	return current.StartTime.Equal(p.StartTime) && current.State != "Z"
+}
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+	"TSTP": syscall.SIGTSTP,
+}
+
+// 解析信号的名字或者编号，比如"TERM"、"SIGTERM"、"term"或者"15"
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n >= 65 {
+			return 0, fmt.Errorf("killer: invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("killer: unknown signal %q", s)
+}
+
+// 信号的名字，比如SIGTERM的名字是"TERM"，没有名字的信号返回它的编号
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
@@ -0,0 +1,153 @@
+package killer
+
+import (
+	"basic/concurrency/signal/proc"
+	"bufio"
+	"context"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 启动一个shell脚本，等待它输出第一行，即它已经设置好了trap
+func start(t *testing.T, script string, setpgid bool) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", script)
+	if setpgid {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatalf("ERROR: The script does not start: %s", err)
+	}
+	return cmd
+}
+
+const stubborn = `trap "" TERM; echo ready; while true; do sleep 0.01; done`
+
+func TestKill(t *testing.T) {
+	polite := start(t, "echo ready; exec sleep 30", false)
+	rude := start(t, stubborn, false)
+	// 已经退出并被回收的进程
+	gone := exec.Command("true")
+	gone.Run()
+
+	begin := time.Now()
+	results := Kill(context.Background(), []int{polite.Process.Pid, rude.Process.Pid, gone.Process.Pid}, WithGrace(200*time.Millisecond))
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Fatalf("ERROR: Kill takes %v", elapsed)
+	}
+	expected := []struct {
+		name    string
+		outcome Outcome
+		signals []syscall.Signal
+	}{
+		{"sleep", Exited, []syscall.Signal{syscall.SIGTERM}},
+		{"sh", Killed, []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL}},
+		{"", NotFound, nil},
+	}
+	for i, r := range results {
+		e := expected[i]
+		if r.Name != e.name || r.Outcome != e.outcome || !reflect.DeepEqual(r.Signals, e.signals) || r.Err != nil {
+			t.Fatalf("ERROR: The result %d is %+v", i, r)
+		}
+	}
+	if results[0].Elapsed >= 200*time.Millisecond || results[1].Elapsed < 200*time.Millisecond {
+		t.Fatalf("ERROR: The elapsed times are %v and %v", results[0].Elapsed, results[1].Elapsed)
+	}
+}
+
+func TestWithoutEscalation(t *testing.T) {
+	cmd := start(t, stubborn, false)
+	r := Kill(context.Background(), []int{cmd.Process.Pid}, WithGrace(100*time.Millisecond), WithoutEscalation())[0]
+	if r.Outcome != Timeout || len(r.Signals) != 1 || r.Err != nil {
+		t.Fatalf("ERROR: The result is %+v", r)
+	}
+	if p, err := proc.Get(cmd.Process.Pid); err != nil || p.State == "Z" {
+		t.Fatal("ERROR: The process is killed!")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r = Kill(ctx, []int{cmd.Process.Pid}, WithSignal(syscall.SIGUSR1))[0]
+	// sh没有处理SIGUSR1，所以它被终止了
+	if r.Outcome != Exited || r.Signals[0] != syscall.SIGUSR1 {
+		t.Fatalf("ERROR: The result is %+v", r)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cmd := start(t, stubborn, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := Kill(ctx, []int{cmd.Process.Pid})[0]
+	if r.Outcome != Timeout || r.Err != context.DeadlineExceeded || r.Elapsed > time.Second {
+		t.Fatalf("ERROR: The result is %+v", r)
+	}
+}
+
+// 进程组中的所有进程都收到信号，包括shell启动的后台进程
+func TestKillGroup(t *testing.T) {
+	cmd := start(t, "echo ready; sleep 30 & sleep 30 & wait", true)
+	pgid := cmd.Process.Pid
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		members, _ := proc.Find(proc.ByGroup(pgid))
+		if len(members) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ERROR: The members are %v", members)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results := KillGroup(context.Background(), pgid, WithGrace(time.Second))
+	if len(results) != 3 || results[0].PID != pgid {
+		t.Fatalf("ERROR: The results are %v", results)
+	}
+	for _, r := range results {
+		if r.Outcome != Exited || r.Elapsed > time.Second {
+			t.Fatalf("ERROR: The result is %+v", r)
+		}
+	}
+	if r := KillGroup(context.Background(), pgid); len(r) != 1 || r[0].Outcome != NotFound {
+		t.Fatalf("ERROR: The results of a gone group are %v", r)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"SIGKILL": syscall.SIGKILL,
+		"hup":     syscall.SIGHUP,
+		"10":      syscall.Signal(10),
+	} {
+		if sig, err := ParseSignal(s); err != nil || sig != expected {
+			t.Fatalf("ERROR: %q is parsed as %v, %v", s, sig, err)
+		}
+	}
+	if name := SignalName(syscall.SIGTERM); name != "TERM" {
+		t.Fatalf("ERROR: The name of SIGTERM is %s", name)
+	}
+	if name := SignalName(syscall.Signal(40)); name != "40" {
+		t.Fatalf("ERROR: The name of signal 40 is %s", name)
+	}
+	for _, s := range []string{"", "0", "99", "SIGFOO"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Fatalf("ERROR: %q is parsed", s)
+		}
+	}
+}
@@ -1,22 +1,40 @@
 package main
 
 import (
-	"bytes"
-	"errors"
+	"basic/concurrency/signal/cmdpipe"
+	"basic/concurrency/signal/dispatcher"
+	"basic/concurrency/signal/proc"
+	"basic/concurrency/signal/supervisor"
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
-	"io"
+	"log"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime/debug"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// 使用-dispatcher运行dispatcherDemo：go run mysignal.go -dispatcher
+var useDispatcher = flag.Bool("dispatcher", false, "run the signal dispatcher demo")
+
+// 使用-supervisor运行supervisorDemo：go run mysignal.go -supervisor
+var useSupervisor = flag.Bool("supervisor", false, "run the process supervisor demo")
+
 func main() {
+	flag.Parse()
+	if *useDispatcher {
+		dispatcherDemo()
+		return
+	}
+	if *useSupervisor {
+		supervisorDemo()
+		return
+	}
 	go func() {
 		time.Sleep(5 * time.Second)
 		sigSendingDemo()
@@ -67,7 +85,97 @@ func signalHandleDemo() {
 }
 
 /**
-发送信号
+使用dispatcher包完成signalHandleDemo中的工作：不再需要自己管理通道，处理函数按照信号注册，关闭时按照相反的顺序执行关闭钩子。
+运行之后它会依次向自己发送SIGUSR1（输出Goroutine的调用栈）、SIGHUP（重新加载）和SIGINT（执行关闭钩子并退出）；
+也可以手动键入Ctrl-c，在关闭钩子执行完之前再次键入Ctrl-c会立即退出
+*/
+func dispatcherDemo() {
+	d := dispatcher.New(dispatcher.WithLogger(log.New(os.Stdout, "[dispatcher] ", log.Ltime)))
+	d.HandleShutdown()
+	d.HandleReload(func() error {
+		fmt.Println("Reload the configuration...")
+		return nil
+	})
+	d.HandleStackDump(os.Stdout)
+	d.Handle(syscall.SIGQUIT, func(sig os.Signal) {
+		fmt.Printf("Received a signal: %s\n", sig)
+	})
+	// 先注册的钩子后执行
+	d.OnShutdown("database", time.Second, func(ctx context.Context) error {
+		fmt.Println("Close the database.")
+		return nil
+	})
+	d.OnShutdown("server", 2*time.Second, func(ctx context.Context) error {
+		fmt.Println("Stop the server, waiting for the requests in flight...")
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGHUP, syscall.SIGINT} {
+		time.Sleep(time.Second)
+		fmt.Printf("Send signal '%s' to myself...\n", sig)
+		syscall.Kill(os.Getpid(), sig)
+	}
+	// 进程会在关闭钩子执行完之后退出
+	select {}
+}
+
+/**
+使用supervisor包监管两个子进程：ticker一直运行，收到SIGHUP时输出一行；crasher每次运行1秒之后崩溃，它被重启3次之后被标记为失败。
+子进程的输出写入临时目录中的日志文件。SIGHUP被转发给子进程，键入Ctrl-c时由dispatcher的关闭钩子停止所有的子进程
+*/
+func supervisorDemo() {
+	logDir, err := os.MkdirTemp("", "supervisor")
+	if err != nil {
+		fmt.Printf("MkdirTemp Error: %s\n", err)
+		return
+	}
+	fmt.Printf("The logs are in %s\n", logDir)
+	s := supervisor.New(supervisor.WithLogger(log.New(os.Stdout, "[supervisor] ", log.Ltime)))
+	s.Add(supervisor.Spec{
+		Name:    "ticker",
+		Command: "sh",
+		Args:    []string{"-c", `trap "echo reloaded" HUP; while true; do date; sleep 1; done`},
+		LogDir:  logDir,
+	})
+	s.Add(supervisor.Spec{
+		Name:        "crasher",
+		Command:     "sh",
+		Args:        []string{"-c", "sleep 1; echo crashed >&2; exit 1"},
+		MinBackoff:  500 * time.Millisecond,
+		MaxRestarts: 3,
+		LogDir:      logDir,
+	})
+	if err := s.Start(); err != nil {
+		fmt.Printf("Start Error: %s\n", err)
+		return
+	}
+	stopForwarding := s.ForwardSignals(syscall.SIGHUP)
+
+	d := dispatcher.New(dispatcher.WithLogger(log.New(os.Stdout, "[dispatcher] ", log.Ltime)))
+	d.HandleShutdown()
+	d.OnShutdown("supervisor", 3*time.Second, func(ctx context.Context) error {
+		stopForwarding()
+		return s.Stop(ctx)
+	})
+	go func() {
+		time.Sleep(3 * time.Second)
+		fmt.Println("Send signal 'hangup' to myself...")
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	}()
+	for range time.Tick(2 * time.Second) {
+		for _, status := range s.Status() {
+			fmt.Printf("%-8s %-8s pid=%-6d restarts=%d last=%q\n", status.Name, status.State, status.PID, status.Restarts, status.LastError)
+		}
+	}
+}
+
+/**
+发送信号。
+这里只是发送信号之后就不再理会目标进程了；要终止进程并等待它们真正退出，参见killer包和gokill命令
 */
 func sigSendingDemo() {
 	defer func() {
@@ -76,34 +184,27 @@ func sigSendingDemo() {
 			debug.PrintStack()
 		}
 	}()
-	// ps aux | grep "mysignal" | grep -v "grep" | grep -v "go run" | awk '{print $2}'
-	cmds := []*exec.Cmd{
-		exec.Command("ps", "aux"),
-		exec.Command("grep", "mysignal"),
-		exec.Command("grep", "-v", "grep"),
-		exec.Command("grep", "-v", "go run"),
-		exec.Command("awk", "{print $2}"),
-	}
-	output, err := runCmds(cmds)
-	if err != nil {
+	// 最初通过ps aux | grep "mysignal" | grep -v "grep" | grep -v "go run" | awk '{print $2}'查找进程，
+	// 它依赖于ps的输出格式，还会匹配到命令行中恰好包含mysignal的其它进程，比如正在编辑mysignal.go的编辑器。现在直接读取/proc，
+	// ps的输出只用来与/proc中查找到的进程对照
+	if err := printPsLines("mysignal"); err != nil {
 		fmt.Printf("Command Execution Error: %s\n", err)
-		return
 	}
-	pids, err := getPids(output)
+	pids, err := getPids()
 	if err != nil {
-		fmt.Printf("PID Parsing Error: %s\n", err)
+		fmt.Printf("Process Lookup Error: %s\n", err)
 		return
 	}
 	fmt.Printf("Target PID(s):\n%v\n", pids)
 	for _, pid := range pids {
-		proc, err := os.FindProcess(pid)
+		process, err := os.FindProcess(pid)
 		if err != nil {
 			fmt.Printf("Process Finding Error: %s\n", err)
 			return
 		}
 		sig := syscall.SIGQUIT
 		fmt.Printf("Send signal '%s' to the process (pid=%d)...\n", sig, pid)
-		err = proc.Signal(sig)
+		err = process.Signal(sig)
 		if err != nil {
 			fmt.Printf("Signal Sending Error: %s\n", err)
 			return
@@ -111,93 +212,51 @@ func sigSendingDemo() {
 	}
 }
 
-func getPids(strs []string) ([]int, error) {
-	pids := make([]int, 0)
-	for _, str := range strs {
-		pid, err := strconv.Atoi(strings.TrimSpace(str))
-		if err != nil {
-			return nil, err
-		}
-		pids = append(pids, pid)
-	}
-	return pids, nil
-}
-
 /**
-第一命令的输出作为第二个命令的输入，第二个命令的输出又作为第三个命令的输入，以此类推
+查找所有运行当前可执行文件的进程。通过go run执行时，可执行文件被编译到临时目录中，而go run进程本身的可执行文件是go，
+所以不需要再过滤掉go run进程
 */
-func runCmds(cmds []*exec.Cmd) ([]string, error) {
-	if cmds == nil || len(cmds) == 0 {
-		return nil, errors.New("the cmd slice is invalid")
+func getPids() ([]int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
 	}
-	first := true
-	var output []byte
-	var err error
-	for _, cmd := range cmds {
-		fmt.Printf("Run command: %v ...\n", getCmdPlaintext(cmd))
-		// 把前一个命令输出作为当前命令的输入
-		if !first {
-			var stdinBuf bytes.Buffer
-			// 把output写入Buffer
-			stdinBuf.Write(output)
-			cmd.Stdin = &stdinBuf
-		}
-		var stdoutBuf bytes.Buffer
-		cmd.Stdout = &stdoutBuf
-		if err = cmd.Start(); err != nil {
-			return nil, getError(err, cmd)
-		}
-		if err = cmd.Wait(); err != nil {
-			return nil, getError(err, cmd)
-		}
-		output = stdoutBuf.Bytes()
-		if !first {
-			fmt.Printf("Output:\n%s\n", string(output))
-		}
-		if first {
-			first = false
-		}
+	procs, err := proc.Find(proc.ByExe(exe))
+	if err != nil {
+		return nil, err
 	}
-	lines := make([]string, 0)
-	var outputBuf bytes.Buffer
-	outputBuf.Write(output)
-	for {
-		// 读取数据直到遇到换行
-		line, err := outputBuf.ReadBytes('\n')
-		if err != nil {
-			// 所有数据已读完
-			if err == io.EOF {
-				break
-			} else {
-				return nil, getError(err, nil)
-			}
-		}
-		lines = append(lines, string(line))
+	pids := make([]int, 0, len(procs))
+	for _, p := range procs {
+		fmt.Printf("Found process: %s (started at %s)\n", p, p.StartTime.Format("15:04:05"))
+		pids = append(pids, p.PID)
 	}
-	return lines, nil
+	return pids, nil
 }
 
-func getError(err error, cmd *exec.Cmd, extraInfo ...string) error {
-	var errMsg string
-	if cmd != nil {
-		errMsg = fmt.Sprintf("%s  [%s %v]", err, (*cmd).Path, (*cmd).Args)
-	} else {
-		errMsg = fmt.Sprintf("%s", err)
+/**
+通过命令管道ps aux | grep pattern | grep -v grep打印命令行中包含pattern的进程。各个命令同时运行，
+每一行输出在产生的同时就被读取并打印出来，而不是等所有的命令都执行完之后再一次性地处理
+*/
+func printPsLines(pattern string) error {
+	p := cmdpipe.New(
+		exec.Command("ps", "aux"),
+		exec.Command("grep", pattern),
+		exec.Command("grep", "-v", "grep"))
+	stdout, err := p.StdoutPipe()
+	if err != nil {
+		return err
 	}
-	if len(extraInfo) > 0 {
-		errMsg = fmt.Sprintf("%s (%v)", errMsg, extraInfo)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Start(ctx); err != nil {
+		return err
 	}
-	return errors.New(errMsg)
-}
-
-func getCmdPlaintext(cmd *exec.Cmd) string {
-	var buf bytes.Buffer
-	buf.WriteString(cmd.Path)
-	for _, arg := range cmd.Args[1:] {
-		buf.WriteRune(' ')
-		buf.WriteString(arg)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fmt.Printf("ps: %s\n", scanner.Text())
 	}
-	return buf.String()
+	// 与shell的pipefail一样，任何一个命令失败都会导致管道失败，比如grep没有找到匹配的行时退出码为1
+	return p.Wait()
 }
 
 /**
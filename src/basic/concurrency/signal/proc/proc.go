@@ -0,0 +1,301 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+直接读取/proc查找进程，不再需要执行ps aux | grep ... | awk这样的命令管道并解析它们的输出。
+每个进程的信息来自/proc/<pid>/stat、status、cmdline和exe，所以它只能在Linux上使用。
+在读取的过程中退出的进程会被忽略；没有权限读取的信息（比如其它用户的进程的可执行文件）保持为空。
+*/
+
+// 一个进程的信息
+type Process struct {
+	PID  int
+	PPID int
+	// 进程组ID，进程组的组长的PID等于它
+	PGID int
+	// 进程名，即/proc/<pid>/comm，内核把它截断为15个字节
+	Name string
+	// 可执行文件的绝对路径，没有权限读取或者是内核线程时为空
+	Exe string
+	// 命令行参数，内核线程和僵尸进程的命令行为空
+	Cmdline []string
+	// 进程的状态，比如R（运行）、S（睡眠）、D（不可中断的睡眠）、Z（僵尸）、T（停止）
+	State string
+	// 有效用户ID，与ps aux的USER列相同
+	UID int
+	// 进程的启动时间
+	StartTime time.Time
+}
+
+// 进程的所有者的用户名，找不到用户时返回用户ID
+func (p *Process) User() string {
+	uid := strconv.Itoa(p.UID)
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
+
+func (p *Process) String() string {
+	return fmt.Sprintf("%d %s %s", p.PID, p.State, strings.Join(p.Cmdline, " "))
+}
+
+// proc文件系统的挂载点，测试时被替换为一个模拟的目录
+var procRoot = "/proc"
+
+/**
+stat中的时间以时钟滴答为单位。每秒的滴答数是sysconf(_SC_CLK_TCK)，不使用cgo无法取得它，
+但是Linux在所有的平台上都把它固定为USER_HZ，即100
+*/
+const clockTicks = 100
+
+// 列出所有的进程，按照PID排序
+func List() ([]*Process, error) {
+	return Find()
+}
+
+// 列出满足所有过滤条件的进程，按照PID排序
+func Find(filters ...Filter) ([]*Process, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	bootTime, err := readBootTime()
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]*Process, 0)
+	for _, entry := range entries {
+		// /proc中还有很多其它的文件，只有名字是数字的目录代表一个进程
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		p, err := readProcess(pid, bootTime)
+		if err != nil {
+			// 进程在列出之后已经退出了
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH) {
+				continue
+			}
+			return nil, err
+		}
+		if match(p, filters) {
+			procs = append(procs, p)
+		}
+	}
+	// ReadDir按照名字排序，与PID的顺序不同
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs, nil
+}
+
+// 取得给定的进程的信息，进程不存在时返回的错误满足errors.Is(err, fs.ErrNotExist)
+func Get(pid int) (*Process, error) {
+	bootTime, err := readBootTime()
+	if err != nil {
+		return nil, err
+	}
+	return readProcess(pid, bootTime)
+}
+
+// 进程的过滤条件
+type Filter func(p *Process) bool
+
+// 进程名或者命令行的第一个参数的文件名等于name。进程名可能被截断，所以长的名字要通过命令行匹配
+func ByName(name string) Filter {
+	return func(p *Process) bool {
+		return p.Name == name || (len(p.Cmdline) > 0 && filepath.Base(p.Cmdline[0]) == name)
+	}
+}
+
+// 可执行文件的路径等于path，path应该是绝对路径，比如os.Executable的返回值
+func ByExe(path string) Filter {
+	return func(p *Process) bool {
+		return p.Exe != "" && p.Exe == path
+	}
+}
+
+// 以空格连接的命令行匹配re
+func ByCmdline(re *regexp.Regexp) Filter {
+	return func(p *Process) bool {
+		return len(p.Cmdline) > 0 && re.MatchString(strings.Join(p.Cmdline, " "))
+	}
+}
+
+// 父进程是ppid
+func ByParent(ppid int) Filter {
+	return func(p *Process) bool {
+		return p.PPID == ppid
+	}
+}
+
+// 属于进程组pgid
+func ByGroup(pgid int) Filter {
+	return func(p *Process) bool {
+		return p.PGID == pgid
+	}
+}
+
+// 有效用户ID是uid
+func ByUID(uid int) Filter {
+	return func(p *Process) bool {
+		return p.UID == uid
+	}
+}
+
+// 所有者是给定的用户，用户名不存在时返回错误
+func ByUser(username string) (Filter, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	return ByUID(uid), nil
+}
+
+// 不满足给定的条件，比如Not(ByParent(1))
+func Not(f Filter) Filter {
+	return func(p *Process) bool {
+		return !f(p)
+	}
+}
+
+func match(p *Process, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func readProcess(pid int, bootTime time.Time) (*Process, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	p := &Process{PID: pid}
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	var startTicks uint64
+	if startTicks, err = parseStat(stat, p); err != nil {
+		return nil, fmt.Errorf("proc: %s/stat: %s", dir, err)
+	}
+	p.StartTime = bootTime.Add(time.Duration(startTicks) * time.Second / clockTicks)
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	if p.UID, err = parseUID(status); err != nil {
+		return nil, fmt.Errorf("proc: %s/status: %s", dir, err)
+	}
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	p.Cmdline = parseCmdline(cmdline)
+	// 没有权限或者是内核线程时读取失败，这不是错误
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		// 可执行文件在进程启动之后被删除或者替换时带有这个后缀
+		p.Exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	return p, nil
+}
+
+/**
+解析/proc/<pid>/stat，比如"1234 (my prog) S 1 1234 ..."，把结果写入p并返回启动时间。进程名可以包含空格和括号，所以它的结尾是最后一个右括号，
+之后的字段以空格分隔：第3个字段是状态，第4个是父进程ID，第5个是进程组ID，第22个是自系统启动以来的启动时间
+*/
+func parseStat(stat []byte, p *Process) (startTicks uint64, err error) {
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return 0, errors.New("malformed process name")
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	// fields[0]是第3个字段
+	if len(fields) < 20 {
+		return 0, errors.New("too few fields")
+	}
+	if p.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, err
+	}
+	if p.PGID, err = strconv.Atoi(fields[2]); err != nil {
+		return 0, err
+	}
+	if startTicks, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return 0, err
+	}
+	p.Name, p.State = string(stat[open+1:end]), fields[0]
+	return startTicks, nil
+}
+
+// 从/proc/<pid>/status的"Uid:"一行中取得有效用户ID，这一行依次是实际、有效、保存和文件系统用户ID
+func parseUID(status []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "Uid:" {
+			return strconv.Atoi(fields[2])
+		}
+	}
+	return 0, errors.New("no Uid line")
+}
+
+// 命令行的每个参数都以'\0'结尾
+func parseCmdline(cmdline []byte) []string {
+	cmdline = bytes.TrimRight(cmdline, "\x00")
+	if len(cmdline) == 0 {
+		return nil
+	}
+	return strings.Split(string(cmdline), "\x00")
+}
+
+var bootTimeCache struct {
+	sync.Mutex
+	root string
+	time time.Time
+}
+
+// 系统的启动时间，即/proc/stat中的btime，它在系统运行期间不会变化
+func readBootTime() (time.Time, error) {
+	bootTimeCache.Lock()
+	defer bootTimeCache.Unlock()
+	if bootTimeCache.root == procRoot {
+		return bootTimeCache.time, nil
+	}
+	data, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			sec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			bootTimeCache.root, bootTimeCache.time = procRoot, time.Unix(sec, 0)
+			return bootTimeCache.time, nil
+		}
+	}
+	return time.Time{}, errors.New("proc: no btime in " + procRoot + "/stat")
+}
@@ -0,0 +1,152 @@
+package proc
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSelf(t *testing.T) {
+	p, err := Get(os.Getpid())
+	if err != nil {
+		t.Fatalf("Get Error: %s", err)
+	}
+	exe, _ := os.Executable()
+	if p.PID != os.Getpid() || p.PPID != os.Getppid() || p.PGID != syscall.Getpgrp() || p.Exe != exe || p.UID != os.Geteuid() {
+		t.Fatalf("ERROR: The process is %+v", p)
+	}
+	if len(p.Cmdline) != len(os.Args) || p.Cmdline[0] != os.Args[0] {
+		t.Fatalf("ERROR: The cmdline is %q, expected %q", p.Cmdline, os.Args)
+	}
+	if p.State != "R" && p.State != "S" {
+		t.Fatalf("ERROR: The state is %s", p.State)
+	}
+	// 启动时间的精度是10ms
+	if age := time.Since(p.StartTime); age < -time.Second || age > 10*time.Minute {
+		t.Fatalf("ERROR: The process started at %v", p.StartTime)
+	}
+}
+
+func TestFind(t *testing.T) {
+	cmd := exec.Command("sleep", "30.123")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	sleep, _ := exec.LookPath("sleep")
+	sleep, _ = filepath.EvalSymlinks(sleep)
+
+	find := func(filters ...Filter) []*Process {
+		procs, err := Find(filters...)
+		if err != nil {
+			t.Fatalf("Find Error: %s", err)
+		}
+		return procs
+	}
+	children := find(ByParent(os.Getpid()))
+	if len(children) != 1 || children[0].PID != cmd.Process.Pid {
+		t.Fatalf("ERROR: The children are %v", children)
+	}
+	child := children[0]
+	if child.Name != "sleep" || len(child.Cmdline) != 2 || child.Cmdline[1] != "30.123" {
+		t.Fatalf("ERROR: The child is %+v", child)
+	}
+	for name, filters := range map[string][]Filter{
+		"name":    {ByName("sleep"), ByParent(os.Getpid())},
+		"exe":     {ByExe(sleep), ByParent(os.Getpid())},
+		"cmdline": {ByCmdline(regexp.MustCompile(`^sleep 30\.123$`))},
+		"uid":     {ByUID(os.Geteuid()), ByCmdline(regexp.MustCompile(`30\.123`))},
+		"group":   {ByGroup(syscall.Getpgrp()), ByParent(os.Getpid())},
+	} {
+		if procs := find(filters...); len(procs) != 1 || procs[0].PID != child.PID {
+			t.Fatalf("ERROR: The processes found by %s are %v", name, procs)
+		}
+	}
+	if procs := find(ByParent(os.Getpid()), Not(ByName("sleep"))); len(procs) != 0 {
+		t.Fatalf("ERROR: The processes are %v", procs)
+	}
+	if u, err := ByUser(child.User()); err != nil {
+		t.Fatalf("ByUser Error: %s", err)
+	} else if len(find(u, ByParent(os.Getpid()))) != 1 {
+		t.Fatal("ERROR: The child is not found by its user!")
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if _, err := Get(child.PID); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ERROR: The error of an exited process is %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	procs, err := List()
+	if err != nil {
+		t.Fatalf("List Error: %s", err)
+	}
+	var self bool
+	for i, p := range procs {
+		if i > 0 && p.PID <= procs[i-1].PID {
+			t.Fatalf("ERROR: The processes are not sorted: %d, %d", procs[i-1].PID, p.PID)
+		}
+		self = self || p.PID == os.Getpid()
+	}
+	if !self {
+		t.Fatal("ERROR: The current process is not listed!")
+	}
+}
+
+// 在模拟的/proc中测试进程名中的空格和括号、内核线程以及已经退出的进程
+func TestParse(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("stat", "cpu  1 2 3\nbtime 1700000000\nprocesses 10\n")
+	write("42/stat", "42 (my (odd) prog) S 7 42 42 0 -1 4194304 1 0 0 0 0 0 0 0 20 0 1 0 250 1000 10\n")
+	write("42/status", "Name:\tmy (odd) prog\nUid:\t1000\t1001\t1000\t1000\n")
+	write("42/cmdline", "/opt/my prog\x00--flag\x00\x00")
+	write("2/stat", "2 (kthreadd) S 0 0 0 0 -1 2129984 0 0 0 0 0 0 0 0 20 0 1 0 3 0 0\n")
+	write("2/status", "Name:\tkthreadd\nUid:\t0\t0\t0\t0\n")
+	write("2/cmdline", "")
+	// 只剩下目录的进程被忽略
+	os.MkdirAll(filepath.Join(root, "99"), 0755)
+	os.MkdirAll(filepath.Join(root, "self"), 0755)
+	defer func(old string) { procRoot = old }(procRoot)
+	procRoot = root
+
+	procs, err := List()
+	if err != nil {
+		t.Fatalf("List Error: %s", err)
+	}
+	if len(procs) != 2 || procs[0].PID != 2 || procs[1].PID != 42 {
+		t.Fatalf("ERROR: The processes are %v", procs)
+	}
+	p := procs[1]
+	expected := time.Unix(1700000002, 500000000)
+	if p.Name != "my (odd) prog" || p.State != "S" || p.PPID != 7 || p.PGID != 42 || p.UID != 1001 || !p.StartTime.Equal(expected) || p.Exe != "" {
+		t.Fatalf("ERROR: The process is %+v", p)
+	}
+	if len(p.Cmdline) != 2 || p.Cmdline[0] != "/opt/my prog" || p.Cmdline[1] != "--flag" {
+		t.Fatalf("ERROR: The cmdline is %q", p.Cmdline)
+	}
+	if procs[0].Cmdline != nil {
+		t.Fatalf("ERROR: The cmdline of the kernel thread is %q", procs[0].Cmdline)
+	}
+	if procs, _ := Find(ByName("my prog")); len(procs) != 1 {
+		t.Fatalf("ERROR: The process is not found by the name of its executable: %v", procs)
+	}
+	if _, err := parseStat([]byte("42 (prog S 7"), new(Process)); err == nil {
+		t.Fatal("ERROR: A malformed stat is parsed!")
+	}
+}
@@ -0,0 +1,505 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+子进程的监管者。
+每个子进程由一个Goroutine负责：启动它，等待它退出，然后按照指数退避的时间间隔重启它。如果在一个时间窗口内重启的次数超过了上限，
+说明它一启动就会崩溃，重启没有意义，所以不再重启而是把它标记为失败。
+子进程的标准输出和标准错误被写入按照大小轮转的日志文件，它们的状态可以通过Status或者StatusHandler查询。
+每个子进程都在自己的进程组中，所以发给它的信号也会发给它启动的进程，Stop时不会留下孤儿进程；
+这也意味着终端的Ctrl-c只会发给监管者，由监管者决定如何停止子进程。
+*/
+
+// 一个子进程的声明
+type Spec struct {
+	// 子进程的名字，在监管者中唯一，也用作日志文件的名字
+	Name    string
+	Command string
+	Args    []string
+	// 追加到监管者的环境变量之后的环境变量，形式是"key=value"
+	Env []string
+	// 工作目录，为空时使用监管者的工作目录
+	Dir string
+
+	// 为true时正常退出（退出码为0）的子进程也会被重启
+	RestartAlways bool
+	// 重启的最小和最大退避时间，默认是DefaultMinBackoff和DefaultMaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// 在RestartWindow内最多重启MaxRestarts次，默认是DefaultMaxRestarts和DefaultRestartWindow
+	MaxRestarts   int
+	RestartWindow time.Duration
+
+	// 日志文件所在的目录，为空时丢弃子进程的输出。标准输出和标准错误分别写入<Name>.stdout.log和<Name>.stderr.log
+	LogDir string
+	// 日志文件的最大字节数和保留的旧日志文件的数量，默认是DefaultMaxLogSize和DefaultMaxLogFiles
+	MaxLogSize  int64
+	MaxLogFiles int
+}
+
+const (
+	DefaultMinBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff    = 30 * time.Second
+	DefaultMaxRestarts   = 5
+	DefaultRestartWindow = time.Minute
+	DefaultMaxLogSize    = 10 << 20
+	DefaultMaxLogFiles   = 3
+)
+
+/**
+子进程退出之后等待它的输出管道关闭的最长时间。日志文件不是*os.File，exec通过Goroutine复制子进程的输出，
+Wait要等到所有持有管道的进程都退出才返回；崩溃的子进程留下的后台进程（比如sh -c 'sleep 1000 & exit 1'中的sleep）
+会让Wait一直阻塞，子进程的退出永远不会被发现，也就不会被重启。参见exec.Cmd.WaitDelay
+*/
+const waitDelay = time.Second
+
+// 子进程的状态
+type State string
+
+const (
+	// 正在运行
+	StateRunning State = "running"
+	// 已经退出，正在等待重启
+	StateBackoff State = "backoff"
+	// 正常退出并且不需要重启
+	StateExited State = "exited"
+	// 重启的次数超过了上限，不再重启
+	StateFailed State = "failed"
+	// 被Stop停止
+	StateStopped State = "stopped"
+)
+
+// 子进程的状态的快照
+type Status struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+	// 正在运行的进程的ID，没有运行时是0
+	PID int `json:"pid,omitempty"`
+	// 累计重启的次数
+	Restarts int `json:"restarts"`
+	// 最近一次启动的时间
+	StartedAt time.Time `json:"started_at"`
+	// 最近一次退出的退出码和原因，比如"exit status 1"或者"signal: killed"，启动失败时退出码是-1
+	LastExitCode int    `json:"last_exit_code"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// 日志记录器，*log.Logger满足这个接口
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// 监管者的可选项
+type Option func(s *Supervisor)
+
+// 设置日志记录器，默认不记录日志
+func WithLogger(l Logger) Option {
+	return func(s *Supervisor) {
+		s.logger = l
+	}
+}
+
+// 监管者已被停止时Add返回的错误
+var ErrStopped = errors.New("supervisor: stopped")
+
+type Supervisor struct {
+	logger Logger
+
+	mu       sync.Mutex
+	children map[string]*child
+	started  bool
+	// 在Stop时被关闭
+	stopping chan struct{}
+	wg       sync.WaitGroup
+}
+
+type child struct {
+	spec   Spec
+	status Status
+	cmd    *exec.Cmd
+	stdout *rotatingFile
+	stderr *rotatingFile
+	// 每次重启的时间，只保留重启窗口内的
+	restartTimes []time.Time
+}
+
+func New(opts ...Option) *Supervisor {
+	s := &Supervisor{
+		children: make(map[string]*child),
+		stopping: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// 添加一个子进程。监管者已经启动时立即启动它，否则在Start时启动
+func (s *Supervisor) Add(spec Spec) error {
+	if spec.Name == "" || spec.Command == "" {
+		return errors.New("supervisor: the name and the command are required")
+	}
+	setDefaults(&spec)
+	c := &child{spec: spec, status: Status{Name: spec.Name}}
+	if spec.LogDir != "" {
+		var err error
+		if c.stdout, err = openRotatingFile(filepath.Join(spec.LogDir, spec.Name+".stdout.log"), spec.MaxLogSize, spec.MaxLogFiles); err != nil {
+			return err
+		}
+		if c.stderr, err = openRotatingFile(filepath.Join(spec.LogDir, spec.Name+".stderr.log"), spec.MaxLogSize, spec.MaxLogFiles); err != nil {
+			c.stdout.Close()
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stopping:
+		c.closeLogs()
+		return ErrStopped
+	default:
+	}
+	if _, ok := s.children[spec.Name]; ok {
+		c.closeLogs()
+		return fmt.Errorf("supervisor: duplicate child %q", spec.Name)
+	}
+	s.children[spec.Name] = c
+	if s.started {
+		s.wg.Add(1)
+		go s.supervise(c)
+	}
+	return nil
+}
+
+func setDefaults(spec *Spec) {
+	if spec.MinBackoff <= 0 {
+		spec.MinBackoff = DefaultMinBackoff
+	}
+	if spec.MaxBackoff <= 0 {
+		spec.MaxBackoff = DefaultMaxBackoff
+	}
+	if spec.MaxRestarts <= 0 {
+		spec.MaxRestarts = DefaultMaxRestarts
+	}
+	if spec.RestartWindow <= 0 {
+		spec.RestartWindow = DefaultRestartWindow
+	}
+	if spec.MaxLogSize <= 0 {
+		spec.MaxLogSize = DefaultMaxLogSize
+	}
+	if spec.MaxLogFiles <= 0 {
+		spec.MaxLogFiles = DefaultMaxLogFiles
+	}
+}
+
+// 启动所有已经添加的子进程
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stopping:
+		return ErrStopped
+	default:
+	}
+	if s.started {
+		return errors.New("supervisor: already started")
+	}
+	s.started = true
+	for _, c := range s.children {
+		s.wg.Add(1)
+		go s.supervise(c)
+	}
+	return nil
+}
+
+// 向所有正在运行的子进程（和它们的进程组）发送信号
+func (s *Supervisor) Signal(sig syscall.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.children {
+		c.signal(sig)
+	}
+}
+
+/**
+把监管者收到的信号转发给所有的子进程，没有给定信号时转发SIGHUP、SIGUSR1和SIGUSR2。返回的函数用来停止转发。
+因为转发的信号而退出的子进程会被重启，所以停止子进程应该使用Stop，而不是转发SIGTERM。
+*/
+func (s *Supervisor) ForwardSignals(sigs ...syscall.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+	}
+	sigRecv := make(chan os.Signal, len(sigs))
+	for _, sig := range sigs {
+		signal.Notify(sigRecv, sig)
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case sig := <-sigRecv:
+				s.logf("Forward signal %s to the children.", sig)
+				s.Signal(sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigRecv)
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+/**
+停止所有的子进程：先发送SIGTERM，等待它们退出；如果在此之前ctx被取消，那么就发送SIGKILL并返回ctx的错误。
+停止之后子进程不会再被重启，监管者也不能再被使用。
+*/
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
+	}
+	for _, c := range s.children {
+		c.signal(syscall.SIGTERM)
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.Signal(syscall.SIGKILL)
+		<-finished
+	}
+	s.mu.Lock()
+	for _, c := range s.children {
+		c.closeLogs()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// 所有子进程的状态，按照名字排序
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.children))
+	for _, c := range s.children {
+		statuses = append(statuses, c.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// 给定的子进程的状态
+func (s *Supervisor) StatusOf(name string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.children[name]
+	if !ok {
+		return Status{}, false
+	}
+	return c.status, true
+}
+
+/**
+返回以JSON格式输出子进程的状态的http.Handler，比如：
+	http.Handle("/children", supervisor.StatusHandler())
+请求带有name参数时只输出这个子进程的状态，找不到时返回404
+*/
+func (s *Supervisor) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v interface{} = s.Status()
+		if name := r.URL.Query().Get("name"); name != "" {
+			status, ok := s.StatusOf(name)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			v = status
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	})
+}
+
+// 负责一个子进程的Goroutine：启动、等待退出、按照退避时间重启，直到它不需要再被重启或者监管者被停止为止
+func (s *Supervisor) supervise(c *child) {
+	defer s.wg.Done()
+	for {
+		err := s.start(c)
+		if err == nil {
+			pid := c.cmd.Process.Pid
+			s.logf("Started %s. (pid: %d)", c.spec.Name, pid)
+			err = c.cmd.Wait()
+			// 杀死子进程留在它的进程组中的后台进程，否则每次重启都会多出一批无人管理的进程，并且它们仍然持有旧的日志文件
+			syscall.Kill(-pid, syscall.SIGKILL)
+		}
+		s.mu.Lock()
+		c.cmd = nil
+		c.status.PID = 0
+		c.status.LastExitCode = -1
+		c.status.LastError = ""
+		if err != nil {
+			c.status.LastError = err.Error()
+		}
+		var exitErr *exec.ExitError
+		if err == nil {
+			c.status.LastExitCode = 0
+		} else if errors.As(err, &exitErr) {
+			c.status.LastExitCode = exitErr.ExitCode()
+		}
+		next, stopped := s.nextState(c, err)
+		c.status.State = next
+		backoff := c.backoff()
+		s.mu.Unlock()
+		s.logf("%s exited: %v (state: %s)", c.spec.Name, err, next)
+		if stopped || next != StateBackoff {
+			return
+		}
+		if !s.sleep(backoff) {
+			s.mu.Lock()
+			c.status.State = StateStopped
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Lock()
+		c.status.Restarts++
+		s.mu.Unlock()
+	}
+}
+
+// 子进程退出之后的状态，调用时持有锁。第二个返回值表示监管者是否正在停止
+func (s *Supervisor) nextState(c *child, err error) (State, bool) {
+	select {
+	case <-s.stopping:
+		return StateStopped, true
+	default:
+	}
+	if err == nil && !c.spec.RestartAlways {
+		return StateExited, false
+	}
+	now := time.Now()
+	recent := c.restartTimes[:0]
+	for _, t := range c.restartTimes {
+		if now.Sub(t) < c.spec.RestartWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.restartTimes = recent
+	if len(c.restartTimes) >= c.spec.MaxRestarts {
+		return StateFailed, false
+	}
+	c.restartTimes = append(c.restartTimes, now)
+	return StateBackoff, false
+}
+
+/**
+退避时间随着重启窗口内的重启次数翻倍：MinBackoff、2*MinBackoff、4*MinBackoff...，最多是MaxBackoff。
+子进程稳定运行了一个重启窗口之后，之前的重启不再计数，退避时间也从MinBackoff重新开始。调用时持有锁
+*/
+func (c *child) backoff() time.Duration {
+	backoff := c.spec.MinBackoff
+	for i := 1; i < len(c.restartTimes) && backoff < c.spec.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.spec.MaxBackoff {
+		backoff = c.spec.MaxBackoff
+	}
+	return backoff
+}
+
+func (s *Supervisor) start(c *child) error {
+	cmd := exec.Command(c.spec.Command, c.spec.Args...)
+	cmd.Dir = c.spec.Dir
+	if len(c.spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.spec.Env...)
+	}
+	// 子进程在自己的进程组中，进程组ID等于它的进程ID
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if c.stdout != nil {
+		cmd.Stdout, cmd.Stderr = c.stdout, c.stderr
+		cmd.WaitDelay = waitDelay
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stopping:
+		return ErrStopped
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.cmd = cmd
+	c.status.State = StateRunning
+	c.status.PID = cmd.Process.Pid
+	c.status.StartedAt = time.Now()
+	return nil
+}
+
+// 等待退避时间，监管者被停止时返回false
+func (s *Supervisor) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.stopping:
+		return false
+	}
+}
+
+// 向子进程的进程组发送信号，调用时持有锁
+func (c *child) signal(sig syscall.Signal) {
+	if c.cmd != nil && c.cmd.Process != nil {
+		// 进程可能已经退出了，此时的错误可以忽略
+		syscall.Kill(-c.cmd.Process.Pid, sig)
+	}
+}
+
+func (c *child) closeLogs() {
+	if c.stdout != nil {
+		c.stdout.Close()
+		c.stderr.Close()
+	}
+}
+
+func (s *Supervisor) logf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,231 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 等待条件成立，超时时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("ERROR: Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readLog(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func stop(t *testing.T, s *Supervisor) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop Error: %s", err)
+	}
+}
+
+// 一直崩溃的子进程按照指数退避的时间间隔被重启，超过重启的上限之后被标记为失败
+func TestRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := New()
+	err := s.Add(Spec{
+		Name:        "crasher",
+		Command:     "sh",
+		Args:        []string{"-c", `echo "started in $PWD with $GREETING"; echo oops >&2; exit 3`},
+		Env:         []string{"GREETING=hello"},
+		Dir:         dir,
+		MinBackoff:  20 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+		MaxRestarts: 3,
+		LogDir:      dir,
+	})
+	if err != nil {
+		t.Fatalf("Add Error: %s", err)
+	}
+	begin := time.Now()
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start Error: %s", err)
+	}
+	defer stop(t, s)
+	waitFor(t, "the failure", func() bool {
+		status, _ := s.StatusOf("crasher")
+		return status.State == StateFailed
+	})
+	// 退避时间依次是20ms、40ms和50ms
+	if elapsed := time.Since(begin); elapsed < 110*time.Millisecond {
+		t.Fatalf("ERROR: The child is restarted 3 times in %v", elapsed)
+	}
+	status, _ := s.StatusOf("crasher")
+	if status.Restarts != 3 || status.LastExitCode != 3 || status.LastError != "exit status 3" || status.PID != 0 {
+		t.Fatalf("ERROR: The status is %+v", status)
+	}
+	stdout := readLog(t, filepath.Join(dir, "crasher.stdout.log"))
+	if expected := strings.Repeat("started in "+dir+" with hello\n", 4); stdout != expected {
+		t.Fatalf("ERROR: The stdout is %q, expected %q", stdout, expected)
+	}
+	if stderr := readLog(t, filepath.Join(dir, "crasher.stderr.log")); stderr != strings.Repeat("oops\n", 4) {
+		t.Fatalf("ERROR: The stderr is %q", stderr)
+	}
+}
+
+// 正常退出的子进程默认不被重启，RestartAlways为true时被重启
+func TestCleanExit(t *testing.T) {
+	s := New()
+	s.Add(Spec{Name: "once", Command: "true"})
+	s.Add(Spec{Name: "always", Command: "true", RestartAlways: true, MinBackoff: time.Millisecond, MaxRestarts: 2})
+	s.Add(Spec{Name: "missing", Command: "/no/such/command", MinBackoff: time.Millisecond, MaxRestarts: 1})
+	s.Start()
+	defer stop(t, s)
+	waitFor(t, "the exits", func() bool {
+		statuses := s.Status()
+		return statuses[0].State == StateFailed && statuses[1].State == StateFailed && statuses[2].State == StateExited
+	})
+	statuses := s.Status()
+	if statuses[0].Name != "always" || statuses[0].Restarts != 2 || statuses[0].LastExitCode != 0 {
+		t.Fatalf("ERROR: The status is %+v", statuses[0])
+	}
+	if statuses[1].Name != "missing" || statuses[1].LastExitCode != -1 || statuses[1].LastError == "" {
+		t.Fatalf("ERROR: The status is %+v", statuses[1])
+	}
+	if statuses[2].Name != "once" || statuses[2].Restarts != 0 {
+		t.Fatalf("ERROR: The status is %+v", statuses[2])
+	}
+	if err := s.Add(Spec{Name: "once", Command: "true"}); err == nil {
+		t.Fatal("ERROR: A duplicate child is added!")
+	}
+}
+
+// 崩溃的子进程留下了持有输出管道的后台进程时，它的退出仍然会被发现，并且它被重启
+func TestOrphanedGrandchild(t *testing.T) {
+	dir := t.TempDir()
+	s := New()
+	s.Add(Spec{
+		Name:        "orphan",
+		Command:     "sh",
+		Args:        []string{"-c", "sleep 1000 & exit 1"},
+		MinBackoff:  time.Millisecond,
+		MaxRestarts: 1,
+		LogDir:      dir,
+	})
+	s.Start()
+	defer stop(t, s)
+	waitFor(t, "the failure", func() bool {
+		status, _ := s.StatusOf("orphan")
+		return status.State == StateFailed
+	})
+	if status, _ := s.StatusOf("orphan"); status.Restarts != 1 || status.LastExitCode != 1 {
+		t.Fatalf("ERROR: The status is %+v", status)
+	}
+}
+
+// 信号被转发给子进程，被停止的子进程不会被重启
+func TestSignalAndStop(t *testing.T) {
+	dir := t.TempDir()
+	s := New()
+	s.Add(Spec{
+		Name:    "trapper",
+		Command: "sh",
+		Args:    []string{"-c", `trap "echo got HUP" HUP; echo ready; while true; do sleep 0.01; done`},
+		LogDir:  dir,
+	})
+	// 忽略SIGTERM的子进程在Stop超时之后被杀死，它也忽略下面的SIGHUP
+	s.Add(Spec{Name: "stubborn", Command: "sh", Args: []string{"-c", `trap "" TERM HUP; echo ready; while true; do sleep 0.01; done`}, LogDir: dir})
+	s.Start()
+	logPath := filepath.Join(dir, "trapper.stdout.log")
+	waitFor(t, "the children", func() bool {
+		return readLog(t, logPath) == "ready\n" && readLog(t, filepath.Join(dir, "stubborn.stdout.log")) == "ready\n"
+	})
+	s.Signal(syscall.SIGHUP)
+	waitFor(t, "the trap", func() bool { return strings.Contains(readLog(t, logPath), "got HUP") })
+	if status, _ := s.StatusOf("trapper"); status.State != StateRunning || status.Restarts != 0 || status.PID == 0 {
+		t.Fatalf("ERROR: The status is %+v", status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := s.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: Stop returns %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Fatalf("ERROR: Stop takes %v", elapsed)
+	}
+	for _, status := range s.Status() {
+		if status.State != StateStopped || status.Restarts != 0 {
+			t.Fatalf("ERROR: The status is %+v", status)
+		}
+	}
+	if err := s.Add(Spec{Name: "late", Command: "true"}); err != ErrStopped {
+		t.Fatalf("ERROR: Add returns %v after Stop", err)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	s := New()
+	s.Add(Spec{Name: "sleeper", Command: "sleep", Args: []string{"30"}})
+	s.Start()
+	defer stop(t, s)
+	waitFor(t, "the child", func() bool {
+		status, _ := s.StatusOf("sleeper")
+		return status.State == StateRunning
+	})
+
+	rec := httptest.NewRecorder()
+	s.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/children", nil))
+	var statuses []Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("ERROR: The response %q is not JSON: %s", rec.Body, err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "sleeper" || statuses[0].State != StateRunning || statuses[0].PID == 0 {
+		t.Fatalf("ERROR: The statuses are %+v", statuses)
+	}
+	rec = httptest.NewRecorder()
+	s.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/children?name=nobody", nil))
+	if rec.Code != 404 {
+		t.Fatalf("ERROR: The status code is %d", rec.Code)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write Error: %s", err)
+		}
+	}
+	r.Close()
+	for name, expected := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		if content := readLog(t, name); content != expected {
+			t.Fatalf("ERROR: The content of %s is %q, expected %q", name, content, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("ERROR: Too many files are kept!")
+	}
+	if _, err := r.Write([]byte("x")); err != os.ErrClosed {
+		t.Fatalf("ERROR: Write returns %v after Close", err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"basic/concurrency/socket/sock"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+/**
+压测的执行和统计。
+每个并发的客户端使用自己的连接，不断地发送请求直到达到持续时间或者请求总数为止。给定了目标速率时，所有客户端共享一个发送计划：
+第i个请求在开始之后的i/rate秒发送，客户端依次领取下一个请求，所以总速率不会超过目标速率，而某个客户端变慢时其它客户端可以补上。
+每个成功的请求的耗时都被记录下来，结束之后排序得到精确的分位数。失败的请求只按照错误的分类计数，不计入耗时，
+否则立即失败的请求会把分位数拉低，让一个已经出错的服务端看起来更快。
+连接出错之后客户端上的所有调用都会立即失败，所以客户端会关闭旧的连接并重新连接；重新连接也失败时这个客户端停止发送请求，
+而不是在一个空转的循环里不断地记录连接错误。
+*/
+
+// 请求参数中的这个占位符会被替换为一个随机的非负32位整数
+const randPlaceholder = "{rand}"
+
+type config struct {
+	network string
+	addr    string
+	framer  sock.Framer
+	// 每个客户端的可选项
+	clientOpts []sock.ClientOption
+	// 并发的客户端的数量
+	clients int
+	// 持续时间和请求总数，先达到哪一个就在哪里停止，0表示不限制，但是不能都是0
+	duration time.Duration
+	requests int64
+	// 每秒发送的请求总数，0表示不限制
+	rate float64
+	// 每个请求的超时时间，0表示不超时
+	timeout time.Duration
+	// 每个请求的命令
+	command string
+	args    []string
+	token   string
+}
+
+// 压测的结果
+type report struct {
+	Clients    int            `json:"clients"`
+	Elapsed    float64        `json:"elapsed_seconds"`
+	Requests   int            `json:"requests"`
+	Successes  int            `json:"successes"`
+	Errors     map[string]int `json:"errors"`
+	Throughput float64        `json:"requests_per_second"`
+	// 成功的请求的耗时，以毫秒为单位
+	Latency latencySummary `json:"latency_ms"`
+}
+
+type latencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// 一个客户端的结果
+type clientResult struct {
+	// 成功的请求的耗时
+	latencies []time.Duration
+	errors    map[string]int
+}
+
+func run(ctx context.Context, cfg *config) (*report, error) {
+	if cfg.duration <= 0 && cfg.requests <= 0 {
+		return nil, errors.New("either the duration or the number of requests must be positive")
+	}
+	if cfg.clients <= 0 {
+		cfg.clients = 1
+	}
+	clients := make([]*sock.Client, cfg.clients)
+	defer func() {
+		for _, client := range clients {
+			if client != nil {
+				client.Close()
+			}
+		}
+	}()
+	for i := range clients {
+		client, err := dial(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("client %d: %s", i, err)
+		}
+		clients[i] = client
+	}
+
+	if cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+	// 已经领取的请求的数量
+	var issued int64
+	begin := time.Now()
+	results := make([]clientResult, cfg.clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(client **sock.Client, result *clientResult) {
+			defer wg.Done()
+			result.errors = make(map[string]int)
+			rnd := rand.New(rand.NewSource(rand.Int63()))
+			for {
+				n := atomic.AddInt64(&issued, 1)
+				if cfg.requests > 0 && n > cfg.requests {
+					return
+				}
+				if cfg.rate > 0 && !sleepUntil(ctx, begin.Add(time.Duration(float64(n-1)/cfg.rate*float64(time.Second)))) {
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				req := sock.EncodeCommand(&sock.Command{Name: cfg.command, Args: expandArgs(cfg.args, rnd), Token: cfg.token})
+				sent := time.Now()
+				_, err := call(ctx, *client, req, cfg.timeout)
+				// 持续时间结束时失败的请求是被中断的，不计入结果
+				if err != nil && interrupted(ctx) {
+					return
+				}
+				if err == nil {
+					result.latencies = append(result.latencies, time.Since(sent))
+					continue
+				}
+				class := errorClass(err)
+				result.errors[class]++
+				if class != "connection" {
+					continue
+				}
+				(*client).Close()
+				redialed, err := dial(cfg)
+				if err != nil {
+					result.errors["dial"]++
+					return
+				}
+				*client = redialed
+			}
+		}(&clients[i], &results[i])
+	}
+	wg.Wait()
+	return summarize(cfg.clients, time.Since(begin), results), nil
+}
+
+func dial(cfg *config) (*sock.Client, error) {
+	opts := append([]sock.ClientOption{sock.WithClientNetwork(cfg.network)}, cfg.clientOpts...)
+	return sock.DialClient(cfg.addr, cfg.framer, 5*time.Second, opts...)
+}
+
+func call(ctx context.Context, client *sock.Client, req []byte, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return client.Call(ctx, req)
+}
+
+// ctx是否已经被取消。连接的读写超时与ctx的截止时间相同，它可能在ctx被取消之前先到期，所以还要比较截止时间
+func interrupted(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// 等待到给定的时间，ctx先被取消时返回false
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func expandArgs(args []string, rnd *rand.Rand) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = strings.Replace(arg, randPlaceholder, strconv.Itoa(int(rnd.Int31())), -1)
+	}
+	return expanded
+}
+
+// 错误的分类：错误响应按照它的种类分类，其它的错误是超时或者连接的错误。重新连接失败另外记为dial
+func errorClass(err error) string {
+	var e *sock.Error
+	switch {
+	case errors.As(err, &e):
+		return e.Kind.String()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "connection"
+	}
+}
+
+func summarize(clients int, elapsed time.Duration, results []clientResult) *report {
+	r := &report{Clients: clients, Elapsed: elapsed.Seconds(), Errors: make(map[string]int)}
+	var latencies []time.Duration
+	failures := 0
+	for _, result := range results {
+		latencies = append(latencies, result.latencies...)
+		for class, n := range result.errors {
+			r.Errors[class] += n
+			failures += n
+		}
+	}
+	r.Successes = len(latencies)
+	r.Requests = r.Successes + failures
+	if elapsed > 0 {
+		r.Throughput = float64(r.Requests) / elapsed.Seconds()
+	}
+	r.Latency = summarizeLatencies(latencies)
+	return r
+}
+
+func summarizeLatencies(latencies []time.Duration) latencySummary {
+	if len(latencies) == 0 {
+		return latencySummary{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	return latencySummary{
+		Min:  millis(latencies[0]),
+		Mean: millis(sum / time.Duration(len(latencies))),
+		P50:  millis(percentile(latencies, 50)),
+		P90:  millis(percentile(latencies, 90)),
+		P99:  millis(percentile(latencies, 99)),
+		Max:  millis(latencies[len(latencies)-1]),
+	}
+}
+
+// 已排序的耗时的第p百分位数，使用最近秩的方法，即至少有p%的耗时不超过它的最小的耗时
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// 以表格的形式输出结果
+func (r *report) printTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Clients\t%d\n", r.Clients)
+	fmt.Fprintf(tw, "Elapsed\t%.3fs\n", r.Elapsed)
+	fmt.Fprintf(tw, "Requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "Successes\t%d\n", r.Successes)
+	fmt.Fprintf(tw, "Throughput\t%.1f req/s\n", r.Throughput)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Latency\tmin\tmean\tp50\tp90\tp99\tmax")
+	l := r.Latency
+	fmt.Fprintf(tw, "(ms)\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\n", l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Error\tCount")
+		classes := make([]string, 0, len(r.Errors))
+		for class := range r.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(tw, "%s\t%d\n", class, r.Errors[class])
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"basic/concurrency/socket/sock"
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFramer = sock.LengthFramer(0)
+
+// 启动一个带有echo和fail命令的服务端，返回它的地址
+func startServer(t *testing.T) string {
+	router := sock.NewRouter()
+	router.RegisterFunc("echo", func(ctx context.Context, cmd *sock.Command) ([]byte, error) {
+		if _, err := strconv.Atoi(cmd.Args[0]); err != nil {
+			return nil, sock.Errorf(sock.KindParse, "%s", err)
+		}
+		return []byte(cmd.Args[0]), nil
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := sock.NewServer(listener.Addr().String(), testFramer, router)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return listener.Addr().String()
+}
+
+func testConfig(addr string) *config {
+	return &config{
+		network: "tcp",
+		addr:    addr,
+		framer:  testFramer,
+		timeout: time.Second,
+		clients: 4,
+		command: "echo",
+		args:    []string{randPlaceholder},
+	}
+}
+
+func TestRunRequests(t *testing.T) {
+	cfg := testConfig(startServer(t))
+	cfg.requests = 200
+	r, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	if r.Requests != 200 || r.Successes != 200 || len(r.Errors) != 0 {
+		t.Fatalf("ERROR: The report is %+v", r)
+	}
+	l := r.Latency
+	if !(l.Min > 0 && l.Min <= l.P50 && l.P50 <= l.P90 && l.P90 <= l.P99 && l.P99 <= l.Max) {
+		t.Fatalf("ERROR: The latency is %+v", l)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	cfg := testConfig(startServer(t))
+	cfg.requests = 10
+	cfg.args = []string{"x"}
+	r, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	if r.Requests != 10 || r.Successes != 0 || r.Errors["parse"] != 10 {
+		t.Fatalf("ERROR: The report is %+v", r)
+	}
+	cfg.command = "missing"
+	if r, _ = run(context.Background(), cfg); r.Errors["unknown_command"] != 10 {
+		t.Fatalf("ERROR: The report is %+v", r)
+	}
+}
+
+// 目标速率是所有客户端的总速率
+func TestRunRate(t *testing.T) {
+	cfg := testConfig(startServer(t))
+	cfg.duration = 500 * time.Millisecond
+	cfg.rate = 100
+	r, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	if r.Requests < 40 || r.Requests > 51 || r.Successes != r.Requests {
+		t.Fatalf("ERROR: %d requests are sent in %.3fs at 100/s: %+v", r.Requests, r.Elapsed, r.Errors)
+	}
+}
+
+// 连接断开之后客户端重新连接，重新连接也失败时停止，而不是一直空转到持续时间结束
+func TestRunConnectionLost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(listener.Addr().String())
+	cfg.duration = 5 * time.Second
+	go func() {
+		// 接受并立即关闭每个客户端的连接，然后停止监听，之后的重新连接都会被拒绝
+		for i := 0; i < cfg.clients; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+		listener.Close()
+	}()
+	r, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run Error: %s", err)
+	}
+	if r.Elapsed > 1 || r.Successes != 0 || r.Errors["dial"] != cfg.clients || r.Errors["connection"] < cfg.clients {
+		t.Fatalf("ERROR: The report is %+v", r)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	if _, err := run(context.Background(), testConfig("127.0.0.1:1")); err == nil {
+		t.Fatal("ERROR: No limit is accepted!")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{0: 1, 50: 50, 90: 90, 99: 99, 99.5: 100, 100: 100} {
+		if got := percentile(latencies, p); got != expected*time.Millisecond {
+			t.Fatalf("ERROR: The p%v is %v, expected %v", p, got, expected*time.Millisecond)
+		}
+	}
+	if got := percentile(latencies[:1], 99); got != time.Millisecond {
+		t.Fatalf("ERROR: The p99 of one latency is %v", got)
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	r := summarize(2, time.Second, []clientResult{
+		{latencies: []time.Duration{time.Millisecond, 3 * time.Millisecond}, errors: map[string]int{"timeout": 1}},
+		{latencies: []time.Duration{2 * time.Millisecond}, errors: map[string]int{}},
+	})
+	// 失败的请求不计入耗时
+	if r.Requests != 4 || r.Successes != 3 || r.Throughput != 4 || r.Latency.Mean != 2 || r.Latency.Max != 3 {
+		t.Fatalf("ERROR: The report is %+v", r)
+	}
+	var buf bytes.Buffer
+	r.printTable(&buf)
+	for _, s := range []string{"Requests    4", "Successes   3", "timeout  1", "(ms)"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("ERROR: The table does not contain %q:\n%s", s, buf.String())
+		}
+	}
+}
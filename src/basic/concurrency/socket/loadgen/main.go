@@ -0,0 +1,104 @@
+package main
+
+import (
+	"basic/concurrency/socket/sock"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+/**
+socket服务端的压测工具，用来在本地比较服务端修改前后的性能。先启动服务端（比如go run tcpsock.go），然后：
+	go run ./loadgen -clients 16 -duration 10s -rate 5000 -json report.json
+它默认发送{"name":"cbrt","args":["{rand}"]}命令，与tcpsock.go使用相同的分帧策略和消息编码。按下Ctrl+C会提前结束并输出已有的结果
+*/
+
+var (
+	network  = flag.String("network", "tcp", "the network: tcp, tcp6, unix, unixpacket or udp")
+	address  = flag.String("address", "127.0.0.1:8085", "the server address, or the socket file path for unix and unixpacket")
+	clients  = flag.Int("clients", 8, "the number of concurrent clients, each with its own connection")
+	duration = flag.Duration("duration", 10*time.Second, "how long to run, 0 means until -requests are sent")
+	requests = flag.Int64("requests", 0, "the total number of requests, 0 means until -duration elapses")
+	rate     = flag.Float64("rate", 0, "the target rate of all clients in requests per second, 0 means as fast as possible")
+	timeout  = flag.Duration("timeout", 5*time.Second, "the timeout of each request")
+	command  = flag.String("command", "cbrt", "the command name")
+	args     = flag.String("args", "{rand}", "the space separated command arguments, {rand} is replaced with a random integer")
+	token    = flag.String("token", "", "the auth token sent with each command")
+	codec    = flag.String("codec", "binary", "the message codec: binary or json")
+	caFile   = flag.String("ca", "", "the PEM encoded CA certificate file, enables TLS")
+	certFile = flag.String("cert", "", "the PEM encoded client certificate file for mTLS")
+	keyFile  = flag.String("key", "", "the PEM encoded client private key file for mTLS")
+	jsonFile = flag.String("json", "", "write a JSON report to this file, - means stdout")
+)
+
+func main() {
+	flag.Parse()
+	cfg := &config{
+		network:  *network,
+		addr:     *address,
+		framer:   sock.LengthFramer(sock.DefaultMaxFrameSize),
+		timeout:  *timeout,
+		clients:  *clients,
+		duration: *duration,
+		requests: *requests,
+		rate:     *rate,
+		command:  *command,
+		args:     strings.Fields(*args),
+		token:    *token,
+	}
+	switch *codec {
+	case "binary":
+	case "json":
+		cfg.clientOpts = append(cfg.clientOpts, sock.WithClientCodec(sock.JSONCodec()))
+	default:
+		fail("unknown codec %q", *codec)
+	}
+	if *caFile != "" {
+		tlsConfig, _, err := sock.ClientTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fail("TLS Error: %s", err)
+		}
+		cfg.clientOpts = append(cfg.clientOpts, sock.WithClientTLSConfig(tlsConfig))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	r, err := run(ctx, cfg)
+	if err != nil {
+		fail("%s", err)
+	}
+	// JSON报告输出到标准输出时，表格输出到标准错误，以免破坏JSON
+	table := os.Stdout
+	if *jsonFile == "-" {
+		table = os.Stderr
+	}
+	r.printTable(table)
+	if *jsonFile != "" {
+		if err := writeJSON(*jsonFile, r); err != nil {
+			fail("Write Error: %s", err)
+		}
+	}
+}
+
+func writeJSON(path string, r *report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "loadgen: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,252 @@
+package sock
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+)
+
+/**
+与Server配套的客户端。
+每个请求都带有一个连接内唯一的ID，发送之后被记录在待处理的调用表中。一个专门的Goroutine不断地从连接中读取响应，根据响应中的ID
+找到对应的调用并唤醒它，所以多个Goroutine可以共享同一个连接并发地调用，响应的顺序与请求的顺序无关。
+*/
+
+// 客户端的可选项
+type ClientOption func(c *Client)
+
+// 设置消息的编码方式，默认使用BinaryCodec。它必须与服务端的编码方式相同
+func WithClientCodec(codec MessageCodec) ClientOption {
+	return func(c *Client) {
+		c.codec = codec
+	}
+}
+
+// 设置调用的默认超时时间，只对没有截止时间的ctx生效。0表示不超时
+func WithCallTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.callTimeout = d
+	}
+}
+
+// 设置TLS配置，DialClient会使用TLS连接到服务端。对NewClient无效，因为它的连接已经建立
+func WithClientTLSConfig(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// 设置连接服务端使用的网络，默认是"tcp"，参见transport.go。对NewClient无效，因为它的连接已经建立
+func WithClientNetwork(network string) ClientOption {
+	return func(c *Client) {
+		c.network = network
+	}
+}
+
+// 客户端已被关闭时Call返回的错误
+var ErrClientClosed = errors.New("sock: client closed")
+
+type Client struct {
+	conn        *Conn
+	network     string
+	codec       MessageCodec
+	callTimeout time.Duration
+	tlsConfig   *tls.Config
+
+	// 保证请求被逐个写入
+	writeMu sync.Mutex
+	wbuf    []byte
+
+	// 保护下面的字段
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *Message
+	// 读取响应的Goroutine退出的原因，不为nil之后不再接受新的调用
+	err error
+	// 在读取响应的Goroutine退出时被关闭
+	done chan struct{}
+}
+
+// 连接到服务端并创建客户端。framer不能与客户端的编码方式一起使用时返回ErrIncompatibleCodec
+func DialClient(addr string, framer Framer, timeout time.Duration, opts ...ClientOption) (*Client, error) {
+	c := newClient(opts)
+	if err := checkCodec(framer, c.codec); err != nil {
+		return nil, err
+	}
+	conn, err := dialNetwork(c.network, addr, framer, timeout, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.start(conn)
+	return c, nil
+}
+
+// 在一个已经建立的连接上创建客户端，客户端会接管这个连接
+func NewClient(conn *Conn, opts ...ClientOption) *Client {
+	c := newClient(opts)
+	c.start(conn)
+	return c
+}
+
+func newClient(opts []ClientOption) *Client {
+	c := &Client{
+		network: "tcp",
+		codec:   BinaryCodec(),
+		pending: make(map[uint64]chan *Message),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) start(conn *Conn) {
+	c.conn = conn
+	go c.readLoop()
+}
+
+/**
+发送一个请求并等待它的响应。
+服务端返回错误响应时返回*Error；服务端的响应不符合协议时返回*ProtocolError；ctx被取消或者超时的时候返回ctx的错误，之后到达的响应会被丢弃；连接出错时返回连接的错误，
+这个错误之后的所有调用都会失败。
+*/
+func (c *Client) Call(ctx context.Context, req []byte) ([]byte, error) {
+	return c.call(ctx, &Message{Status: StatusOK, Body: req})
+}
+
+// 检查连接和服务端是否可用，服务端会直接返回而不会调用Handler
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, &Message{Status: StatusPing})
+	return err
+}
+
+func (c *Client) call(ctx context.Context, req *Message) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && c.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.callTimeout)
+		defer cancel()
+	}
+	id, ch, err := c.register()
+	if err != nil {
+		return nil, err
+	}
+	req.ID = id
+	if err := c.send(ctx, req); err != nil {
+		c.unregister(id)
+		return nil, err
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.closeErr()
+		}
+		switch resp.Status {
+		case StatusOK:
+			return resp.Body, nil
+		case StatusError:
+			return nil, &Error{Kind: resp.Kind, Message: string(resp.Body)}
+		}
+		return nil, &ProtocolError{Reason: "unexpected response status " + resp.Status.String()}
+	case <-ctx.Done():
+		c.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+// 关闭客户端和它的连接，正在等待响应的调用会返回ErrClientClosed
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = ErrClientClosed
+	}
+	c.mu.Unlock()
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) register() (uint64, chan *Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.nextID++
+	// 缓冲区保证读取响应的Goroutine不会因为调用方已经放弃等待而阻塞
+	ch := make(chan *Message, 1)
+	c.pending[c.nextID] = ch
+	return c.nextID, ch, nil
+}
+
+func (c *Client) unregister(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) send(ctx context.Context, m *Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	buf, err := c.codec.AppendMessage(c.wbuf[:0], m)
+	if err != nil {
+		return err
+	}
+	c.wbuf = buf
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	err = c.conn.WriteFrame(buf)
+	if err != nil && err != ErrFrameTooLarge && err != ErrInvalidFrame {
+		// 帧可能只被写入了一部分，连接上的数据已经无法被正确地分帧了
+		c.conn.Close()
+	}
+	return err
+}
+
+func (c *Client) readLoop() {
+	var err error
+	for {
+		var frame []byte
+		frame, err = c.conn.ReadFrame()
+		if err != nil {
+			break
+		}
+		resp := new(Message)
+		if err = c.codec.DecodeMessage(frame, resp); err != nil {
+			err = &ProtocolError{Reason: err.Error()}
+			break
+		}
+		// 帧读取器会复用它的缓冲区
+		resp.Body = append([]byte(nil), resp.Body...)
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		// 找不到对应调用的响应属于已经超时的调用，直接丢弃
+		if ok {
+			ch <- resp
+		}
+	}
+	c.conn.Close()
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
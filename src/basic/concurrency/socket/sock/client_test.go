@@ -0,0 +1,292 @@
+package sock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func dialClient(t *testing.T, addr string, opts ...ClientOption) *Client {
+	client, err := DialClient(addr, testFramer, time.Second, opts...)
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// 请求的内容是处理它需要的毫秒数，处理完成之后原样返回
+var sleepHandler = HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+	ms, err := strconv.Atoi(string(req))
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return req, nil
+})
+
+/**
+先发送的请求处理得更慢，所以响应的顺序与请求的顺序相反，每个调用仍然得到自己的响应。
+*/
+func TestClientOutOfOrder(t *testing.T) {
+	_, addr, _ := startServer(t, sleepHandler)
+	client := dialClient(t, addr)
+	const n = 10
+	order := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := n; i > 0; i-- {
+		req := strconv.Itoa(i * 20)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Call(context.Background(), []byte(req))
+			if err != nil || string(resp) != req {
+				t.Errorf("ERROR: The response of %s is (%q, %v)", req, resp, err)
+			}
+			order <- string(resp)
+		}()
+		// 保证请求按顺序发送
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+	close(order)
+	if first := <-order; first != "20" {
+		t.Fatalf("ERROR: The first response is %s, expected the fastest one!", first)
+	}
+}
+
+// 同一个连接上的请求被并发地处理：只有所有的请求都到达了服务端，它们才能完成
+func TestServePipelined(t *testing.T) {
+	const n = 8
+	var barrier sync.WaitGroup
+	barrier.Add(n)
+	_, addr, _ := startServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		barrier.Done()
+		barrier.Wait()
+		return req, nil
+	}))
+	client := dialClient(t, addr, WithCallTimeout(2*time.Second))
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		req := fmt.Sprintf("req-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := client.Call(context.Background(), []byte(req)); err != nil || string(resp) != req {
+				t.Errorf("ERROR: The response of %s is (%q, %v)", req, resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeMaxPipelined(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive int
+	_, addr, _ := startServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		mu.Lock()
+		if active++; active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return req, nil
+	}), WithMaxPipelined(2))
+	client := dialClient(t, addr, WithCallTimeout(2*time.Second))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Call(context.Background(), []byte("x")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive != 2 {
+		t.Fatalf("ERROR: %d requests are handled concurrently, expected 2", maxActive)
+	}
+}
+
+func TestClientRemoteError(t *testing.T) {
+	_, addr, _ := startServer(t, sleepHandler)
+	client := dialClient(t, addr)
+	_, err := client.Call(context.Background(), []byte("abc"))
+	if re, ok := err.(*Error); !ok || re.Kind != KindInternal || re.Message != `strconv.Atoi: parsing "abc": invalid syntax` {
+		t.Fatalf("ERROR: The error is %#v, expected a remote error", err)
+	}
+	// 错误响应不影响后续的调用
+	if resp, err := client.Call(context.Background(), []byte("0")); err != nil || string(resp) != "0" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	_, addr, _ := startServer(t, sleepHandler)
+	client := dialClient(t, addr, WithCallTimeout(30*time.Millisecond))
+	if _, err := client.Call(context.Background(), []byte("200")); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: The slow call returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	// ctx自身的截止时间优先于默认的超时时间
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if resp, err := client.Call(ctx, []byte("100")); err != nil || string(resp) != "100" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+	// 超时调用的响应迟到之后被丢弃，不会被当作其它调用的响应
+	time.Sleep(150 * time.Millisecond)
+	if resp, err := client.Call(context.Background(), []byte("1")); err != nil || string(resp) != "1" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	_, addr, _ := startServer(t, sleepHandler)
+	client := dialClient(t, addr)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), []byte("10000"))
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	select {
+	case err := <-errs:
+		if err != ErrClientClosed {
+			t.Fatalf("ERROR: The pending call returned %v, expected %v", err, ErrClientClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ERROR: The pending call is not woken up by Close!")
+	}
+	if _, err := client.Call(context.Background(), []byte("0")); err != ErrClientClosed {
+		t.Fatalf("ERROR: The call after Close returned %v, expected %v", err, ErrClientClosed)
+	}
+}
+
+func TestClientErrorKinds(t *testing.T) {
+	handler := HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		switch string(req) {
+		case "parse":
+			return nil, Errorf(KindParse, "'%s' is not integer!", "x")
+		case "range":
+			return nil, Errorf(KindOutOfRange, "'%d' is not 32-bit integer!", int64(1)<<40)
+		case "wrapped":
+			return nil, fmt.Errorf("convert: %w", Errorf(KindParse, "wrapped"))
+		}
+		return nil, errors.New("plain")
+	})
+	for _, codec := range []MessageCodec{BinaryCodec(), JSONCodec()} {
+		_, addr, _ := startServer(t, handler, WithMessageCodec(codec))
+		client := dialClient(t, addr, WithClientCodec(codec), WithCallTimeout(2*time.Second))
+		cases := []struct {
+			req     string
+			kind    ErrorKind
+			message string
+		}{
+			{"parse", KindParse, "'x' is not integer!"},
+			{"range", KindOutOfRange, "'1099511627776' is not 32-bit integer!"},
+			{"wrapped", KindParse, "wrapped"},
+			{"other", KindInternal, "plain"},
+		}
+		for _, c := range cases {
+			_, err := client.Call(context.Background(), []byte(c.req))
+			var e *Error
+			if !errors.As(err, &e) || e.Kind != c.kind || e.Message != c.message {
+				t.Fatalf("ERROR: The error of %s is %#v, expected %s error %q", c.req, err, c.kind, c.message)
+			}
+		}
+	}
+}
+
+// 没有名字的种类可以被编码再解码，较新的服务端返回的新种类不会导致客户端无法解码响应
+func TestErrorKindText(t *testing.T) {
+	for _, kind := range []ErrorKind{KindInternal, KindRateLimited, 200, 255} {
+		text, err := kind.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText Error: %s", err)
+		}
+		var decoded ErrorKind
+		if err := decoded.UnmarshalText(text); err != nil || decoded != kind {
+			t.Fatalf("ERROR: %q is decoded to (%d, %v), expected %d", text, decoded, err, kind)
+		}
+	}
+	for _, text := range []string{"bogus", "kind(256)", "kind(-1)", "kind()", "kind(1"} {
+		var kind ErrorKind
+		if err := kind.UnmarshalText([]byte(text)); err == nil {
+			t.Fatalf("ERROR: %q is decoded to %d", text, kind)
+		}
+	}
+}
+
+// 不符合协议的响应使客户端返回*ProtocolError并关闭连接
+// BinaryCodec与DelimiterFramer的组合在使用之前就被拒绝，使用JSONCodec时可以
+func TestIncompatibleCodec(t *testing.T) {
+	framer := DelimiterFramer('\n', 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer(listener.Addr().String(), framer, upperHandler).Serve(listener); err != ErrIncompatibleCodec {
+		t.Fatalf("ERROR: Serve returns %v", err)
+	}
+	if _, err := DialClient(listener.Addr().String(), framer, time.Second); err != ErrIncompatibleCodec {
+		t.Fatalf("ERROR: DialClient returns %v", err)
+	}
+
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(listener.Addr().String(), framer, upperHandler, WithMessageCodec(JSONCodec()))
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+	client, err := DialClient(listener.Addr().String(), framer, time.Second, WithClientCodec(JSONCodec()))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	if resp, err := client.Call(context.Background(), []byte("hello")); err != nil || string(resp) != "HELLO" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
+
+func TestClientProtocolError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := NewConn(conn, testFramer)
+		c.ReadFrame()
+		// 太短的消息无法被解码
+		c.WriteFrame([]byte("bad"))
+		time.Sleep(time.Second)
+	}()
+	client := dialClient(t, listener.Addr().String(), WithCallTimeout(2*time.Second))
+	_, err = client.Call(context.Background(), []byte("x"))
+	if _, ok := err.(*ProtocolError); !ok {
+		t.Fatalf("ERROR: The error is %#v, expected a protocol error", err)
+	}
+	if _, err := client.Call(context.Background(), []byte("x")); err == nil {
+		t.Fatal("ERROR: The client is still usable after a protocol error!")
+	}
+}
@@ -0,0 +1,94 @@
+package sock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**
+错误响应的信封。
+失败的响应的状态是StatusError，消息头中带有错误的种类，消息内容是错误信息，所以客户端可以区分结果和错误，并根据错误的种类决定如何处理。
+Handler返回*Error时它的种类和信息被原样发送给客户端，返回其它的错误时种类是KindInternal；客户端的Call总是以*Error返回错误响应。
+*/
+
+// 错误的种类
+type ErrorKind byte
+
+const (
+	// 服务端内部的错误，Handler返回的不是*Error的错误都属于这一种
+	KindInternal ErrorKind = iota
+	// 请求的内容无法被解析
+	KindParse
+	// 请求中的数值超出了允许的范围
+	KindOutOfRange
+	// 请求的命令没有被注册，参见Router
+	KindUnknownCommand
+	// 请求没有携带合法的认证令牌
+	KindUnauthorized
+	// 请求超出了服务端允许的速率
+	KindRateLimited
+)
+
+var kindNames = map[ErrorKind]string{
+	KindInternal:       "internal",
+	KindParse:          "parse",
+	KindOutOfRange:     "out_of_range",
+	KindUnknownCommand: "unknown_command",
+	KindUnauthorized:   "unauthorized",
+	KindRateLimited:    "rate_limited",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// JSONCodec使用种类的名字编码，没有名字的种类被编码为"kind(N)"
+func (k ErrorKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// 较新的服务端可能返回客户端还不认识的种类，它们以"kind(N)"的形式被解码为对应的数值，而不是导致整个响应无法被解码
+func (k *ErrorKind) UnmarshalText(text []byte) error {
+	for kind, name := range kindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	s := string(text)
+	if strings.HasPrefix(s, "kind(") && strings.HasSuffix(s, ")") {
+		if n, err := strconv.ParseUint(s[len("kind("):len(s)-1], 10, 8); err == nil {
+			*k = ErrorKind(n)
+			return nil
+		}
+	}
+	return fmt.Errorf("sock: unknown error kind %q", text)
+}
+
+// 带有种类的错误，Handler通过它返回错误响应，客户端通过它得知错误响应的内容
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Kind.String() + " error: " + e.Message
+}
+
+// 创建一个给定种类的错误
+func Errorf(kind ErrorKind, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// 服务端的响应不符合协议，比如无法被解码。出现这个错误之后连接会被关闭
+type ProtocolError struct {
+	Reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return "sock: protocol error: " + e.Reason
+}
@@ -0,0 +1,327 @@
+package sock
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+/**
+TCP连接上传输的是字节流，它本身并没有消息边界的概念，所以我们需要一种分帧（framing）的方式来确定每一条消息从哪里开始、到哪里结束。
+Framer代表了一种分帧策略，它为连接的读端和写端分别创建帧读取器和帧写入器。服务端和客户端必须使用相同的分帧策略。
+这里提供了4种分帧策略：
+分隔符：tcpsock.go最初使用的协议，简单，但消息内容中不能出现分隔符
+4字节长度前缀：每个帧以大端序的4字节长度开头，消息内容可以是任意的字节
+varint长度前缀：与上一种相同，只是长度用uvarint编码，短消息的开销更小
+换行分隔的JSON（NDJSON）：每一行是一个JSON值，便于用文本工具调试
+所有的帧读取器都会拒绝超过最大长度的帧，以免恶意或出错的对端通过一个巨大的长度耗尽服务端的内存。
+*/
+type Framer interface {
+	// 创建一个从r中读取帧的帧读取器，帧读取器可以带有缓冲区，所以对同一个连接只应该创建一个帧读取器
+	NewFrameReader(r io.Reader) FrameReader
+	// 创建一个向w中写入帧的帧写入器
+	NewFrameWriter(w io.Writer) FrameWriter
+}
+
+// 帧读取器
+type FrameReader interface {
+	// 读取一个完整的帧，返回的切片只在下一次调用ReadFrame之前有效
+	ReadFrame() ([]byte, error)
+}
+
+// 帧写入器
+type FrameWriter interface {
+	// 写入一个完整的帧
+	WriteFrame(p []byte) error
+}
+
+// 默认的帧的最大长度
+const DefaultMaxFrameSize = 1 << 20
+
+var (
+	// 帧的长度超过了最大长度。读取时遇到这个错误之后连接上的数据就无法再被正确地分帧了，应该关闭连接
+	ErrFrameTooLarge = errors.New("sock: frame too large")
+	// 帧的内容不符合分帧策略的要求，比如包含了分隔符或者不是合法的JSON
+	ErrInvalidFrame = errors.New("sock: invalid frame")
+)
+
+func maxFrameSizeOrDefault(maxFrameSize int) int {
+	if maxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return maxFrameSize
+}
+
+// 以一个分隔字节作为消息边界的分帧策略。maxFrameSize不大于0时使用DefaultMaxFrameSize
+func DelimiterFramer(delim byte, maxFrameSize int) Framer {
+	return delimiterFramer{delim: delim, max: maxFrameSizeOrDefault(maxFrameSize)}
+}
+
+type delimiterFramer struct {
+	delim byte
+	max   int
+}
+
+func (f delimiterFramer) NewFrameReader(r io.Reader) FrameReader {
+	return &delimiterReader{r: bufio.NewReader(r), delim: f.delim, max: f.max}
+}
+
+func (f delimiterFramer) NewFrameWriter(w io.Writer) FrameWriter {
+	return &delimiterWriter{w: w, delim: f.delim, max: f.max}
+}
+
+type delimiterReader struct {
+	r     *bufio.Reader
+	delim byte
+	max   int
+	buf   []byte
+}
+
+func (fr *delimiterReader) ReadFrame() ([]byte, error) {
+	// 在整个连接的生命周期中都使用同一个缓冲读取器，以免缓冲区中的数据丢失。
+	// 这里没有使用ReadBytes，因为它会一直读到分隔符为止而不限制长度
+	fr.buf = fr.buf[:0]
+	for {
+		slice, err := fr.r.ReadSlice(fr.delim)
+		if len(fr.buf)+len(slice) > fr.max+1 {
+			return nil, ErrFrameTooLarge
+		}
+		fr.buf = append(fr.buf, slice...)
+		if err == nil {
+			return fr.buf[:len(fr.buf)-1], nil
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(fr.buf) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+type delimiterWriter struct {
+	w     io.Writer
+	delim byte
+	max   int
+	buf   []byte
+}
+
+func (fw *delimiterWriter) WriteFrame(p []byte) error {
+	if len(p) > fw.max {
+		return ErrFrameTooLarge
+	}
+	if bytes.IndexByte(p, fw.delim) >= 0 {
+		return ErrInvalidFrame
+	}
+	// 把内容和分隔符拼接在一起，通过一次Write写入，以免两次写入之间插入了其它的数据
+	fw.buf = append(append(fw.buf[:0], p...), fw.delim)
+	_, err := fw.w.Write(fw.buf)
+	return err
+}
+
+// 以大端序的4字节长度作为前缀的分帧策略。maxFrameSize不大于0时使用DefaultMaxFrameSize
+func LengthFramer(maxFrameSize int) Framer {
+	return lengthFramer{max: maxFrameSizeOrDefault(maxFrameSize)}
+}
+
+type lengthFramer struct {
+	max int
+}
+
+func (f lengthFramer) NewFrameReader(r io.Reader) FrameReader {
+	return &lengthReader{r: bufio.NewReader(r), max: f.max}
+}
+
+func (f lengthFramer) NewFrameWriter(w io.Writer) FrameWriter {
+	return &lengthWriter{w: w, max: f.max}
+}
+
+type lengthReader struct {
+	r      *bufio.Reader
+	max    int
+	header [4]byte
+	buf    []byte
+}
+
+func (fr *lengthReader) ReadFrame() ([]byte, error) {
+	if _, err := io.ReadFull(fr.r, fr.header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(fr.header[:])
+	// 在分配内存之前检查长度
+	if int64(n) > int64(fr.max) {
+		return nil, ErrFrameTooLarge
+	}
+	return readPayload(fr.r, &fr.buf, int(n))
+}
+
+type lengthWriter struct {
+	w   io.Writer
+	max int
+	buf []byte
+}
+
+func (fw *lengthWriter) WriteFrame(p []byte) error {
+	if len(p) > fw.max {
+		return ErrFrameTooLarge
+	}
+	fw.buf = append(fw.buf[:0], 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(fw.buf, uint32(len(p)))
+	fw.buf = append(fw.buf, p...)
+	_, err := fw.w.Write(fw.buf)
+	return err
+}
+
+// 以uvarint编码的长度作为前缀的分帧策略。maxFrameSize不大于0时使用DefaultMaxFrameSize
+func VarintFramer(maxFrameSize int) Framer {
+	return varintFramer{max: maxFrameSizeOrDefault(maxFrameSize)}
+}
+
+type varintFramer struct {
+	max int
+}
+
+func (f varintFramer) NewFrameReader(r io.Reader) FrameReader {
+	return &varintReader{r: bufio.NewReader(r), max: f.max}
+}
+
+func (f varintFramer) NewFrameWriter(w io.Writer) FrameWriter {
+	return &varintWriter{w: w, max: f.max}
+}
+
+type varintReader struct {
+	r   *bufio.Reader
+	max int
+	buf []byte
+}
+
+func (fr *varintReader) ReadFrame() ([]byte, error) {
+	n, err := binary.ReadUvarint(fr.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(fr.max) {
+		return nil, ErrFrameTooLarge
+	}
+	return readPayload(fr.r, &fr.buf, int(n))
+}
+
+type varintWriter struct {
+	w   io.Writer
+	max int
+	buf []byte
+}
+
+func (fw *varintWriter) WriteFrame(p []byte) error {
+	if len(p) > fw.max {
+		return ErrFrameTooLarge
+	}
+	fw.buf = binary.AppendUvarint(fw.buf[:0], uint64(len(p)))
+	fw.buf = append(fw.buf, p...)
+	_, err := fw.w.Write(fw.buf)
+	return err
+}
+
+// 读取长度为n的帧内容，复用buf指向的缓冲区
+func readPayload(r io.Reader, buf *[]byte, n int) ([]byte, error) {
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	p := (*buf)[:n]
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+/**
+换行分隔的JSON（NDJSON）分帧策略，每个帧都必须是一个合法的JSON值。
+写入时会把JSON压缩成一行（合法的JSON字符串中的换行一定是被转义的，所以压缩之后不会再包含换行），读取时会校验每一行是否是合法的JSON。
+maxFrameSize不大于0时使用DefaultMaxFrameSize
+*/
+func NDJSONFramer(maxFrameSize int) Framer {
+	return ndjsonFramer{max: maxFrameSizeOrDefault(maxFrameSize)}
+}
+
+type ndjsonFramer struct {
+	max int
+}
+
+func (f ndjsonFramer) NewFrameReader(r io.Reader) FrameReader {
+	return &ndjsonReader{&delimiterReader{r: bufio.NewReader(r), delim: '\n', max: f.max}}
+}
+
+func (f ndjsonFramer) NewFrameWriter(w io.Writer) FrameWriter {
+	return &ndjsonWriter{w: w, max: f.max}
+}
+
+type ndjsonReader struct {
+	lines *delimiterReader
+}
+
+func (fr *ndjsonReader) ReadFrame() ([]byte, error) {
+	line, err := fr.lines.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	// 兼容以\r\n结尾的行
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if !json.Valid(line) {
+		return nil, ErrInvalidFrame
+	}
+	return line, nil
+}
+
+type ndjsonWriter struct {
+	w   io.Writer
+	max int
+	buf bytes.Buffer
+}
+
+func (fw *ndjsonWriter) WriteFrame(p []byte) error {
+	fw.buf.Reset()
+	if err := json.Compact(&fw.buf, p); err != nil {
+		return ErrInvalidFrame
+	}
+	if fw.buf.Len() > fw.max {
+		return ErrFrameTooLarge
+	}
+	fw.buf.WriteByte('\n')
+	_, err := fw.w.Write(fw.buf.Bytes())
+	return err
+}
+
+// 带有分帧功能的连接，服务端和客户端都通过它来读写帧
+type Conn struct {
+	net.Conn
+	FrameReader
+	FrameWriter
+}
+
+// 使用给定的分帧策略包装一个连接
+func NewConn(conn net.Conn, framer Framer) *Conn {
+	return &Conn{
+		Conn:        conn,
+		FrameReader: framer.NewFrameReader(conn),
+		FrameWriter: framer.NewFrameWriter(conn),
+	}
+}
+
+// 通过TCP连接到服务端，并使用给定的分帧策略包装这个连接
+func Dial(addr string, framer Framer, timeout time.Duration) (*Conn, error) {
+	return dialNetwork("tcp", addr, framer, timeout, nil)
+}
+
+// 使用TLS连接到服务端，并使用给定的分帧策略包装这个连接。握手在返回之前完成，所以证书的错误会由DialTLS返回
+func DialTLS(addr string, framer Framer, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	return dialNetwork("tcp", addr, framer, timeout, config)
+}
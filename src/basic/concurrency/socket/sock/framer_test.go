@@ -0,0 +1,217 @@
+package sock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFramers = map[string]Framer{
+	"delimiter": DelimiterFramer('\t', 0),
+	"length":    LengthFramer(0),
+	"varint":    VarintFramer(0),
+	"ndjson":    NDJSONFramer(0),
+}
+
+// 每种分帧策略都能正确传输的帧，ndjson要求内容是合法的JSON
+func testFrames(name string) [][]byte {
+	if name == "ndjson" {
+		return [][]byte{[]byte(`{"a":1}`), []byte(`"line1\nline2\ttab"`), []byte(`[1,2,3]`), []byte(`null`)}
+	}
+	frames := [][]byte{[]byte("hello"), []byte(""), bytes.Repeat([]byte("x"), 5000), []byte("last")}
+	if name != "delimiter" {
+		// 长度前缀的帧可以包含任意的字节
+		frames = append(frames, []byte("tab\tnewline\n\x00"))
+	}
+	return frames
+}
+
+func TestFramerRoundTrip(t *testing.T) {
+	for name, framer := range testFramers {
+		var buf bytes.Buffer
+		frames := testFrames(name)
+		w := framer.NewFrameWriter(&buf)
+		for _, frame := range frames {
+			if err := w.WriteFrame(frame); err != nil {
+				t.Fatalf("%s: Write Error: %s", name, err)
+			}
+		}
+		r := framer.NewFrameReader(&buf)
+		for i, frame := range frames {
+			got, err := r.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s: Read Error: %s (frame %d)", name, err, i)
+			}
+			if !bytes.Equal(got, frame) {
+				t.Fatalf("%s: ERROR: Frame %d is %q, expected %q", name, i, got, frame)
+			}
+		}
+		if _, err := r.ReadFrame(); err != io.EOF {
+			t.Fatalf("%s: ERROR: The read after the last frame returned %v, expected EOF", name, err)
+		}
+	}
+}
+
+// 帧被拆分成多次写入到达时也能被正确地读取
+func TestFramerPartialReads(t *testing.T) {
+	for name, framer := range testFramers {
+		var buf bytes.Buffer
+		frames := testFrames(name)
+		w := framer.NewFrameWriter(&buf)
+		for _, frame := range frames {
+			if err := w.WriteFrame(frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := framer.NewFrameReader(&oneByteReader{&buf})
+		for i, frame := range frames {
+			if got, err := r.ReadFrame(); err != nil || !bytes.Equal(got, frame) {
+				t.Fatalf("%s: ERROR: Frame %d is (%q, %v), expected %q", name, i, got, err, frame)
+			}
+		}
+	}
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
+
+func TestFramerMaxFrameSize(t *testing.T) {
+	const max = 16
+	framers := map[string]Framer{
+		"delimiter": DelimiterFramer('\t', max),
+		"length":    LengthFramer(max),
+		"varint":    VarintFramer(max),
+		"ndjson":    NDJSONFramer(max),
+	}
+	large := []byte(`"` + strings.Repeat("x", max) + `"`)
+	fits := []byte(`"` + strings.Repeat("x", max-2) + `"`)
+	for name, framer := range framers {
+		var buf bytes.Buffer
+		w := framer.NewFrameWriter(&buf)
+		if err := w.WriteFrame(large); err != ErrFrameTooLarge {
+			t.Fatalf("%s: ERROR: Writing a large frame returned %v, expected %v", name, err, ErrFrameTooLarge)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%s: ERROR: %d bytes are written for a rejected frame!", name, buf.Len())
+		}
+		if err := w.WriteFrame(fits); err != nil {
+			t.Fatalf("%s: Write Error: %s", name, err)
+		}
+		if got, err := framer.NewFrameReader(&buf).ReadFrame(); err != nil || !bytes.Equal(got, fits) {
+			t.Fatalf("%s: ERROR: The frame is (%q, %v), expected %q", name, got, err, fits)
+		}
+
+		// 对端发送的大帧会在读取时被拒绝
+		buf.Reset()
+		if err := testFramers[name].NewFrameWriter(&buf).WriteFrame(large); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := framer.NewFrameReader(&buf).ReadFrame(); err != ErrFrameTooLarge {
+			t.Fatalf("%s: ERROR: Reading a large frame returned %v, expected %v", name, err, ErrFrameTooLarge)
+		}
+	}
+}
+
+// 长度前缀中声明的巨大长度在分配内存之前就被拒绝
+func TestLengthFramerHugeLength(t *testing.T) {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], 1<<31)
+	r := LengthFramer(0).NewFrameReader(bytes.NewReader(header[:]))
+	if _, err := r.ReadFrame(); err != ErrFrameTooLarge {
+		t.Fatalf("ERROR: The read returned %v, expected %v", err, ErrFrameTooLarge)
+	}
+	huge := binary.AppendUvarint(nil, 1<<40)
+	r = VarintFramer(0).NewFrameReader(bytes.NewReader(huge))
+	if _, err := r.ReadFrame(); err != ErrFrameTooLarge {
+		t.Fatalf("ERROR: The read returned %v, expected %v", err, ErrFrameTooLarge)
+	}
+}
+
+func TestFramerTruncated(t *testing.T) {
+	for name, framer := range testFramers {
+		var buf bytes.Buffer
+		if err := framer.NewFrameWriter(&buf).WriteFrame([]byte(`"truncated"`)); err != nil {
+			t.Fatal(err)
+		}
+		buf.Truncate(buf.Len() - 1)
+		if _, err := framer.NewFrameReader(&buf).ReadFrame(); err != io.ErrUnexpectedEOF {
+			t.Fatalf("%s: ERROR: Reading a truncated frame returned %v, expected %v", name, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestFramerInvalidFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := DelimiterFramer('\t', 0).NewFrameWriter(&buf).WriteFrame([]byte("a\tb")); err != ErrInvalidFrame {
+		t.Fatalf("ERROR: Writing a frame containing the delimiter returned %v, expected %v", err, ErrInvalidFrame)
+	}
+	w := NDJSONFramer(0).NewFrameWriter(&buf)
+	if err := w.WriteFrame([]byte("not json")); err != ErrInvalidFrame {
+		t.Fatalf("ERROR: Writing an invalid JSON returned %v, expected %v", err, ErrInvalidFrame)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("ERROR: %d bytes are written for rejected frames!", buf.Len())
+	}
+	// 多行的JSON会被压缩成一行
+	if err := w.WriteFrame([]byte("{\n  \"a\": 1\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\"a\":1}\n" {
+		t.Fatalf("ERROR: The written frame is %q!", buf.String())
+	}
+	r := NDJSONFramer(0).NewFrameReader(strings.NewReader("{\"b\":2}\r\nnot json\n"))
+	if got, err := r.ReadFrame(); err != nil || string(got) != `{"b":2}` {
+		t.Fatalf("ERROR: The frame is (%q, %v)", got, err)
+	}
+	if _, err := r.ReadFrame(); err != ErrInvalidFrame {
+		t.Fatalf("ERROR: Reading an invalid JSON returned %v, expected %v", err, ErrInvalidFrame)
+	}
+}
+
+// 服务端和客户端使用相同的分帧策略通信。分隔符和NDJSON的帧中不能出现任意的字节，所以要使用JSON编码消息
+func TestServeFramers(t *testing.T) {
+	echo := HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	codecs := map[string]MessageCodec{
+		"delimiter": JSONCodec(),
+		"length":    BinaryCodec(),
+		"varint":    BinaryCodec(),
+		"ndjson":    JSONCodec(),
+	}
+	for name, framer := range testFramers {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := NewServer(listener.Addr().String(), framer, echo, WithMessageCodec(codecs[name]))
+		go server.Serve(listener)
+		client, err := DialClient(listener.Addr().String(), framer, time.Second,
+			WithClientCodec(codecs[name]), WithCallTimeout(2*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, frame := range testFrames("length") {
+			if got, err := client.Call(context.Background(), frame); err != nil || !bytes.Equal(got, frame) {
+				t.Fatalf("%s: ERROR: Response %d is (%q, %v), expected %q", name, i, got, err, frame)
+			}
+		}
+		client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		server.Shutdown(ctx)
+		cancel()
+	}
+}
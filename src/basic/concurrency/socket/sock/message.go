@@ -0,0 +1,138 @@
+package sock
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+/**
+每个帧中都是一条消息，消息头中带有请求ID和状态。
+服务端在响应中原样返回请求的ID，所以客户端可以在一个连接上连续发送多个请求（流水线），并在响应以任意顺序到达时找到与之对应的请求。
+*/
+
+// 消息的状态，普通请求的状态是StatusOK
+type Status byte
+
+const (
+	// 普通的请求或成功的响应
+	StatusOK Status = iota
+	// 失败的响应，消息内容是错误信息
+	StatusError
+	// 健康检查的请求，服务端不调用Handler，直接返回一个空的成功响应
+	StatusPing
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusError:
+		return "error"
+	case StatusPing:
+		return "ping"
+	}
+	return "unknown"
+}
+
+type Message struct {
+	ID     uint64
+	Status Status
+	// 错误的种类，只在状态为StatusError时有意义，参见errors.go
+	Kind ErrorKind
+	Body []byte
+}
+
+// 把消息编码到帧中的方式，服务端和客户端必须使用相同的编码方式
+type MessageCodec interface {
+	// 把消息追加到buf中并返回追加之后的切片
+	AppendMessage(buf []byte, m *Message) ([]byte, error)
+	// 从帧中解码消息，解码出的Body可以引用frame的内存
+	DecodeMessage(frame []byte, m *Message) error
+}
+
+/**
+二进制的编码方式：8字节大端序的ID，1字节的状态，1字节的错误种类，然后是消息内容。
+因为ID中可能出现任意的字节，所以它应该与长度前缀的分帧策略一起使用，而不是分隔符，参见ErrIncompatibleCodec。
+*/
+func BinaryCodec() MessageCodec {
+	return binaryCodec{}
+}
+
+type binaryCodec struct{}
+
+/**
+分帧策略与编码方式不能一起使用时，Serve和DialClient返回的错误。
+BinaryCodec编码的ID中可能出现任意的字节，ID一旦增长到包含了分隔符，这条消息就无法被DelimiterFramer写入了，
+所以它们的组合在使用之前就被拒绝，而不是在运行了一段时间之后才失败
+*/
+var ErrIncompatibleCodec = errors.New("sock: BinaryCodec cannot be used with DelimiterFramer")
+
+func checkCodec(framer Framer, codec MessageCodec) error {
+	if _, ok := framer.(delimiterFramer); ok {
+		if _, ok := codec.(binaryCodec); ok {
+			return ErrIncompatibleCodec
+		}
+	}
+	return nil
+}
+
+const binaryHeaderLen = 10
+
+func (binaryCodec) AppendMessage(buf []byte, m *Message) ([]byte, error) {
+	buf = binary.BigEndian.AppendUint64(buf, m.ID)
+	buf = append(buf, byte(m.Status), byte(m.Kind))
+	return append(buf, m.Body...), nil
+}
+
+func (binaryCodec) DecodeMessage(frame []byte, m *Message) error {
+	if len(frame) < binaryHeaderLen {
+		return ErrInvalidFrame
+	}
+	m.ID = binary.BigEndian.Uint64(frame)
+	m.Status = Status(frame[8])
+	m.Kind = ErrorKind(frame[9])
+	m.Body = frame[binaryHeaderLen:]
+	return nil
+}
+
+/**
+JSON的编码方式，每条消息是{"id":1,"status":0,"body":"..."}，错误响应还带有"kind":"parse"这样的错误种类，与NDJSONFramer一起使用便于调试。
+消息内容被编码为JSON字符串，所以它只适合文本内容，不合法的UTF-8字节会被替换。
+*/
+func JSONCodec() MessageCodec {
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+type jsonMessage struct {
+	ID     uint64     `json:"id"`
+	Status Status     `json:"status"`
+	Kind   *ErrorKind `json:"kind,omitempty"`
+	Body   string     `json:"body"`
+}
+
+func (jsonCodec) AppendMessage(buf []byte, m *Message) ([]byte, error) {
+	jm := jsonMessage{ID: m.ID, Status: m.Status, Body: string(m.Body)}
+	if m.Status == StatusError {
+		jm.Kind = &m.Kind
+	}
+	data, err := json.Marshal(jm)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, data...), nil
+}
+
+func (jsonCodec) DecodeMessage(frame []byte, m *Message) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(frame, &jm); err != nil {
+		return ErrInvalidFrame
+	}
+	m.ID, m.Status, m.Kind, m.Body = jm.ID, jm.Status, KindInternal, []byte(jm.Body)
+	if jm.Kind != nil {
+		m.Kind = *jm.Kind
+	}
+	return nil
+}
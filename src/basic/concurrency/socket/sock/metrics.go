@@ -0,0 +1,219 @@
+package sock
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+/**
+服务端的指标。
+计数器和直方图都通过原子操作更新，不会使处理请求的Goroutine互相等待。Stats返回它们在某一时刻的快照，
+MetricsHandler以Prometheus的文本格式输出它们，PublishExpvar把它们发布到expvar的/debug/vars中。
+*/
+
+// 处理请求的耗时的直方图的默认区间上界
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// 记录耗时的分布的直方图，它可以被多个Goroutine同时使用
+type Histogram struct {
+	// 总耗时（纳秒）
+	sum int64
+	// counts[i]是落在(bounds[i-1], bounds[i]]中的次数，最后一个是超过所有上界的次数
+	bounds []time.Duration
+	counts []uint64
+}
+
+// 创建一个直方图，bounds是各个区间的上界，为空时使用DefaultLatencyBuckets
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// 记录一次耗时
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return d <= h.bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// 直方图的一个区间
+type Bucket struct {
+	// 区间的上界
+	UpperBound time.Duration
+	// 不超过上界的次数，即累计的次数
+	Count uint64
+}
+
+// 直方图在某一时刻的快照
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     time.Duration
+	Buckets []Bucket
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snap := HistogramSnapshot{Buckets: make([]Bucket, len(h.bounds))}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		snap.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	// 总次数由各个区间的次数相加得到，以免在读取的过程中被更新而与区间的次数不一致
+	snap.Count = cumulative + atomic.LoadUint64(&h.counts[len(h.bounds)])
+	snap.Sum = time.Duration(atomic.LoadInt64(&h.sum))
+	return snap
+}
+
+// 平均耗时，没有记录时返回0
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+/**
+估计分位数q（0到1之间）的耗时，返回第一个累计次数不少于q*Count的区间的上界，所以它的精度取决于区间的划分。
+落在最后一个上界之外的分位数无法估计，此时返回最后一个上界；没有记录时返回0。
+*/
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 || len(s.Buckets) == 0 {
+		return 0
+	}
+	// 向上取整，否则次数为奇数时中位数会落到前一个记录上，比如5次记录的中位数是第3次而不是第2次
+	rank := uint64(math.Ceil(q * float64(s.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	for _, b := range s.Buckets {
+		if b.Count >= rank {
+			return b.UpperBound
+		}
+	}
+	return s.Buckets[len(s.Buckets)-1].UpperBound
+}
+
+// 服务端的指标在某一时刻的快照
+type Stats struct {
+	// 当前打开的连接（包括数据报连接）的数量
+	ActiveConns int64
+	// 累计接受的连接的数量
+	AcceptedConns uint64
+	// 累计处理的请求的数量，不包括健康检查的请求
+	Requests uint64
+	// 其中得到错误响应的请求的数量
+	Errors uint64
+	// 累计读取的请求和写入的响应的消息的字节数，不包括分帧的开销
+	BytesIn  uint64
+	BytesOut uint64
+	// 因为等待请求超时而被关闭的连接的数量
+	ReadTimeouts uint64
+	// Handler处理请求的耗时
+	Latency HistogramSnapshot
+}
+
+// 服务端的计数器，它被单独分配并且64位的字段放在最前面，以保证32位平台上原子操作的对齐
+type serverMetrics struct {
+	accepted     uint64
+	requests     uint64
+	errors       uint64
+	bytesIn      uint64
+	bytesOut     uint64
+	readTimeouts uint64
+	active       int64
+	latency      *Histogram
+}
+
+// 返回服务端的指标的快照
+func (s *Server) Stats() Stats {
+	m := s.metrics
+	return Stats{
+		ActiveConns:   atomic.LoadInt64(&m.active),
+		AcceptedConns: atomic.LoadUint64(&m.accepted),
+		Requests:      atomic.LoadUint64(&m.requests),
+		Errors:        atomic.LoadUint64(&m.errors),
+		BytesIn:       atomic.LoadUint64(&m.bytesIn),
+		BytesOut:      atomic.LoadUint64(&m.bytesOut),
+		ReadTimeouts:  atomic.LoadUint64(&m.readTimeouts),
+		Latency:       m.latency.Snapshot(),
+	}
+}
+
+/**
+返回以Prometheus的文本格式输出服务端的指标的http.Handler，比如：
+	http.Handle("/metrics", server.MetricsHandler())
+*/
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.Stats().WritePrometheus(w)
+	})
+}
+
+/**
+把服务端的指标以给定的名字发布到expvar中，它们会出现在expvar.Handler（默认注册在/debug/vars）的输出中。
+与expvar.Publish一样，重复发布同一个名字会panic
+*/
+func (s *Server) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Stats()
+	}))
+}
+
+// 以Prometheus的文本格式输出指标，指标的名字以sock_开头，耗时以秒为单位
+func (st Stats) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string, value string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, value)
+	}
+	metric("sock_active_connections", "gauge", "Number of open connections.", strconv.FormatInt(st.ActiveConns, 10))
+	metric("sock_accepted_connections_total", "counter", "Number of accepted connections.", strconv.FormatUint(st.AcceptedConns, 10))
+	metric("sock_requests_total", "counter", "Number of handled requests.", strconv.FormatUint(st.Requests, 10))
+	metric("sock_request_errors_total", "counter", "Number of requests answered with an error.", strconv.FormatUint(st.Errors, 10))
+	metric("sock_received_bytes_total", "counter", "Bytes of received request messages.", strconv.FormatUint(st.BytesIn, 10))
+	metric("sock_sent_bytes_total", "counter", "Bytes of sent response messages.", strconv.FormatUint(st.BytesOut, 10))
+	metric("sock_read_timeouts_total", "counter", "Number of connections closed after a read timeout.", strconv.FormatUint(st.ReadTimeouts, 10))
+
+	const name = "sock_request_duration_seconds"
+	fmt.Fprintf(bw, "# HELP %s Time spent by the handler.\n# TYPE %s histogram\n", name, name)
+	for _, b := range st.Latency.Buckets {
+		fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", name, formatSeconds(b.UpperBound), b.Count)
+	}
+	fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", name, st.Latency.Count)
+	fmt.Fprintf(bw, "%s_sum %s\n", name, formatSeconds(st.Latency.Sum))
+	fmt.Fprintf(bw, "%s_count %d\n", name, st.Latency.Count)
+	return bw.Flush()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
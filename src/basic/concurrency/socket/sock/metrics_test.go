@@ -0,0 +1,125 @@
+package sock
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(100*time.Millisecond, time.Millisecond, 10*time.Millisecond)
+	for _, d := range []time.Duration{500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond, 50 * time.Millisecond, time.Second} {
+		h.Observe(d)
+	}
+	snap := h.Snapshot()
+	if snap.Count != 5 || snap.Sum != 1056500*time.Microsecond {
+		t.Fatalf("ERROR: The count is %d and the sum is %v", snap.Count, snap.Sum)
+	}
+	// 区间按照上界排序，次数是累计的
+	expected := []Bucket{{time.Millisecond, 2}, {10 * time.Millisecond, 3}, {100 * time.Millisecond, 4}}
+	for i, b := range snap.Buckets {
+		if b != expected[i] {
+			t.Fatalf("ERROR: The bucket %d is %v, expected %v", i, b, expected[i])
+		}
+	}
+	// 一共有奇数（5）次记录，中位数是第3次记录，它落在第二个区间中
+	for q, d := range map[float64]time.Duration{0: time.Millisecond, 0.4: time.Millisecond, 0.5: 10 * time.Millisecond, 0.6: 10 * time.Millisecond, 0.99: 100 * time.Millisecond, 1: 100 * time.Millisecond} {
+		if got := snap.Quantile(q); got != d {
+			t.Fatalf("ERROR: The quantile %v is %v, expected %v", q, got, d)
+		}
+	}
+	if snap.Mean() != 211300*time.Microsecond {
+		t.Fatalf("ERROR: The mean is %v", snap.Mean())
+	}
+	if empty := NewHistogram().Snapshot(); empty.Quantile(0.5) != 0 || empty.Mean() != 0 || len(empty.Buckets) != len(DefaultLatencyBuckets) {
+		t.Fatalf("ERROR: The empty snapshot is %v", empty)
+	}
+}
+
+// 在timeout之内等待条件成立
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("ERROR: Timed out waiting for the condition!")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	server, addr, _ := startServer(t, sleepHandler, WithReadTimeout(200*time.Millisecond))
+	client := dialClient(t, addr, WithCallTimeout(2*time.Second))
+	for _, req := range []string{"1", "2", "3", "x"} {
+		client.Call(context.Background(), []byte(req))
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping Error: %s", err)
+	}
+	st := server.Stats()
+	if st.AcceptedConns != 1 || st.ActiveConns != 1 || st.Requests != 4 || st.Errors != 1 || st.ReadTimeouts != 0 {
+		t.Fatalf("ERROR: The stats are %+v", st)
+	}
+	// 每个消息有10个字节的消息头，健康检查的请求也要计算流量
+	if st.BytesIn != 4*11+10 || st.BytesOut <= st.BytesIn-10 {
+		t.Fatalf("ERROR: %d bytes are received and %d bytes are sent", st.BytesIn, st.BytesOut)
+	}
+	if st.Latency.Count != 4 || st.Latency.Sum < time.Millisecond {
+		t.Fatalf("ERROR: The latency is %+v", st.Latency)
+	}
+	// 空闲的连接因为读超时而被关闭
+	waitFor(t, 2*time.Second, func() bool { return server.Stats().ActiveConns == 0 })
+	if st := server.Stats(); st.ReadTimeouts != 1 || st.AcceptedConns != 1 {
+		t.Fatalf("ERROR: The stats are %+v", st)
+	}
+}
+
+func TestServerStatsUDP(t *testing.T) {
+	server, addr, _ := startUDPServer(t, sleepHandler)
+	client, err := DialClient(addr, testFramer, time.Second, WithClientNetwork("udp"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	if _, err := client.Call(context.Background(), []byte("1")); err != nil {
+		t.Fatalf("Call Error: %s", err)
+	}
+	st := server.Stats()
+	if st.ActiveConns != 1 || st.AcceptedConns != 0 || st.Requests != 1 || st.BytesIn != 11 || st.BytesOut != 11 {
+		t.Fatalf("ERROR: The stats are %+v", st)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	server, addr, _ := startServer(t, sleepHandler)
+	client := dialClient(t, addr, WithCallTimeout(2*time.Second))
+	client.Call(context.Background(), []byte("1"))
+	client.Call(context.Background(), []byte("x"))
+
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("ERROR: The content type is %s", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE sock_active_connections gauge",
+		"sock_active_connections 1",
+		"sock_accepted_connections_total 1",
+		"# TYPE sock_requests_total counter",
+		"sock_requests_total 2",
+		"sock_request_errors_total 1",
+		"sock_read_timeouts_total 0",
+		"# TYPE sock_request_duration_seconds histogram",
+		`sock_request_duration_seconds_bucket{le="0.0001"} `,
+		`sock_request_duration_seconds_bucket{le="10"} 2`,
+		`sock_request_duration_seconds_bucket{le="+Inf"} 2`,
+		"sock_request_duration_seconds_count 2",
+	} {
+		if !strings.Contains(body, "\n"+line) && !strings.HasPrefix(body, line) {
+			t.Fatalf("ERROR: The metrics do not contain %q:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package sock
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/**
+Router的中间件。
+*/
+
+// 记录每个命令的名字、耗时和错误。logger为nil时使用log.Default()
+func Logging(logger Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(ctx context.Context, cmd *Command) ([]byte, error) {
+			begin := time.Now()
+			resp, err := next.HandleCommand(ctx, cmd)
+			if err != nil {
+				logger.Printf("Command %s%q failed in %v: %s", cmd.Name, cmd.Args, time.Since(begin), err)
+			} else {
+				logger.Printf("Command %s%q finished in %v.", cmd.Name, cmd.Args, time.Since(begin))
+			}
+			return resp, err
+		})
+	}
+}
+
+/**
+捕获命令中的panic并把它转换为种类为KindInternal的错误响应，否则一个命令的panic会使整个服务端进程崩溃。
+panic的值和调用栈会被记录到logger中（logger可以为nil），但不会发送给客户端。
+*/
+func Recovery(logger Logger) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(ctx context.Context, cmd *Command) (resp []byte, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					if logger != nil {
+						logger.Printf("Recovered from panic in command %s: %v\n%s", cmd.Name, p, debug.Stack())
+					}
+					resp, err = nil, Errorf(KindInternal, "internal error in command %s", cmd.Name)
+				}
+			}()
+			return next.HandleCommand(ctx, cmd)
+		})
+	}
+}
+
+/**
+使用令牌桶限制所有命令的总速率：桶中最多有burst个令牌，每秒补充rate个，每个命令消耗一个令牌。
+没有令牌时命令不会等待，而是立即得到种类为KindRateLimited的错误响应，客户端可以稍后重试。
+*/
+func RateLimit(rate float64, burst int) Middleware {
+	bucket := &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(ctx context.Context, cmd *Command) ([]byte, error) {
+			if !bucket.take(time.Now()) {
+				return nil, Errorf(KindRateLimited, "rate limit exceeded (%.1f/s, burst %d)", rate, burst)
+			}
+			return next.HandleCommand(ctx, cmd)
+		})
+	}
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+/**
+检查命令携带的认证令牌，令牌不在给定的令牌中的命令会得到种类为KindUnauthorized的错误响应。
+比较使用常量时间的算法，以免通过响应时间猜测令牌。
+*/
+func TokenAuth(tokens ...string) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return CommandHandlerFunc(func(ctx context.Context, cmd *Command) ([]byte, error) {
+			if cmd.Token == "" {
+				return nil, Errorf(KindUnauthorized, "missing token")
+			}
+			valid := 0
+			for _, token := range tokens {
+				valid |= subtle.ConstantTimeCompare([]byte(cmd.Token), []byte(token))
+			}
+			if valid != 1 {
+				return nil, Errorf(KindUnauthorized, "invalid token")
+			}
+			return next.HandleCommand(ctx, cmd)
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package sock
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/**
+客户端的连接池。
+调用时从池中取出一个空闲的连接，调用结束之后再把它放回池中，空闲的连接超过最大数量时会被关闭；打开的连接达到最大数量时，调用会等待其它的调用归还连接。
+取出空闲的连接时会检查它是否还可用，已经断开的连接会被丢弃，空闲了太久的连接会先发送一个健康检查的请求。
+建立连接失败时会按照指数退避的时间间隔重试。对于幂等的请求，如果调用因为连接的错误而失败，那么会换一个连接重试，因为重复执行它们是安全的；
+其它的请求可能已经被服务端执行过了，所以不会被重试。
+*/
+
+// 连接池的可选项
+type PoolOption func(p *Pool)
+
+// 设置池中空闲的连接的最大数量，默认是DefaultMaxIdle。0表示不保留空闲的连接
+func WithMaxIdle(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxIdle = n
+	}
+}
+
+// 设置池中打开的连接（包括正在使用的和空闲的）的最大数量。0表示不限制
+func WithMaxOpen(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxOpen = n
+	}
+}
+
+// 设置建立连接的超时时间，默认是DefaultDialTimeout
+func WithDialTimeout(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.dialTimeout = d
+	}
+}
+
+// 设置空闲多久的连接在被取出时要先进行健康检查，默认是DefaultHealthCheckAfter。0表示每次取出都检查，负数表示不检查
+func WithHealthCheckAfter(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.healthCheckAfter = d
+	}
+}
+
+// 设置重试的最小和最大退避时间，每次重试之后退避时间加倍，直到最大退避时间为止
+func WithBackoff(min, max time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.minBackoff = min
+		p.maxBackoff = max
+	}
+}
+
+// 设置建立连接和调用幂等请求的最大重试次数，默认是DefaultMaxRetries
+func WithMaxRetries(n int) PoolOption {
+	return func(p *Pool) {
+		p.maxRetries = n
+	}
+}
+
+// 设置池中的客户端的可选项
+func WithClientOptions(opts ...ClientOption) PoolOption {
+	return func(p *Pool) {
+		p.clientOpts = append(p.clientOpts, opts...)
+	}
+}
+
+// 连接池已被关闭时返回的错误
+var ErrPoolClosed = errors.New("sock: pool closed")
+
+const (
+	DefaultMaxIdle          = 2
+	DefaultDialTimeout      = 2 * time.Second
+	DefaultHealthCheckAfter = 30 * time.Second
+	DefaultMinBackoff       = 10 * time.Millisecond
+	DefaultMaxBackoff       = time.Second
+	DefaultMaxRetries       = 3
+)
+
+type Pool struct {
+	addr             string
+	framer           Framer
+	clientOpts       []ClientOption
+	maxIdle          int
+	maxOpen          int
+	dialTimeout      time.Duration
+	healthCheckAfter time.Duration
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	maxRetries       int
+
+	// 空闲的连接
+	idle chan *pooledClient
+	// 限制打开的连接的数量的票据，每个打开的连接持有一张票据
+	tickets chan struct{}
+	// 在Close时被关闭
+	done chan struct{}
+	// 保证done只被关闭一次，并发调用Close时也是如此
+	closeOnce sync.Once
+}
+
+type pooledClient struct {
+	*Client
+	// 最近一次被放回池中的时间
+	returnedAt time.Time
+}
+
+func NewPool(addr string, framer Framer, opts ...PoolOption) *Pool {
+	p := &Pool{
+		addr:             addr,
+		framer:           framer,
+		maxIdle:          DefaultMaxIdle,
+		dialTimeout:      DefaultDialTimeout,
+		healthCheckAfter: DefaultHealthCheckAfter,
+		minBackoff:       DefaultMinBackoff,
+		maxBackoff:       DefaultMaxBackoff,
+		maxRetries:       DefaultMaxRetries,
+		done:             make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.maxIdle > 0 {
+		p.idle = make(chan *pooledClient, p.maxIdle)
+	}
+	if p.maxOpen > 0 {
+		p.tickets = make(chan struct{}, p.maxOpen)
+	}
+	return p
+}
+
+// 使用池中的一个连接发送请求并等待它的响应。请求不会被重试
+func (p *Pool) Call(ctx context.Context, req []byte) ([]byte, error) {
+	return p.call(ctx, req, false)
+}
+
+// 与Call相同，但是请求必须是幂等的，因为它在连接出错时会被重试
+func (p *Pool) CallIdempotent(ctx context.Context, req []byte) ([]byte, error) {
+	return p.call(ctx, req, true)
+}
+
+// 关闭连接池和所有空闲的连接，正在使用的连接会在被归还时关闭
+func (p *Pool) Close() error {
+	closed := false
+	p.closeOnce.Do(func() {
+		close(p.done)
+		closed = true
+	})
+	if !closed {
+		return ErrPoolClosed
+	}
+	for {
+		select {
+		case pc := <-p.idle:
+			p.discard(pc)
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *Pool) call(ctx context.Context, req []byte, idempotent bool) ([]byte, error) {
+	backoff := p.minBackoff
+	for attempt := 0; ; attempt++ {
+		pc, err := p.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := pc.Call(ctx, req)
+		p.put(pc)
+		if err == nil || !idempotent || attempt >= p.maxRetries || !isConnError(err) {
+			return resp, err
+		}
+		if !p.sleep(ctx, backoff) {
+			return nil, err
+		}
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+// 调用的错误是否是连接的错误，只有这种错误才值得换一个连接重试
+func isConnError(err error) bool {
+	if _, ok := err.(*Error); ok {
+		return false
+	}
+	switch err {
+	case context.Canceled, context.DeadlineExceeded, ErrFrameTooLarge, ErrInvalidFrame:
+		return false
+	}
+	return true
+}
+
+// 取出一个可用的连接，在没有可用的连接时建立一个新的连接
+func (p *Pool) get(ctx context.Context) (*pooledClient, error) {
+	for {
+		// 优先使用空闲的连接
+		select {
+		case pc := <-p.idle:
+			if p.healthy(ctx, pc) {
+				return pc, nil
+			}
+			p.discard(pc)
+			continue
+		default:
+		}
+		if p.tickets == nil {
+			select {
+			case <-p.done:
+				return nil, ErrPoolClosed
+			default:
+			}
+			return p.dial(ctx)
+		}
+		select {
+		case pc := <-p.idle:
+			if p.healthy(ctx, pc) {
+				return pc, nil
+			}
+			p.discard(pc)
+		case p.tickets <- struct{}{}:
+			pc, err := p.dial(ctx)
+			if err != nil {
+				<-p.tickets
+			}
+			return pc, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.done:
+			return nil, ErrPoolClosed
+		}
+	}
+}
+
+// 把连接放回池中，已经断开的连接和超过空闲数量的连接会被关闭
+func (p *Pool) put(pc *pooledClient) {
+	if pc.closeErr() != nil {
+		p.discard(pc)
+		return
+	}
+	select {
+	case <-p.done:
+		p.discard(pc)
+		return
+	default:
+	}
+	pc.returnedAt = time.Now()
+	select {
+	case p.idle <- pc:
+	default:
+		p.discard(pc)
+	}
+}
+
+func (p *Pool) discard(pc *pooledClient) {
+	pc.Close()
+	if p.tickets != nil {
+		<-p.tickets
+	}
+}
+
+func (p *Pool) healthy(ctx context.Context, pc *pooledClient) bool {
+	if pc.closeErr() != nil {
+		return false
+	}
+	if p.healthCheckAfter < 0 || time.Since(pc.returnedAt) < p.healthCheckAfter {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.dialTimeout)
+	defer cancel()
+	return pc.Ping(ctx) == nil
+}
+
+// 建立一个新的连接，失败时按照指数退避的时间间隔重试
+func (p *Pool) dial(ctx context.Context) (*pooledClient, error) {
+	backoff := p.minBackoff
+	for attempt := 0; ; attempt++ {
+		client, err := DialClient(p.addr, p.framer, p.dialTimeout, p.clientOpts...)
+		if err == nil {
+			return &pooledClient{Client: client}, nil
+		}
+		if attempt >= p.maxRetries || !p.sleep(ctx, backoff) {
+			return nil, err
+		}
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+// 等待退避时间，加上最多一半的随机抖动以免大量的客户端同时重试。ctx被取消或者连接池被关闭时返回false
+func (p *Pool) sleep(ctx context.Context, backoff time.Duration) bool {
+	if backoff > 0 {
+		backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *Pool) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,340 @@
+package sock
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+可以在同一个地址上被停止和重新启动的服务端，用来模拟服务端的重启。
+*/
+type restartableServer struct {
+	t       *testing.T
+	addr    string
+	handler Handler
+	// 累计接受的连接的数量
+	accepted int32
+
+	mu       sync.Mutex
+	server   *Server
+	listener net.Listener
+}
+
+func newRestartableServer(t *testing.T, handler Handler) *restartableServer {
+	rs := &restartableServer{t: t, addr: "127.0.0.1:0", handler: handler}
+	rs.start()
+	t.Cleanup(func() { rs.stop() })
+	return rs
+}
+
+func (rs *restartableServer) start() {
+	listener, err := net.Listen("tcp", rs.addr)
+	if err != nil {
+		// 可能在其它的Goroutine中被调用，所以不能使用Fatalf
+		rs.t.Errorf("Listen Error: %s", err)
+		return
+	}
+	rs.addr = listener.Addr().String()
+	server := NewServer(rs.addr, testFramer, rs.handler)
+	rs.mu.Lock()
+	rs.server, rs.listener = server, listener
+	rs.mu.Unlock()
+	go server.Serve(&countingListener{listener, &rs.accepted})
+}
+
+// 强制关闭服务端和它的所有连接
+func (rs *restartableServer) stop() {
+	rs.mu.Lock()
+	server, listener := rs.server, rs.listener
+	rs.server, rs.listener = nil, nil
+	rs.mu.Unlock()
+	if server != nil {
+		// Serve可能还没有开始使用监听器，所以要自己关闭它，保证重新启动时地址已经被释放
+		listener.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		server.Shutdown(ctx)
+	}
+}
+
+type countingListener struct {
+	net.Listener
+	accepted *int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(l.accepted, 1)
+	}
+	return conn, err
+}
+
+func newTestPool(t *testing.T, addr string, opts ...PoolOption) *Pool {
+	opts = append([]PoolOption{
+		WithDialTimeout(time.Second),
+		WithBackoff(5*time.Millisecond, 50*time.Millisecond),
+		WithClientOptions(WithCallTimeout(2 * time.Second)),
+	}, opts...)
+	pool := NewPool(addr, testFramer, opts...)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestPoolReuse(t *testing.T) {
+	rs := newRestartableServer(t, upperHandler)
+	pool := newTestPool(t, rs.addr, WithMaxIdle(1))
+	for i := 0; i < 5; i++ {
+		if resp, err := pool.Call(context.Background(), []byte("reuse")); err != nil || string(resp) != "REUSE" {
+			t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+		}
+	}
+	if n := atomic.LoadInt32(&rs.accepted); n != 1 {
+		t.Fatalf("ERROR: %d connections are established, expected 1", n)
+	}
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	var active, maxActive int32
+	rs := newRestartableServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return req, nil
+	}))
+	pool := newTestPool(t, rs.addr, WithMaxOpen(2), WithMaxIdle(2))
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Call(context.Background(), []byte("x")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxActive != 2 {
+		t.Fatalf("ERROR: %d calls are made concurrently, expected 2", maxActive)
+	}
+	if n := atomic.LoadInt32(&rs.accepted); n != 2 {
+		t.Fatalf("ERROR: %d connections are established, expected 2", n)
+	}
+	// 等待连接的调用会因为ctx超时而返回
+	block := make(chan struct{})
+	defer close(block)
+	rs.handler = HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		<-block
+		return req, nil
+	})
+	rs.stop()
+	rs.start()
+	for i := 0; i < 2; i++ {
+		go pool.Call(context.Background(), []byte("blocked"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Call(ctx, []byte("waiting")); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: The waiting call returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+// 空闲的连接被服务端断开之后，下一次调用会丢弃它并建立新的连接
+func TestPoolDiscardBroken(t *testing.T) {
+	rs := newRestartableServer(t, upperHandler)
+	pool := newTestPool(t, rs.addr)
+	if _, err := pool.Call(context.Background(), []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	rs.stop()
+	rs.start()
+	// 等待客户端发现连接已经断开
+	time.Sleep(20 * time.Millisecond)
+	if resp, err := pool.Call(context.Background(), []byte("after")); err != nil || string(resp) != "AFTER" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+	if n := atomic.LoadInt32(&rs.accepted); n != 2 {
+		t.Fatalf("ERROR: %d connections are established, expected 2", n)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	var calls int32
+	rs := newRestartableServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return req, nil
+	}))
+	pool := newTestPool(t, rs.addr, WithHealthCheckAfter(0))
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Call(context.Background(), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 健康检查的请求不会被交给Handler
+	if calls != 3 {
+		t.Fatalf("ERROR: The handler is called %d times, expected 3", calls)
+	}
+	if n := atomic.LoadInt32(&rs.accepted); n != 1 {
+		t.Fatalf("ERROR: %d connections are established, expected 1", n)
+	}
+}
+
+/**
+服务端在调用的过程中被重启：幂等的调用会在新的服务端上重试并成功，其它的调用返回连接的错误。
+*/
+func TestPoolRestartMidCall(t *testing.T) {
+	started := make(chan struct{}, 2)
+	rs := newRestartableServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		if string(req) == "slow" {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return req, nil
+	}))
+	pool := newTestPool(t, rs.addr)
+
+	restart := func() <-chan struct{} {
+		restarted := make(chan struct{})
+		go func() {
+			defer close(restarted)
+			<-started
+			rs.stop()
+			time.Sleep(20 * time.Millisecond)
+			rs.start()
+		}()
+		return restarted
+	}
+	restarted := restart()
+	if _, err := pool.Call(context.Background(), []byte("slow")); !isConnError(err) {
+		t.Fatalf("ERROR: The call returned %v, expected a connection error", err)
+	}
+	<-restarted
+
+	// 幂等的请求在第一次执行时服务端被重启，重试时服务端把请求改为快速的请求
+	var attempts int32
+	rs.handler = HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return req, nil
+	})
+	rs.stop()
+	rs.start()
+	restart()
+	if resp, err := pool.CallIdempotent(context.Background(), []byte("idempotent")); err != nil || string(resp) != "idempotent" {
+		t.Fatalf("ERROR: The idempotent call returned (%q, %v)", resp, err)
+	}
+	if attempts != 2 {
+		t.Fatalf("ERROR: The idempotent request is handled %d times, expected 2", attempts)
+	}
+}
+
+// 服务端不可用时按照指数退避的时间间隔重新连接，服务端恢复之后调用成功
+func TestPoolReconnectBackoff(t *testing.T) {
+	rs := newRestartableServer(t, upperHandler)
+	rs.stop()
+	pool := newTestPool(t, rs.addr, WithBackoff(10*time.Millisecond, time.Second), WithMaxRetries(3))
+	begin := time.Now()
+	if _, err := pool.Call(context.Background(), []byte("down")); err == nil {
+		t.Fatal("ERROR: The call succeeded while the server is down!")
+	}
+	// 10ms+20ms+40ms，再加上随机抖动
+	if elapsed := time.Since(begin); elapsed < 70*time.Millisecond {
+		t.Fatalf("ERROR: The pool gives up after %v, expected at least 70ms of backoff", elapsed)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		rs.start()
+	}()
+	if resp, err := pool.Call(context.Background(), []byte("up")); err != nil || string(resp) != "UP" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
+
+/**
+在服务端被反复重启的过程中持续地进行幂等的调用，所有的调用都应该成功。
+*/
+func TestPoolRestartStress(t *testing.T) {
+	rs := newRestartableServer(t, upperHandler)
+	pool := newTestPool(t, rs.addr, WithMaxOpen(4), WithMaxRetries(10))
+	stop := make(chan struct{})
+	var restarts sync.WaitGroup
+	restarts.Add(1)
+	go func() {
+		defer restarts.Done()
+		for i := 0; i < 5; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(30 * time.Millisecond):
+			}
+			rs.stop()
+			time.Sleep(10 * time.Millisecond)
+			rs.start()
+		}
+	}()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if resp, err := pool.CallIdempotent(context.Background(), []byte("stress")); err != nil || string(resp) != "STRESS" {
+					t.Errorf("ERROR: The response is (%q, %v)", resp, err)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	restarts.Wait()
+	if n := atomic.LoadInt32(&rs.accepted); n < 2 {
+		t.Fatalf("ERROR: Only %d connections are established, the server is not restarted!", n)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	rs := newRestartableServer(t, upperHandler)
+	pool := newTestPool(t, rs.addr)
+	if _, err := pool.Call(context.Background(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	// 并发调用Close时只有一个调用成功，其它的调用返回ErrPoolClosed
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pool.Close(); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			} else if err != ErrPoolClosed {
+				t.Errorf("ERROR: Close returned %v, expected %v", err, ErrPoolClosed)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Fatalf("ERROR: %d calls to Close succeeded", succeeded)
+	}
+	if _, err := pool.Call(context.Background(), []byte("x")); err != ErrPoolClosed {
+		t.Fatalf("ERROR: The call after Close returned %v, expected %v", err, ErrPoolClosed)
+	}
+}
@@ -0,0 +1,131 @@
+package sock
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+/**
+按照命令的名字分发请求的路由器，它本身就是一个Handler，所以一个服务端可以提供多种操作。
+请求的内容是一个JSON编码的命令，比如{"name":"cbrt","args":["27"]}，用EncodeCommand生成。
+每个命令的名字对应一个CommandHandler，找不到对应的CommandHandler的请求会得到种类为KindUnknownCommand的错误响应。
+中间件包装在分发之外，所以日志、限流和认证对所有的请求都生效，包括未知的命令。
+*/
+
+// 一个命令
+type Command struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	// 认证令牌，参见TokenAuth
+	Token string `json:"token,omitempty"`
+}
+
+// 把命令编码为请求的内容
+func EncodeCommand(cmd *Command) []byte {
+	// 只包含字符串的结构体的编码不会失败
+	data, _ := json.Marshal(cmd)
+	return data
+}
+
+// 从请求的内容中解析命令，失败时返回种类为KindParse的错误
+func ParseCommand(req []byte) (*Command, error) {
+	cmd := new(Command)
+	if err := json.Unmarshal(req, cmd); err != nil {
+		return nil, Errorf(KindParse, "invalid command: %s", err)
+	}
+	if cmd.Name == "" {
+		return nil, Errorf(KindParse, "invalid command: the name is empty")
+	}
+	return cmd, nil
+}
+
+// 处理一个命令的接口
+type CommandHandler interface {
+	HandleCommand(ctx context.Context, cmd *Command) ([]byte, error)
+}
+
+// 把普通函数适配为CommandHandler
+type CommandHandlerFunc func(ctx context.Context, cmd *Command) ([]byte, error)
+
+func (f CommandHandlerFunc) HandleCommand(ctx context.Context, cmd *Command) ([]byte, error) {
+	return f(ctx, cmd)
+}
+
+// 中间件，它包装一个CommandHandler并返回新的CommandHandler
+type Middleware func(next CommandHandler) CommandHandler
+
+type Router struct {
+	mu          sync.RWMutex
+	routes      map[string]CommandHandler
+	middlewares []Middleware
+	// 被中间件包装之后的分发函数，在注册中间件时重新生成
+	chain CommandHandler
+}
+
+func NewRouter() *Router {
+	r := &Router{routes: make(map[string]CommandHandler)}
+	r.chain = CommandHandlerFunc(r.dispatch)
+	return r
+}
+
+// 注册一个命令，重复注册同一个名字时后注册的会替换先注册的
+func (r *Router) Register(name string, h CommandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[name] = h
+}
+
+// 以函数的形式注册一个命令
+func (r *Router) RegisterFunc(name string, f func(ctx context.Context, cmd *Command) ([]byte, error)) {
+	r.Register(name, CommandHandlerFunc(f))
+}
+
+/**
+添加中间件。先添加的中间件在外层，比如Use(Recovery(l), Logging(l))时，Recovery可以捕获Logging和命令中的panic。
+*/
+func (r *Router) Use(mws ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, mws...)
+	var h CommandHandler = CommandHandlerFunc(r.dispatch)
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		h = r.middlewares[i](h)
+	}
+	r.chain = h
+}
+
+// 已经注册的命令的名字，按照字典序排列
+func (r *Router) Commands() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.routes))
+	for name := range r.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 实现Handler接口：解析命令并经过中间件分发给对应的CommandHandler
+func (r *Router) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	cmd, err := ParseCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	chain := r.chain
+	r.mu.RUnlock()
+	return chain.HandleCommand(ctx, cmd)
+}
+
+func (r *Router) dispatch(ctx context.Context, cmd *Command) ([]byte, error) {
+	r.mu.RLock()
+	h, ok := r.routes[cmd.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, Errorf(KindUnknownCommand, "unknown command %q", cmd.Name)
+	}
+	return h.HandleCommand(ctx, cmd)
+}
@@ -0,0 +1,214 @@
+package sock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRouter() *Router {
+	router := NewRouter()
+	router.RegisterFunc("echo", func(ctx context.Context, cmd *Command) ([]byte, error) {
+		return []byte(strings.Join(cmd.Args, " ")), nil
+	})
+	router.RegisterFunc("upper", func(ctx context.Context, cmd *Command) ([]byte, error) {
+		if len(cmd.Args) != 1 {
+			return nil, Errorf(KindParse, "upper takes 1 argument, got %d", len(cmd.Args))
+		}
+		return []byte(strings.ToUpper(cmd.Args[0])), nil
+	})
+	router.RegisterFunc("panic", func(ctx context.Context, cmd *Command) ([]byte, error) {
+		panic("boom")
+	})
+	return router
+}
+
+func command(name string, args ...string) []byte {
+	return EncodeCommand(&Command{Name: name, Args: args})
+}
+
+func errorKind(t *testing.T, err error) ErrorKind {
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("ERROR: The error %#v is not a *Error", err)
+	}
+	return e.Kind
+}
+
+func TestRouter(t *testing.T) {
+	router := newTestRouter()
+	if resp, err := router.Handle(context.Background(), command("echo", "a", "b c")); err != nil || string(resp) != "a b c" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+	if resp, err := router.Handle(context.Background(), command("upper", "x")); err != nil || string(resp) != "X" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+	if _, err := router.Handle(context.Background(), command("upper")); errorKind(t, err) != KindParse {
+		t.Fatalf("ERROR: The error is %v, expected a parse error", err)
+	}
+	if _, err := router.Handle(context.Background(), command("missing")); errorKind(t, err) != KindUnknownCommand {
+		t.Fatalf("ERROR: The error is %v, expected an unknown command error", err)
+	}
+	for _, req := range []string{"not json", `{"args":["x"]}`} {
+		if _, err := router.Handle(context.Background(), []byte(req)); errorKind(t, err) != KindParse {
+			t.Fatalf("ERROR: The error of %q is %v, expected a parse error", req, err)
+		}
+	}
+	if names := strings.Join(router.Commands(), ","); names != "echo,panic,upper" {
+		t.Fatalf("ERROR: The commands are %s", names)
+	}
+}
+
+// 先添加的中间件在外层
+func TestRouterMiddlewareOrder(t *testing.T) {
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next CommandHandler) CommandHandler {
+			return CommandHandlerFunc(func(ctx context.Context, cmd *Command) ([]byte, error) {
+				trace = append(trace, name+">")
+				resp, err := next.HandleCommand(ctx, cmd)
+				trace = append(trace, "<"+name)
+				return resp, err
+			})
+		}
+	}
+	router := newTestRouter()
+	router.Use(mark("a"), mark("b"))
+	router.Use(mark("c"))
+	// 未知的命令也经过中间件
+	router.Handle(context.Background(), command("missing"))
+	if got := strings.Join(trace, " "); got != "a> b> c> <c <b <a" {
+		t.Fatalf("ERROR: The middleware trace is %s", got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var logs []string
+	router := newTestRouter()
+	router.Use(Recovery(loggerFunc(func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	})))
+	_, err := router.Handle(context.Background(), command("panic"))
+	if errorKind(t, err) != KindInternal || strings.Contains(err.Error(), "boom") {
+		t.Fatalf("ERROR: The error is %v", err)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "boom") {
+		t.Fatalf("ERROR: The panic is not logged: %q", logs)
+	}
+	if resp, err := router.Handle(context.Background(), command("echo", "alive")); err != nil || string(resp) != "alive" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var logs []string
+	router := newTestRouter()
+	router.Use(Logging(loggerFunc(func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	})))
+	router.Handle(context.Background(), command("echo", "x"))
+	router.Handle(context.Background(), command("missing"))
+	if len(logs) != 2 || !strings.HasPrefix(logs[0], `Command echo["x"] finished`) || !strings.Contains(logs[1], "unknown command") {
+		t.Fatalf("ERROR: The logs are %q", logs)
+	}
+	// logger为nil时记录到log.Default()中
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	router = newTestRouter()
+	router.Use(Logging(nil))
+	router.Handle(context.Background(), command("echo", "x"))
+	if !strings.Contains(buf.String(), `Command echo["x"] finished`) {
+		t.Fatalf("ERROR: The default logger logs %q", buf.String())
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 10, burst: 2, tokens: 2, last: now}
+	if !b.take(now) || !b.take(now) || b.take(now) {
+		t.Fatal("ERROR: The burst is not 2!")
+	}
+	// 100ms补充1个令牌
+	if b.take(now.Add(50*time.Millisecond)) || !b.take(now.Add(150*time.Millisecond)) {
+		t.Fatal("ERROR: The tokens are not refilled at 10/s!")
+	}
+	// 令牌不会超过桶的容量
+	later := now.Add(time.Hour)
+	if !b.take(later) || !b.take(later) || b.take(later) {
+		t.Fatal("ERROR: The refilled tokens exceed the burst!")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	router := newTestRouter()
+	router.Use(RateLimit(1, 3))
+	var limited int
+	for i := 0; i < 5; i++ {
+		if _, err := router.Handle(context.Background(), command("echo")); err != nil {
+			if errorKind(t, err) != KindRateLimited {
+				t.Fatalf("ERROR: The error is %v, expected a rate limited error", err)
+			}
+			limited++
+		}
+	}
+	if limited != 2 {
+		t.Fatalf("ERROR: %d commands are limited, expected 2", limited)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	router := newTestRouter()
+	router.Use(TokenAuth("secret", "other"))
+	for token, kind := range map[string]ErrorKind{"": KindUnauthorized, "wrong": KindUnauthorized, "secre": KindUnauthorized} {
+		_, err := router.Handle(context.Background(), EncodeCommand(&Command{Name: "echo", Token: token}))
+		if errorKind(t, err) != kind {
+			t.Fatalf("ERROR: The error of token %q is %v", token, err)
+		}
+	}
+	for _, token := range []string{"secret", "other"} {
+		resp, err := router.Handle(context.Background(), EncodeCommand(&Command{Name: "echo", Args: []string{"ok"}, Token: token}))
+		if err != nil || string(resp) != "ok" {
+			t.Fatalf("ERROR: The response of token %q is (%q, %v)", token, resp, err)
+		}
+	}
+}
+
+// 路由器作为服务端的Handler，客户端通过错误的种类区分各种失败
+func TestServeRouter(t *testing.T) {
+	router := newTestRouter()
+	router.Use(Recovery(nil), TokenAuth("secret"))
+	_, addr, _ := startServer(t, router)
+	client := dialClient(t, addr, WithCallTimeout(2*time.Second))
+	var wg sync.WaitGroup
+	cases := map[string]ErrorKind{"echo": 255, "missing": KindUnknownCommand, "panic": KindInternal}
+	for name, kind := range cases {
+		wg.Add(1)
+		go func(name string, kind ErrorKind) {
+			defer wg.Done()
+			resp, err := client.Call(context.Background(), EncodeCommand(&Command{Name: name, Args: []string{"hi"}, Token: "secret"}))
+			if kind == 255 {
+				if err != nil || string(resp) != "hi" {
+					t.Errorf("ERROR: The response of %s is (%q, %v)", name, resp, err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Kind != kind {
+				t.Errorf("ERROR: The error of %s is %v, expected a %s error", name, err, kind)
+			}
+		}(name, kind)
+	}
+	wg.Wait()
+	_, err := client.Call(context.Background(), command("echo"))
+	if errorKind(t, err) != KindUnauthorized {
+		t.Fatalf("ERROR: The error is %v, expected an unauthorized error", err)
+	}
+}
@@ -0,0 +1,608 @@
+package sock
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+一个可复用的服务端，支持TCP、Unix域套接字和UDP，参见transport.go。
+它在给定的地址上监听，为每个连接启动一个Goroutine，按照给定的分帧策略从连接中读取请求，交给Handler处理之后再把响应写回连接。
+同一个连接上的多个请求会被并发地处理，响应按照处理完成的顺序写回，客户端通过响应中的请求ID找到与之对应的请求。
+服务端的连接、请求、流量和耗时被记录为指标，参见metrics.go。
+*/
+
+// 处理请求的接口
+type Handler interface {
+	// 处理一个请求并返回响应。返回的错误会作为状态为StatusError的响应返回给客户端，返回*Error可以指定错误的种类。ctx会在服务端被强制关闭时被取消。
+	// 同一个连接上的请求可能被并发地处理，req在Handle返回之后不会被修改
+	Handle(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// 把普通函数适配为Handler
+type HandlerFunc func(ctx context.Context, req []byte) ([]byte, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	return f(ctx, req)
+}
+
+// 日志记录器，*log.Logger满足这个接口
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// 服务端的可选项
+type Option func(s *Server)
+
+// 设置监听的网络，默认是"tcp"。对于"unix"和"unixpacket"，地址是套接字文件的路径
+func WithNetwork(network string) Option {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// 设置面向数据报的网络中一个数据报的最大长度，默认是DefaultMaxPacketSize。超过最大长度的请求会被丢弃
+func WithMaxPacketSize(n int) Option {
+	return func(s *Server) {
+		s.maxPacketSize = n
+	}
+}
+
+// 设置同时存在的连接的最大数量，达到这个数量之后不再接受新的连接，直到有连接被关闭为止。0表示不限制
+func WithMaxConns(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// 设置等待读取一个请求的超时时间，超时的连接会被关闭。0表示不超时
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = d
+	}
+}
+
+// 设置写入一个响应的超时时间。0表示不超时
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// 设置一个连接上同时处理的请求的最大数量，达到这个数量之后不再读取这个连接上的新请求。0表示不限制。
+// 对于UDP，它限制的是整个服务端同时处理的请求的数量
+func WithMaxPipelined(n int) Option {
+	return func(s *Server) {
+		s.maxPipelined = n
+	}
+}
+
+// 设置消息的编码方式，默认使用BinaryCodec
+func WithMessageCodec(codec MessageCodec) Option {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
+
+// 设置TLS配置，服务端只接受TLS连接。使用mTLS时在配置中设置ClientAuth和ClientCAs，参见ServerTLSConfig
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// 设置日志记录器，默认不记录日志
+func WithLogger(l Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// 服务端已被关闭时Serve和ListenAndServe返回的错误
+var ErrServerClosed = errors.New("sock: server closed")
+
+// 默认的超时时间，与tcpsock.go最初硬编码的值相同
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = 5 * time.Second
+)
+
+// 默认的一个连接上同时处理的请求的最大数量
+const DefaultMaxPipelined = 64
+
+type Server struct {
+	network       string
+	addr          string
+	maxPacketSize int
+	framer        Framer
+	codec         MessageCodec
+	handler       Handler
+	maxConns      int
+	maxPipelined  int
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	tlsConfig     *tls.Config
+	logger        Logger
+	metrics       *serverMetrics
+
+	// 保护下面的字段
+	mu          sync.Mutex
+	listeners   map[net.Listener]struct{}
+	packetConns map[net.PacketConn]struct{}
+	conns       map[*serverConn]struct{}
+	// 在Shutdown时被关闭
+	done chan struct{}
+	// 限制连接数量的票据，与channel.go中的goTicket是一样的思路
+	tickets chan struct{}
+	// 所有连接的处理Goroutine，以及所有正在处理数据报的ServePacket
+	connWg sync.WaitGroup
+	// 传给Handler的上下文，在强制关闭时被取消
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewServer(addr string, framer Framer, handler Handler, opts ...Option) *Server {
+	s := &Server{
+		network:      "tcp",
+		addr:         addr,
+		framer:       framer,
+		codec:        BinaryCodec(),
+		handler:      handler,
+		maxPipelined: DefaultMaxPipelined,
+		readTimeout:  DefaultReadTimeout,
+		writeTimeout: DefaultWriteTimeout,
+		listeners:    make(map[net.Listener]struct{}),
+		packetConns:  make(map[net.PacketConn]struct{}),
+		conns:        make(map[*serverConn]struct{}),
+		done:         make(chan struct{}),
+		metrics:      &serverMetrics{latency: NewHistogram()},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxConns > 0 {
+		s.tickets = make(chan struct{}, s.maxConns)
+	}
+	if s.maxPacketSize <= 0 {
+		s.maxPacketSize = DefaultMaxPacketSize
+	}
+	s.framer = framerFor(s.network, s.framer, s.maxPacketSize)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// 在服务端的网络和地址上监听并处理连接（对于UDP是数据报），直到服务端被关闭为止
+func (s *Server) ListenAndServe() error {
+	if isDatagramNetwork(s.network) {
+		conn, err := net.ListenPacket(s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		return s.ServePacket(conn)
+	}
+	if s.network == "unix" || s.network == "unixpacket" {
+		if err := removeStaleSocket(s.network, s.addr); err != nil {
+			return err
+		}
+	}
+	// Unix域套接字的监听器在被关闭时会删除套接字文件
+	listener, err := net.Listen(s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// 在给定的监听器上接受并处理连接，直到服务端被关闭为止。它总是会关闭监听器，并且总是返回一个非nil的错误，服务端被关闭时返回ErrServerClosed。
+// 设置了TLS配置时，监听器接受的连接会被包装为TLS连接
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	if err := checkCodec(s.framer, s.codec); err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	if !s.trackListener(listener, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(listener, false)
+	s.logf("Got listener for the server. (local address: %s)", listener.Addr())
+	var tempDelay time.Duration
+	for {
+		if !s.acquireTicket() {
+			return ErrServerClosed
+		}
+		// 阻塞直到新连接到来
+		conn, err := listener.Accept()
+		if err != nil {
+			s.releaseTicket()
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			// 对于暂时性的错误（比如文件描述符耗尽），等待一段时间之后重试
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				s.logf("Accept Error: %s; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		s.logf("Established a connection with a client application. (remote address: %s)", conn.RemoteAddr())
+		c := &serverConn{server: s, conn: conn}
+		if !s.trackConn(c, true) {
+			conn.Close()
+			s.releaseTicket()
+			return ErrServerClosed
+		}
+		atomic.AddUint64(&s.metrics.accepted, 1)
+		atomic.AddInt64(&s.metrics.active, 1)
+		go c.serve()
+	}
+}
+
+/**
+在给定的数据报连接上接收并处理请求，直到服务端被关闭为止。它总是会关闭连接，并且总是返回一个非nil的错误，服务端被关闭时返回ErrServerClosed。
+每个数据报是一个请求，响应被发送回请求的来源地址。请求被并发地处理，同时处理的数量由WithMaxPipelined限制
+*/
+func (s *Server) ServePacket(conn net.PacketConn) error {
+	defer conn.Close()
+	if !s.trackPacketConn(conn, true) {
+		return ErrServerClosed
+	}
+	atomic.AddInt64(&s.metrics.active, 1)
+	var inflight sync.WaitGroup
+	defer func() {
+		inflight.Wait()
+		atomic.AddInt64(&s.metrics.active, -1)
+		s.trackPacketConn(conn, false)
+	}()
+	s.logf("Got packet connection for the server. (local address: %s)", conn.LocalAddr())
+	var pipeline chan struct{}
+	if s.maxPipelined > 0 {
+		pipeline = make(chan struct{}, s.maxPipelined)
+	}
+	buf := make([]byte, s.maxPacketSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return ErrServerClosed
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.logf("Read Error: %s; retrying", err)
+				continue
+			}
+			return err
+		}
+		if n > s.maxPacketSize {
+			s.logf("Read Error: %s (remote address: %s)", ErrFrameTooLarge, addr)
+			continue
+		}
+		atomic.AddUint64(&s.metrics.bytesIn, uint64(n))
+		var req Message
+		if err := s.codec.DecodeMessage(buf[:n], &req); err != nil {
+			s.logf("Decode Error: %s (remote address: %s)", err, addr)
+			continue
+		}
+		// 缓冲区会被下一个数据报覆盖，所以在交给其它Goroutine之前要复制请求的内容
+		body := append([]byte(nil), req.Body...)
+		if pipeline != nil {
+			pipeline <- struct{}{}
+		}
+		inflight.Add(1)
+		go func(id uint64, status Status, addr net.Addr) {
+			defer func() {
+				if pipeline != nil {
+					<-pipeline
+				}
+				inflight.Done()
+			}()
+			resp := s.respond(id, status, body)
+			data, err := s.codec.AppendMessage(nil, &resp)
+			if err == nil && len(data) > s.maxPacketSize {
+				err = ErrFrameTooLarge
+			}
+			if err == nil {
+				_, err = conn.WriteTo(data, addr)
+			}
+			if err == nil {
+				atomic.AddUint64(&s.metrics.bytesOut, uint64(len(data)))
+			}
+			if err != nil {
+				s.logf("Write Error: %s (remote address: %s)", err, addr)
+			}
+		}(req.ID, req.Status, addr)
+	}
+}
+
+/**
+优雅地关闭服务端：首先关闭所有的监听器使其不再接受新的连接，然后通知所有的连接在处理完当前的请求之后关闭，并等待它们全部关闭。
+如果在此之前ctx被取消，那么就强制关闭剩余的连接并返回ctx的错误。
+*/
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	// 数据报连接在已经读取的请求都被处理完之后才会被关闭，这样它们的响应才能被写回
+	for pc := range s.packetConns {
+		pc.SetReadDeadline(time.Now())
+	}
+	for c := range s.conns {
+		c.shutdown()
+	}
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.connWg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		s.mu.Lock()
+		for pc := range s.packetConns {
+			pc.Close()
+		}
+		for c := range s.conns {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackPacketConn(pc net.PacketConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+		s.packetConns[pc] = struct{}{}
+		s.connWg.Add(1)
+	} else {
+		delete(s.packetConns, pc)
+		s.connWg.Done()
+	}
+	return true
+}
+
+func (s *Server) trackConn(c *serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		select {
+		case <-s.done:
+			return false
+		default:
+		}
+		s.conns[c] = struct{}{}
+		s.connWg.Add(1)
+	} else {
+		delete(s.conns, c)
+		s.connWg.Done()
+	}
+	return true
+}
+
+// 获取一张票据，服务端被关闭时返回false
+func (s *Server) acquireTicket() bool {
+	if s.tickets == nil {
+		return true
+	}
+	select {
+	case s.tickets <- struct{}{}:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Server) releaseTicket() {
+	if s.tickets != nil {
+		<-s.tickets
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// 服务端的一个连接
+type serverConn struct {
+	server *Server
+	conn   net.Conn
+	// 保护closing，并保证设置读超时和关闭通知不会交错
+	mu      sync.Mutex
+	closing bool
+	// 并发处理的请求的响应要逐个写入
+	writeMu sync.Mutex
+	writer  FrameWriter
+	wbuf    []byte
+}
+
+func (c *serverConn) serve() {
+	s := c.server
+	// 正在处理的请求，连接要在它们的响应都被写回之后才能关闭
+	var inflight sync.WaitGroup
+	defer func() {
+		inflight.Wait()
+		c.conn.Close()
+		atomic.AddInt64(&s.metrics.active, -1)
+		s.trackConn(c, false)
+		s.releaseTicket()
+	}()
+	var pipeline chan struct{}
+	if s.maxPipelined > 0 {
+		pipeline = make(chan struct{}, s.maxPipelined)
+	}
+	reader := s.framer.NewFrameReader(c.conn)
+	c.writer = s.framer.NewFrameWriter(c.conn)
+	for {
+		if !c.setReadDeadline() {
+			return
+		}
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				s.logf("The connection is closed by another side. (remote address: %s)", c.conn.RemoteAddr())
+			} else if c.isClosing() {
+				// 服务端正在关闭，读超时是Shutdown造成的
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				atomic.AddUint64(&s.metrics.readTimeouts, 1)
+				s.logf("Read Timeout: %s (remote address: %s)", err, c.conn.RemoteAddr())
+			} else {
+				s.logf("Read Error: %s (remote address: %s)", err, c.conn.RemoteAddr())
+			}
+			return
+		}
+		atomic.AddUint64(&s.metrics.bytesIn, uint64(len(frame)))
+		var req Message
+		if err := s.codec.DecodeMessage(frame, &req); err != nil {
+			s.logf("Decode Error: %s (remote address: %s)", err, c.conn.RemoteAddr())
+			return
+		}
+		// 帧读取器会复用它的缓冲区，所以在交给其它Goroutine之前要复制请求的内容
+		body := append([]byte(nil), req.Body...)
+		if pipeline != nil {
+			pipeline <- struct{}{}
+		}
+		inflight.Add(1)
+		go func(id uint64, status Status) {
+			defer func() {
+				if pipeline != nil {
+					<-pipeline
+				}
+				inflight.Done()
+			}()
+			c.handle(id, status, body)
+		}(req.ID, req.Status)
+	}
+}
+
+// 处理一个请求并生成它的响应
+func (s *Server) respond(id uint64, status Status, req []byte) Message {
+	resp := Message{ID: id, Status: StatusOK}
+	if status != StatusPing {
+		begin := time.Now()
+		body, err := s.handler.Handle(s.ctx, req)
+		s.metrics.latency.Observe(time.Since(begin))
+		atomic.AddUint64(&s.metrics.requests, 1)
+		if err != nil {
+			atomic.AddUint64(&s.metrics.errors, 1)
+			// 向客户端输出错误的种类和信息，而不是把错误信息当作正常的响应内容
+			resp.Status, resp.Kind = StatusError, KindInternal
+			body = []byte(err.Error())
+			var e *Error
+			if errors.As(err, &e) {
+				resp.Kind, body = e.Kind, []byte(e.Message)
+			}
+		}
+		resp.Body = body
+	}
+	return resp
+}
+
+func (c *serverConn) handle(id uint64, status Status, req []byte) {
+	s := c.server
+	resp := s.respond(id, status, req)
+	if err := c.writeMessage(&resp); err != nil {
+		s.logf("Write Error: %s (remote address: %s)", err, c.conn.RemoteAddr())
+		// 连接已经不可用了，关闭它使读取请求的循环退出
+		c.conn.Close()
+	}
+}
+
+func (c *serverConn) writeMessage(m *Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	buf, err := c.server.codec.AppendMessage(c.wbuf[:0], m)
+	if err != nil {
+		return err
+	}
+	c.wbuf = buf
+	if c.server.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.server.writeTimeout))
+	}
+	if err := c.writer.WriteFrame(buf); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.server.metrics.bytesOut, uint64(len(buf)))
+	return nil
+}
+
+// 设置读取下一个请求的超时时间，连接正在被关闭时返回false
+func (c *serverConn) setReadDeadline() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	var deadline time.Time
+	if c.server.readTimeout > 0 {
+		deadline = time.Now().Add(c.server.readTimeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+	return true
+}
+
+// 通知连接关闭：正在等待请求的连接会因为读超时而立即返回，正在处理请求的连接会在写回响应之后返回
+func (c *serverConn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closing = true
+	c.conn.SetReadDeadline(time.Now())
+}
+
+func (c *serverConn) isClosing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closing
+}
@@ -0,0 +1,203 @@
+package sock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFramer = LengthFramer(0)
+
+// 在随机端口上启动服务端，测试结束时关闭它
+func startServer(t *testing.T, handler Handler, opts ...Option) (*Server, string, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen Error: %s", err)
+	}
+	server := NewServer(listener.Addr().String(), testFramer, handler, opts...)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return server, listener.Addr().String(), serveErr
+}
+
+type testClient struct {
+	*Conn
+	nextID uint64
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := Dial(addr, testFramer, time.Second)
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{Conn: conn}
+}
+
+// 发送一个请求并返回它的ID
+func (c *testClient) send(t *testing.T, req string) uint64 {
+	c.nextID++
+	frame, _ := BinaryCodec().AppendMessage(nil, &Message{ID: c.nextID, Body: []byte(req)})
+	if err := c.WriteFrame(frame); err != nil {
+		t.Fatalf("Write Error: %s", err)
+	}
+	return c.nextID
+}
+
+// 在timeout之内读取一个响应
+func (c *testClient) recv(timeout time.Duration) (*Message, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	resp := new(Message)
+	if err := BinaryCodec().DecodeMessage(frame, resp); err != nil {
+		return nil, err
+	}
+	resp.Body = append([]byte(nil), resp.Body...)
+	return resp, nil
+}
+
+func (c *testClient) call(t *testing.T, req string) *Message {
+	id := c.send(t, req)
+	resp, err := c.recv(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Read Error: %s", err)
+	}
+	if resp.ID != id {
+		t.Fatalf("ERROR: The response ID is %d, expected %d", resp.ID, id)
+	}
+	return resp
+}
+
+var upperHandler = HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+	if len(req) == 0 {
+		return nil, errors.New("empty request")
+	}
+	return bytes.ToUpper(req), nil
+})
+
+func TestServe(t *testing.T) {
+	_, addr, _ := startServer(t, upperHandler)
+	c := dial(t, addr)
+	for _, req := range []string{"hello", "world", "tcp"} {
+		if resp := c.call(t, req); resp.Status != StatusOK || string(resp.Body) != strings.ToUpper(req) {
+			t.Fatalf("ERROR: The response of %q is %q (%s)!", req, resp.Body, resp.Status)
+		}
+	}
+	if resp := c.call(t, ""); resp.Status != StatusError || string(resp.Body) != "empty request" {
+		t.Fatalf("ERROR: The error response is %q (%s)!", resp.Body, resp.Status)
+	}
+}
+
+/**
+优雅关闭：正在处理的请求完成并写回响应，空闲的连接被关闭，监听器不再接受新的连接。
+*/
+func TestShutdownDrains(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server, addr, serveErr := startServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		close(started)
+		<-release
+		return req, nil
+	}))
+	busy := dial(t, addr)
+	idle := dial(t, addr)
+	busy.send(t, "in-flight")
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("ERROR: Serve returned %v, expected %v", err, ErrServerClosed)
+	}
+	// 空闲的连接会被服务端关闭
+	if _, err := idle.recv(2 * time.Second); err != io.EOF {
+		t.Fatalf("ERROR: The idle connection returned %v, expected EOF", err)
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("ERROR: Shutdown returned %v before the in-flight request is finished!", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if resp, err := busy.recv(2 * time.Second); err != nil || string(resp.Body) != "in-flight" {
+		t.Fatalf("ERROR: The in-flight request got (%v, %v)", resp, err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("ERROR: Shutdown error: %s", err)
+	}
+	if conn, err := net.DialTimeout("tcp", addr, 200*time.Millisecond); err == nil {
+		conn.Close()
+		t.Fatal("ERROR: The server still accepts connections after Shutdown!")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	server, addr, _ := startServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}))
+	c := dial(t, addr)
+	c.send(t, "never")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: Shutdown returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("ERROR: The handler context is not canceled after a forced shutdown!")
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	_, addr, _ := startServer(t, upperHandler, WithMaxConns(1))
+	first := dial(t, addr)
+	if resp := first.call(t, "first"); string(resp.Body) != "FIRST" {
+		t.Fatalf("ERROR: The response is %q!", resp.Body)
+	}
+	// 第二个连接可以完成握手（它在监听队列中），但在第一个连接被关闭之前不会被处理
+	second := dial(t, addr)
+	second.send(t, "second")
+	if resp, err := second.recv(100 * time.Millisecond); err == nil {
+		t.Fatalf("ERROR: The second connection is served (%q) beyond the connection limit!", resp.Body)
+	}
+	first.Close()
+	if resp, err := second.recv(2 * time.Second); err != nil || string(resp.Body) != "SECOND" {
+		t.Fatalf("ERROR: The second connection got (%v, %v)", resp, err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	_, addr, _ := startServer(t, upperHandler, WithReadTimeout(50*time.Millisecond))
+	c := dial(t, addr)
+	if resp := c.call(t, "ok"); string(resp.Body) != "OK" {
+		t.Fatalf("ERROR: The response is %q!", resp.Body)
+	}
+	// 空闲超过读超时时间的连接会被服务端关闭
+	if _, err := c.recv(2 * time.Second); err != io.EOF {
+		t.Fatalf("ERROR: The idle connection returned %v, expected EOF", err)
+	}
+}
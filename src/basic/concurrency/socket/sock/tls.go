@@ -0,0 +1,164 @@
+package sock
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+/**
+TLS的证书加载与热更新。
+证书和私钥从PEM文件中加载，CertReloader持有当前的证书，并通过tls.Config的GetCertificate和GetClientCertificate回调提供给TLS握手，
+所以重新加载证书之后，新的连接会使用新的证书，已经建立的连接不受影响。通常在收到SIGHUP信号时重新加载，这样更换证书时不需要重启进程。
+*/
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// 从文件中加载证书和私钥
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 重新从文件中加载证书和私钥，加载失败时继续使用原来的证书
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// 当前的证书
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// 用作服务端的tls.Config的GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// 用作客户端的tls.Config的GetClientCertificate
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+/**
+在收到给定的信号时重新加载证书，没有给定信号时使用SIGHUP。加载失败的错误会被记录到logger中（logger可以为nil）。
+返回的函数用来停止监听信号。
+*/
+func (r *CertReloader) WatchSignals(logger Logger, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	sigRecv := make(chan os.Signal, 1)
+	signal.Notify(sigRecv, sigs...)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case sig := <-sigRecv:
+				err := r.Reload()
+				if logger == nil {
+					continue
+				}
+				if err != nil {
+					logger.Printf("Reload Error: %s (signal: %s, certificate: %s)", err, sig, r.certFile)
+				} else {
+					logger.Printf("Reloaded the certificate. (signal: %s, certificate: %s)", sig, r.certFile)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigRecv)
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// 从PEM文件中加载CA证书
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("sock: no certificate is found in %s", caFile)
+	}
+	return pool, nil
+}
+
+/**
+创建服务端的TLS配置，证书通过返回的CertReloader提供，可以被热更新。
+clientCAFile不为空时启用mTLS：客户端必须提供由其中的CA签发的证书。
+*/
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, *CertReloader, error) {
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAFile != "" {
+		pool, err := LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, reloader, nil
+}
+
+/**
+创建客户端的TLS配置。caFile为空时使用系统的CA证书验证服务端。
+certFile和keyFile不为空时在mTLS中提供客户端证书，证书通过返回的CertReloader提供，否则返回的CertReloader为nil。
+*/
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, *CertReloader, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile == "" && keyFile == "" {
+		return config, nil, nil
+	}
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.GetClientCertificate = reloader.GetClientCertificate
+	return config, reloader, nil
+}
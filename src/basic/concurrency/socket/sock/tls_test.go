@@ -0,0 +1,228 @@
+package sock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/**
+测试使用的证书都在进程内生成，所以测试不需要网络和外部的文件。
+*/
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sock test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, ca.path("ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// 签发一个证书，把证书和私钥写入name.pem和name.key
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, ca.path(name+".pem"), "CERTIFICATE", der)
+	writePEM(t, ca.path(name+".key"), "EC PRIVATE KEY", keyDer)
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	_, addr, _ := startServer(t, upperHandler, WithTLSConfig(config))
+	return addr
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", 10, x509.ExtKeyUsageServerAuth)
+	serverConfig, _, err := ServerTLSConfig(ca.path("server.pem"), ca.path("server.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, serverConfig)
+
+	clientConfig, _, err := ClientTLSConfig(ca.path("ca.pem"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialClient(addr, testFramer, time.Second, WithClientTLSConfig(clientConfig), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	if resp, err := client.Call(context.Background(), []byte("secure")); err != nil || string(resp) != "SECURE" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+
+	// 不信任这个CA的客户端无法完成握手
+	if _, err := DialTLS(addr, testFramer, time.Second, &tls.Config{}); err == nil {
+		t.Fatal("ERROR: The server certificate is accepted without the CA!")
+	}
+	// 明文的客户端得不到响应
+	plain := dial(t, addr)
+	plain.send(t, "plain")
+	if resp, err := plain.recv(time.Second); err == nil {
+		t.Fatalf("ERROR: A plain client got a response %q!", resp.Body)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", 10, x509.ExtKeyUsageServerAuth)
+	ca.issue(t, "client", 20, x509.ExtKeyUsageClientAuth)
+	serverConfig, _, err := ServerTLSConfig(ca.path("server.pem"), ca.path("server.key"), ca.path("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSServer(t, serverConfig)
+
+	clientConfig, _, err := ClientTLSConfig(ca.path("ca.pem"), ca.path("client.pem"), ca.path("client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(addr, testFramer, WithClientOptions(WithClientTLSConfig(clientConfig), WithCallTimeout(2*time.Second)))
+	defer pool.Close()
+	if resp, err := pool.Call(context.Background(), []byte("mutual")); err != nil || string(resp) != "MUTUAL" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+
+	// 没有客户端证书的连接会被服务端拒绝。TLS 1.3中客户端在握手之后才会得知被拒绝，所以要通过调用来检查
+	noCertConfig, _, err := ClientTLSConfig(ca.path("ca.pem"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := DialClient(addr, testFramer, time.Second, WithClientTLSConfig(noCertConfig), WithCallTimeout(time.Second))
+	if err == nil {
+		defer client.Close()
+		_, err = client.Call(context.Background(), []byte("anonymous"))
+	}
+	if err == nil {
+		t.Fatal("ERROR: A client without certificate is accepted!")
+	}
+}
+
+// 收到SIGHUP之后重新加载证书，新的连接使用新的证书
+func TestCertReload(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", 10, x509.ExtKeyUsageServerAuth)
+	serverConfig, reloader, err := ServerTLSConfig(ca.path("server.pem"), ca.path("server.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan string, 1)
+	stop := reloader.WatchSignals(loggerFunc(func(format string, args ...interface{}) {
+		reloaded <- format
+	}))
+	defer stop()
+	addr := startTLSServer(t, serverConfig)
+	clientConfig, _, err := ClientTLSConfig(ca.path("ca.pem"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() int64 {
+		conn, err := DialTLS(addr, testFramer, time.Second, clientConfig)
+		if err != nil {
+			t.Fatalf("Dial Error: %s", err)
+		}
+		defer conn.Close()
+		return conn.Conn.(*tls.Conn).ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if s := serial(); s != 10 {
+		t.Fatalf("ERROR: The serial number is %d, expected 10", s)
+	}
+
+	ca.issue(t, "server", 11, x509.ExtKeyUsageServerAuth)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ERROR: The certificate is not reloaded after SIGHUP!")
+	}
+	if s := serial(); s != 11 {
+		t.Fatalf("ERROR: The serial number is %d, expected 11", s)
+	}
+
+	// 加载失败时继续使用原来的证书
+	if err := os.WriteFile(ca.path("server.key"), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Fatal("ERROR: A broken key is loaded!")
+	}
+	if s := serial(); s != 11 {
+		t.Fatalf("ERROR: The serial number is %d, expected 11", s)
+	}
+}
+
+type loggerFunc func(format string, args ...interface{})
+
+func (f loggerFunc) Printf(format string, args ...interface{}) {
+	f(format, args...)
+}
@@ -0,0 +1,141 @@
+package sock
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+/**
+服务端和客户端支持的网络：
+"tcp"、"tcp4"、"tcp6"：字节流，使用给定的分帧策略
+"unix"：Unix域的字节流，使用给定的分帧策略
+"unixpacket"：Unix域的有序数据报（SOCK_SEQPACKET），面向连接，但是保留消息边界
+"udp"、"udp4"、"udp6"：无连接的数据报
+对于后两种面向数据报的网络，每个数据报就是一个帧，给定的分帧策略会被忽略而改为使用PacketFramer，一个数据报中只能有一个请求或响应。
+UDP不保证送达，丢失的请求或响应会导致调用超时，所以通过UDP调用时应该设置调用的超时时间。
+*/
+
+// 默认的数据报的最大长度，也就是IPv4上一个UDP数据报所能携带的最大数据量
+const DefaultMaxPacketSize = 65507
+
+// 是否是以数据报为单位收发的网络
+func isPacketNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "unixpacket":
+		return true
+	}
+	return false
+}
+
+// 是否是无连接的网络，这样的网络要通过net.ListenPacket监听
+func isDatagramNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6":
+		return true
+	}
+	return false
+}
+
+/**
+以数据报作为消息边界的分帧策略：每次写入的帧是一个数据报，每次读取一个完整的数据报。
+读取时使用maxPacketSize+1字节的缓冲区，所以超过最大长度的数据报可以被发现而不会被静默地截断。maxPacketSize不大于0时使用DefaultMaxPacketSize
+*/
+func PacketFramer(maxPacketSize int) Framer {
+	if maxPacketSize <= 0 {
+		maxPacketSize = DefaultMaxPacketSize
+	}
+	return packetFramer{max: maxPacketSize}
+}
+
+type packetFramer struct {
+	max int
+}
+
+func (f packetFramer) NewFrameReader(r io.Reader) FrameReader {
+	return &packetReader{r: r, buf: make([]byte, f.max+1)}
+}
+
+func (f packetFramer) NewFrameWriter(w io.Writer) FrameWriter {
+	return &packetWriter{w: w, max: f.max}
+}
+
+type packetReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (fr *packetReader) ReadFrame() ([]byte, error) {
+	n, err := fr.r.Read(fr.buf)
+	if err != nil {
+		return nil, err
+	}
+	if n == len(fr.buf) {
+		return nil, ErrFrameTooLarge
+	}
+	return fr.buf[:n], nil
+}
+
+type packetWriter struct {
+	w   io.Writer
+	max int
+}
+
+func (fw *packetWriter) WriteFrame(p []byte) error {
+	if len(p) > fw.max {
+		return ErrFrameTooLarge
+	}
+	_, err := fw.w.Write(p)
+	return err
+}
+
+// 面向数据报的网络要使用PacketFramer，已经是PacketFramer时保留它的最大长度
+func framerFor(network string, framer Framer, maxPacketSize int) Framer {
+	if !isPacketNetwork(network) {
+		return framer
+	}
+	if _, ok := framer.(packetFramer); ok {
+		return framer
+	}
+	return PacketFramer(maxPacketSize)
+}
+
+// 连接到给定网络上的服务端。对于面向数据报的网络，framer会被替换为PacketFramer
+func DialNetwork(network, addr string, framer Framer, timeout time.Duration) (*Conn, error) {
+	return dialNetwork(network, addr, framer, timeout, nil)
+}
+
+func dialNetwork(network, addr string, framer Framer, timeout time.Duration, config *tls.Config) (*Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if config != nil {
+		conn, err = tls.DialWithDialer(dialer, network, addr, config)
+	} else {
+		conn, err = dialer.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, framerFor(network, framer, DefaultMaxPacketSize)), nil
+}
+
+/**
+删除Unix域套接字的地址上遗留的套接字文件。
+进程正常退出时监听器会删除套接字文件，但是进程崩溃之后文件会被遗留下来，使得重新监听失败。
+只有当这个文件是套接字并且已经没有进程在上面监听时才删除它，以免误删其它的文件或者抢占正在运行的服务端的地址。
+*/
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	return os.Remove(path)
+}
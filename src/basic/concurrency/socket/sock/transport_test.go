@@ -0,0 +1,254 @@
+package sock
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 在给定的网络和地址上通过ListenAndServe启动服务端
+func listenAndServe(t *testing.T, network, addr string, handler Handler, opts ...Option) (*Server, <-chan error) {
+	opts = append([]Option{WithNetwork(network)}, opts...)
+	server := NewServer(addr, testFramer, handler, opts...)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return server, serveErr
+}
+
+// 等待服务端开始监听
+func waitListening(t *testing.T, network, addr string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ERROR: The server is not listening on %s %s: %s", network, addr, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func socketPath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "sock.sock")
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := socketPath(t)
+	// 进程崩溃时遗留下来的套接字文件
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("ERROR: The stale socket file is not left: %s", err)
+	}
+
+	server, serveErr := listenAndServe(t, "unix", path, upperHandler)
+	waitListening(t, "unix", path)
+	client, err := DialClient(path, testFramer, time.Second, WithClientNetwork("unix"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	if resp, err := client.Call(context.Background(), []byte("unix")); err != nil || string(resp) != "UNIX" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+
+	// 正在使用的套接字文件不会被另一个服务端删除
+	other := NewServer(path, testFramer, upperHandler, WithNetwork("unix"))
+	if err := other.ListenAndServe(); err == nil || err == ErrServerClosed {
+		t.Fatalf("ERROR: The second server returned %v, expected an address-in-use error", err)
+	}
+	if resp, err := client.Call(context.Background(), []byte("still")); err != nil || string(resp) != "STILL" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+
+	// 服务端关闭时删除套接字文件
+	server.Shutdown(context.Background())
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("ERROR: Serve returned %v, expected %v", err, ErrServerClosed)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("ERROR: The socket file is not removed after Shutdown: %v", err)
+	}
+}
+
+// 不会删除同名的普通文件
+func TestUnixSocketNotSocket(t *testing.T) {
+	path := socketPath(t)
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(path, testFramer, upperHandler, WithNetwork("unix"))
+	if err := server.ListenAndServe(); err == nil || err == ErrServerClosed {
+		t.Fatalf("ERROR: ListenAndServe returned %v, expected an address-in-use error", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Fatalf("ERROR: The regular file is changed: (%q, %v)", data, err)
+	}
+}
+
+// unixpacket保留消息边界，比帧读取器的缓冲区更大的消息也不会被截断
+func TestUnixPacket(t *testing.T) {
+	path := socketPath(t)
+	_, _ = listenAndServe(t, "unixpacket", path, upperHandler)
+	waitListening(t, "unixpacket", path)
+	client, err := DialClient(path, testFramer, time.Second, WithClientNetwork("unixpacket"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	large := strings.Repeat("p", 10000)
+	for _, req := range []string{"packet", large, "after"} {
+		if resp, err := client.Call(context.Background(), []byte(req)); err != nil || string(resp) != strings.ToUpper(req) {
+			t.Fatalf("ERROR: The response of a %d-byte request is (%d bytes, %v)", len(req), len(resp), err)
+		}
+	}
+}
+
+func startUDPServer(t *testing.T, handler Handler, opts ...Option) (*Server, string, <-chan error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithNetwork("udp")}, opts...)
+	server := NewServer(conn.LocalAddr().String(), testFramer, handler, opts...)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ServePacket(conn)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return server, conn.LocalAddr().String(), serveErr
+}
+
+func TestUDP(t *testing.T) {
+	_, addr, _ := startUDPServer(t, sleepHandler, WithMaxPacketSize(1024))
+	client, err := DialClient(addr, testFramer, time.Second, WithClientNetwork("udp"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	// 每个数据报是一个请求，响应以任意的顺序返回
+	results := make(chan string, 3)
+	for _, req := range []string{"60", "30", "0"} {
+		go func(req string) {
+			resp, err := client.Call(context.Background(), []byte(req))
+			if err != nil {
+				t.Errorf("ERROR: The call of %s failed: %s", req, err)
+			}
+			results <- string(resp)
+		}(req)
+	}
+	var order []string
+	for i := 0; i < 3; i++ {
+		order = append(order, <-results)
+	}
+	if strings.Join(order, ",") != "0,30,60" {
+		t.Fatalf("ERROR: The responses arrived in the order %v", order)
+	}
+	if _, err := client.Call(context.Background(), []byte("abc")); err == nil {
+		t.Fatal("ERROR: The call of an invalid request succeeded!")
+	}
+}
+
+// 超过最大长度的数据报被服务端丢弃，调用会超时，但不影响后续的请求
+func TestUDPMaxPacketSize(t *testing.T) {
+	_, addr, _ := startUDPServer(t, upperHandler, WithMaxPacketSize(64))
+	conn, err := DialNetwork("udp", addr, testFramer, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn, WithCallTimeout(100*time.Millisecond))
+	defer client.Close()
+	if _, err := client.Call(context.Background(), bytes.Repeat([]byte("x"), 100)); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: The call of a large request returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if resp, err := client.Call(context.Background(), []byte("small")); err != nil || string(resp) != "SMALL" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+	// 客户端自己的数据报长度限制
+	if _, err := client.Call(context.Background(), bytes.Repeat([]byte("x"), DefaultMaxPacketSize)); err != ErrFrameTooLarge {
+		t.Fatalf("ERROR: The call of a huge request returned %v, expected %v", err, ErrFrameTooLarge)
+	}
+}
+
+// 优雅关闭时，已经收到的UDP请求的响应仍然会被发送
+func TestUDPShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server, addr, serveErr := startUDPServer(t, HandlerFunc(func(ctx context.Context, req []byte) ([]byte, error) {
+		close(started)
+		<-release
+		return req, nil
+	}))
+	client, err := DialClient(addr, testFramer, time.Second, WithClientNetwork("udp"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), []byte("in-flight"))
+		result <- err
+	}()
+	<-started
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("ERROR: Shutdown returned %v before the in-flight request is finished!", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("ERROR: The in-flight call failed: %s", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("ERROR: Shutdown error: %s", err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("ERROR: ServePacket returned %v, expected %v", err, ErrServerClosed)
+	}
+}
+
+func TestTCP6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	_, _ = listenAndServe(t, "tcp6", addr, upperHandler)
+	waitListening(t, "tcp6", addr)
+	client, err := DialClient(addr, testFramer, time.Second, WithClientNetwork("tcp6"), WithCallTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Dial Error: %s", err)
+	}
+	defer client.Close()
+	if resp, err := client.Call(context.Background(), []byte("v6")); err != nil || string(resp) != "V6" {
+		t.Fatalf("ERROR: The response is (%q, %v)", resp, err)
+	}
+}
@@ -1,231 +1,231 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"basic/concurrency/socket/sock"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"math"
 	"math/rand"
-	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	SERVER_NETWORK = "tcp"
 	SERVER_ADDRESS = "127.0.0.1:8085"
-	DELIMITER      = '\t'
 )
 
-// 代表每个日志记录的序号
-var logSn = 1
+// 服务端和客户端使用的分帧策略。最初的协议是"内容+'\t'"，一旦内容中包含了'\t'就会出错，所以改为使用长度前缀
+var framer = sock.LengthFramer(sock.DefaultMaxFrameSize)
+
+// 代表每个日志记录的序号，服务端和客户端的多个Goroutine会同时记录日志，所以要通过原子操作来递增
+var logSn int64
 
 var wg sync.WaitGroup
 
+// 所有客户端共享的连接池，建立连接失败时会按照指数退避的时间间隔重试
+var pool *sock.Pool
+
+// 通过-network和-address选择传输方式，比如：go run tcpsock.go -network unix -address /tmp/tcpsock.sock。
+// 给定了证书和私钥时服务端使用TLS，再给定CA证书时使用mTLS，客户端使用同一个证书。
+// 比如：go run tcpsock.go -cert server.pem -key server.key -ca ca.pem，运行时发送SIGHUP可以重新加载证书
+var (
+	network  = flag.String("network", SERVER_NETWORK, "the network: tcp, tcp6, unix, unixpacket or udp")
+	address  = flag.String("address", SERVER_ADDRESS, "the server address, or the socket file path for unix and unixpacket")
+	certFile = flag.String("cert", "", "the PEM encoded certificate file")
+	keyFile  = flag.String("key", "", "the PEM encoded private key file")
+	caFile   = flag.String("ca", "", "the PEM encoded CA certificate file")
+	// 给定了令牌时服务端只接受携带这个令牌的命令
+	token = flag.String("token", "", "the auth token required by the server and sent by the clients")
+	// 压测服务端（参见loadgen）时可以用-rate 0取消限流
+	rateLimit = flag.Float64("rate", 100, "the maximum commands per second accepted by the server, 0 means unlimited")
+	// 给定了地址时在这个地址上提供HTTP服务：/metrics是Prometheus格式的指标，/debug/vars是expvar格式的指标
+	metricsAddr = flag.String("metrics", "", "the HTTP address serving /metrics and /debug/vars, e.g. 127.0.0.1:9090")
+)
+
 func main() {
-	wg.Add(2)
-	go serverGo()
+	flag.Parse()
+	serverOpts := []sock.Option{
+		sock.WithNetwork(*network),
+		sock.WithReadTimeout(10 * time.Second),
+		sock.WithWriteTimeout(5 * time.Second),
+		sock.WithLogger(logFunc(printLog)),
+	}
+	clientOpts := []sock.ClientOption{sock.WithClientNetwork(*network), sock.WithCallTimeout(5 * time.Second)}
+	if *certFile != "" {
+		serverConfig, reloader, err := sock.ServerTLSConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			printLog("TLS Error: %s\n", err)
+			return
+		}
+		defer reloader.WatchSignals(logFunc(printLog))()
+		// 客户端使用与服务端相同的证书，所以服务端的证书也要能用于客户端认证
+		clientConfig, _, err := sock.ClientTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			printLog("TLS Error: %s\n", err)
+			return
+		}
+		serverOpts = append(serverOpts, sock.WithTLSConfig(serverConfig))
+		clientOpts = append(clientOpts, sock.WithClientTLSConfig(clientConfig))
+	}
+	pool = sock.NewPool(*address, framer, sock.WithMaxOpen(4), sock.WithClientOptions(clientOpts...))
+	defer pool.Close()
+
+	wg.Add(3)
+	go serverGo(serverOpts...)
 	time.Sleep(500 * time.Millisecond)
 	go clientGo(1)
+	go clientGo(2)
 	wg.Wait()
 }
 
-func serverGo() {
+/**
+服务端的监听、接受连接、读取请求和写回响应都由sock包完成，这里只需要提供分帧策略和处理请求的函数。
+处理请求的是一个路由器，一个服务端可以按照命令的名字提供多种操作
+*/
+func serverGo(opts ...sock.Option) {
 	defer wg.Done()
-	var listener net.Listener
-	listener, err := net.Listen(SERVER_NETWORK, SERVER_ADDRESS)
-	if err != nil {
-		printLog("Listen Error: %s\n", err)
+	server := sock.NewServer(*address, framer, newRouter(), opts...)
+	if *metricsAddr != "" {
+		server.PublishExpvar("sock")
+		http.Handle("/metrics", server.MetricsHandler())
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				printLog("Metrics Error: %s\n", err)
+			}
+		}()
 	}
-	defer listener.Close()
-	printLog("Got listener for the server. (local address: %s)\n", listener.Addr())
-	for {
-		// 阻塞直到新连接到来
-		conn, err := listener.Accept()
-		if err != nil {
-			printLog("Accept Error: %s\n", err)
-		}
-		printLog("Established a connection with a client application. (remote address: %s)\n", conn.RemoteAddr())
-		go handleConn(conn)
+	if err := server.ListenAndServe(); err != nil {
+		printLog("Serve Error: %s\n", err)
 	}
 }
 
+/**
+客户端并发地发送多个请求，每个响应都通过请求ID与它的请求对应起来，所以不再需要假设响应的顺序与请求的顺序相同。
+连接从连接池中取出，计算立方根的请求是幂等的，所以在连接出错时可以被安全地重试
+*/
 func clientGo(id int) {
 	defer wg.Done()
-	conn, err := net.DialTimeout(SERVER_NETWORK, SERVER_ADDRESS, 2*time.Second)
-	if err != nil {
-		printLog("Dial Error: %s (Client[%d])\n", err, id)
-		return
-	}
-	defer conn.Close()
-	printLog("Connected to server. (remote address: %s, local address: %s) (Client[%d])\n",
-		conn.RemoteAddr(), conn.LocalAddr(), id)
 	time.Sleep(200 * time.Millisecond)
-	requestNumber := 5
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	requestNumber := 6
+	var callWg sync.WaitGroup
 	for i := 0; i < requestNumber; i++ {
-		i32Req := rand.Int31()
-		n, err := write(conn, fmt.Sprintf("%d", i32Req))
-		if err != nil {
-			printLog("Write Error: %s (Client[%d])\n", err, id)
-			continue
+		cmd := &sock.Command{Name: "cbrt", Args: []string{fmt.Sprintf("%d", rand.Int31())}, Token: *token}
+		// 最后三个请求会得到不同种类的错误响应
+		switch i {
+		case requestNumber - 3:
+			cmd.Args = []string{"4294967296"}
+		case requestNumber - 2:
+			cmd.Args = []string{"not a number"}
+		case requestNumber - 1:
+			cmd.Name = "cube"
 		}
-		printLog("Sent request (written %d bytes): %d (Client[%d])\n", n, i32Req, id)
-	}
-	for j := 0; j < requestNumber; j++ {
-		strResp, err := read(conn)
-		if err != nil {
-			if err == io.EOF {
-				printLog("The connection is closed by another side. (Client[%d])\n", id)
-			} else {
-				printLog("Read Error: %s (Client[%d])\n", err, id)
+		callWg.Add(1)
+		go func(cmd *sock.Command) {
+			defer callWg.Done()
+			req := fmt.Sprintf("%s%q", cmd.Name, cmd.Args)
+			printLog("Sent request: %s (Client[%d])\n", req, id)
+			resp, err := pool.CallIdempotent(context.Background(), sock.EncodeCommand(cmd))
+			if err != nil {
+				var respErr *sock.Error
+				if errors.As(err, &respErr) {
+					printLog("Received error response to %s: [%s] %s (Client[%d])\n", req, respErr.Kind, respErr.Message, id)
+				} else {
+					printLog("Call Error: %s (Client[%d])\n", err, id)
+				}
+				return
 			}
-			break
-		}
-		printLog("Received response: %s (Client[%d])\n", strResp, id)
+			printLog("Received response to %s: %s (Client[%d])\n", req, resp, id)
+		}(cmd)
 	}
+	callWg.Wait()
 }
 
-/*func handleConn(conn net.Conn) {
-	defer func() {
-		conn.Close()
-	}()
-	for {
-		// 设置读取超时时间
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-		strReq, err := read(conn)
-		if err != nil {
-			if err == io.EOF {
-				printLog("The connection is closed by another side. (Server)\n")
-			} else {
-				printLog("Read Error: %s (Server)\n", err)
-			}
-			break
-		}
-		printLog("Received request: %s (Server)\n", strReq)
-		i32Req, err := convertToInt32(strReq)
-		if err != nil {
-			// 向客户端输出错误响应内容
-			n, err := write(conn, err.Error())
-			if err != nil {
-				printLog("Write Error (written %d bytes): %s (Server)\n", err)
-			}
-			printLog("Sent response (written %d bytes): %s (Server)\n", n, err)
-			continue
-		}
-		f64Resp := cbrt(i32Req)
-		respMsg := fmt.Sprintf("The cube root of %d is %f.", i32Req, f64Resp)
-		n, err := write(conn, respMsg)
-		if err != nil {
-			printLog("Write Error: %s (Server)\n", err)
-		}
-		printLog("Sent response (written %d bytes): %s (Server)\n", n, respMsg)
+/**
+最初的服务端只能计算立方根，现在每种操作都是路由器中的一个命令。
+中间件按照添加的顺序从外到内包装所有的命令：Recovery捕获命令中的panic，Logging记录每个命令的耗时，TokenAuth拒绝没有合法令牌的请求，
+RateLimit限制命令的总速率。TokenAuth必须在RateLimit的外层，否则没有令牌的请求也会消耗共享的令牌桶，攻击者不需要令牌就能让合法的客户端被限流
+*/
+func newRouter() *sock.Router {
+	router := sock.NewRouter()
+	router.RegisterFunc("cbrt", handleCbrt)
+	router.RegisterFunc("sqrt", handleSqrt)
+	router.RegisterFunc("echo", func(ctx context.Context, cmd *sock.Command) ([]byte, error) {
+		return []byte(strings.Join(cmd.Args, " ")), nil
+	})
+	router.Use(sock.Recovery(logFunc(printLog)), sock.Logging(logFunc(printLog)))
+	if *token != "" {
+		router.Use(sock.TokenAuth(*token))
 	}
-}*/
-
-func handleConn(conn net.Conn) {
-	defer func() {
-		conn.Close()
-	}()
-	// 得到一个针对当前连接的缓冲读取器
-	reader := bufio.NewReader(conn)
-	for {
-		// 设置读取超时时间
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-		readBytes, err := reader.ReadBytes(DELIMITER)
-		if err != nil {
-			if err == io.EOF {
-				printLog("The connection is closed by another side. (Server)\n")
-			} else {
-				printLog("Read Error: %s (Server)\n", err)
-			}
-			break
-		}
-		strReq := string(readBytes[:len(readBytes)-1])
-		printLog("Received request: %s (Server)\n", strReq)
-		i32Req, err := convertToInt32(strReq)
-		if err != nil {
-			// 向客户端输出错误响应内容
-			n, err := write(conn, err.Error())
-			if err != nil {
-				printLog("Write Error (written %d bytes): %s (Server)\n", err)
-			}
-			printLog("Sent response (written %d bytes): %s (Server)\n", n, err)
-			continue
-		}
-		f64Resp := cbrt(i32Req)
-		respMsg := fmt.Sprintf("The cube root of %d is %f.", i32Req, f64Resp)
-		n, err := write(conn, respMsg)
-		if err != nil {
-			printLog("Write Error: %s (Server)\n", err)
-		}
-		printLog("Sent response (written %d bytes): %s (Server)\n", n, respMsg)
+	if *rateLimit > 0 {
+		router.Use(sock.RateLimit(*rateLimit, 20))
 	}
+	return router
 }
 
-func cbrt(param int32) float64 {
-	return math.Cbrt(float64(param))
+func handleCbrt(ctx context.Context, cmd *sock.Command) ([]byte, error) {
+	i32Req, err := commandInt32(cmd)
+	if err != nil {
+		// 错误会被作为错误响应返回给客户端，它的种类告诉客户端是哪一种错误
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("The cube root of %d is %f.", i32Req, cbrt(i32Req))), nil
 }
 
-func write(conn net.Conn, content string) (int, error) {
-	var buffer bytes.Buffer
-	buffer.WriteString(content)
-	buffer.WriteByte(DELIMITER)
-	return conn.Write(buffer.Bytes())
+func handleSqrt(ctx context.Context, cmd *sock.Command) ([]byte, error) {
+	i32Req, err := commandInt32(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if i32Req < 0 {
+		return nil, sock.Errorf(sock.KindOutOfRange, "'%d' is negative!", i32Req)
+	}
+	return []byte(fmt.Sprintf("The square root of %d is %f.", i32Req, math.Sqrt(float64(i32Req)))), nil
+}
+
+// 命令只有一个参数，它是一个32位整数
+func commandInt32(cmd *sock.Command) (int32, error) {
+	if len(cmd.Args) != 1 {
+		return 0, sock.Errorf(sock.KindParse, "%s takes 1 argument, but got %d!", cmd.Name, len(cmd.Args))
+	}
+	return convertToInt32(cmd.Args[0])
+}
+
+func cbrt(param int32) float64 {
+	return math.Cbrt(float64(param))
 }
 
 func convertToInt32(str string) (int32, error) {
 	num, err := strconv.Atoi(str)
 	if err != nil {
-		printLog(fmt.Sprintf("Parse Error: %s\n", err))
-		return 0, errors.New(fmt.Sprintf("'%s' is not integer!", str))
+		printLog("Parse Error: %s\n", err)
+		// 超出int范围的整数也不是32位整数
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, sock.Errorf(sock.KindOutOfRange, "'%s' is not 32-bit integer!", str)
+		}
+		return 0, sock.Errorf(sock.KindParse, "'%s' is not integer!", str)
 	}
 	if num > math.MaxInt32 || num < math.MinInt32 {
-		printLog(fmt.Sprintf("Convert Error: The integer %s is too large/small.\n", num))
-		return 0, errors.New(fmt.Sprintf("'%s' is not 32-bit integer!", num))
+		printLog("Convert Error: The integer %d is too large/small.\n", num)
+		return 0, sock.Errorf(sock.KindOutOfRange, "'%d' is not 32-bit integer!", num)
 	}
 	return int32(num), nil
 }
 
-func read(conn net.Conn) (string, error) {
-	// 根据我们的需求可以一个一个字节的读
-	readBytes := make([]byte, 1)
-	var buffer bytes.Buffer
-	for {
-		_, err := conn.Read(readBytes)
-		if err != nil {
-			return "", err
-		}
-		readByte := readBytes[0]
-		// 遇到消息边界就退出循环
-		if readByte == DELIMITER {
-			break
-		}
-		buffer.WriteByte(readByte)
-	}
-	return buffer.String(), nil
+func printLog(format string, args ...interface{}) {
+	sn := atomic.AddInt64(&logSn, 1)
+	fmt.Printf("%d: %s", sn, fmt.Sprintf(format, args...))
 }
 
-/**
-千万不要使用这个read函数，它是有问题的
-这里隐藏了一个陷阱，这与缓冲读取器的缓存机制有关。在很多时候，它会读取比足够多更多一点的数据到其中缓冲区，如果在循环里面调用这个read函数，那么我们对
-read函数的每一次调用都会导致一个新的针对当前连接的缓冲读取器被创建出来，这就会造成本应该留给后面缓冲读取器的数据被前面的缓冲器读到了缓冲区中，由于每次
-循环我们值读取了一部分数据出来， 这就导致每次循环都会丢失了一些数据未被处理，最终数据就是不完整的。
-*/
-/*func read(conn net.Conn) (string, error) {
-	// 创建缓冲器的时候可能已经读取了一些数据到缓冲区中了
-	reader := bufio.NewReader(conn)
-	readBytes, err := reader.ReadBytes(DELIMITER)
-	if err != nil {
-		return "", nil
-	}
-	return string(readBytes[:len(readBytes)-1]), nil
-}*/
+// 把printLog适配为sock.Logger，sock包记录的日志不以换行结尾
+type logFunc func(format string, args ...interface{})
 
-func printLog(format string, args ...interface{}) {
-	fmt.Printf("%d: %s", logSn, fmt.Sprintf(format, args...))
-	logSn++
+func (f logFunc) Printf(format string, args ...interface{}) {
+	f(format+"\n", args...)
 }
@@ -0,0 +1,225 @@
+package blockcodec
+
+import (
+	"basic/sync/datafile3"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+/**
+数据块的编解码与校验层。
+它包装了datafile1~4中的任意一种数据文件（NewDataFile默认使用datafile3），在写入之前对数据进行编码（压缩），并在每个数据块的头部记录校验和；读取的时候先校验再解码。
+这样，在写入被中断（比如进程崩溃）而留下不完整的数据块时，读操作会返回带有序列号的ErrCorruptBlock错误，而不是把错误的数据交给调用方。
+数据块的格式如下（多字节整数均为大端序）：
+[0:4]  CRC-32C校验和，覆盖从第4个字节开始的头部和编码后的数据
+[4]    编解码器的标识
+[5:9]  编码后的数据的长度
+[9:]   编码后的数据，不足的部分以0填充
+由于数据块的长度是固定的，压缩带来的好处体现在可以使用更小的数据块长度上。
+解码之后的数据不能超过MaxDecodedLen，以免一个很小的损坏或者恶意的数据块被解压成巨大的数据而耗尽内存。
+*/
+
+// 数据块头部的长度
+const headerLen = 9
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// 解码之后的数据的最大长度，写入更长的数据时返回ErrBlockTooLarge
+const MaxDecodedLen = 1 << 20
+
+// 编码后的数据超出了数据块的容量，或者数据超过了MaxDecodedLen
+var ErrBlockTooLarge = errors.New("encoded data is too large for the data block")
+
+// 数据块已损坏，比如写入被中断、校验和不匹配或无法解码
+type ErrCorruptBlock struct {
+	// 损坏的数据块的序列号
+	Rsn int64
+	// 损坏的原因
+	Reason string
+}
+
+func (e *ErrCorruptBlock) Error() string {
+	return fmt.Sprintf("corrupt data block %d: %s", e.Rsn, e.Reason)
+}
+
+// 数据文件的接口类型，数据块的长度是编码之前的数据所能使用的最大长度
+type DataFile interface {
+	// 读取并解码一个数据块，数据块损坏时返回*ErrCorruptBlock
+	Read() (rsn int64, d Data, err error)
+	// 编码并写入一个数据块
+	Write(d Data) (wsn int64, err error)
+	// 批量读取最多max个数据块，buf用来存放未解码的数据块，它的长度决定了本次最多能读取多少个数据块。
+	// ds[i]是序列号为firstRsn+i的数据块，它可能引用了buf。有数据块损坏时它在ds中是nil，err是第一个损坏的数据块的*ErrCorruptBlock
+	ReadBatch(max int, buf []byte) (firstRsn int64, ds []Data, err error)
+	// 编码并批量写入数据块，只要有一个数据块编码失败就不写入任何数据块
+	WriteBatch(ds []Data) (firstWsn int64, err error)
+	// 获取最后读取的数据块的序列号
+	Rsn() int64
+	// 获取最后写入的数据块的序列号
+	Wsn() int64
+	// 获取底层数据块的长度，其中包含了头部
+	DataLen() uint32
+	// 获取写入时使用的编解码器
+	Codec() Codec
+}
+
+type Data []byte
+
+/**
+被包装的底层数据文件。datafile1~4中的DataFile都满足它，D是它们各自的Data类型，调用Wrap时可以直接传入它们而不需要写适配器
+*/
+type Base[D ~[]byte] interface {
+	Read() (rsn int64, d D, err error)
+	Write(d D) (wsn int64, err error)
+	ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error)
+	WriteBatch(ds []D) (firstWsn int64, err error)
+	Rsn() int64
+	Wsn() int64
+	DataLen() uint32
+}
+
+// 数据文件的实现类型
+type myDataFile[D ~[]byte] struct {
+	// 底层的数据文件
+	df Base[D]
+	// 写入时使用的编解码器
+	codec Codec
+}
+
+// 在path上创建一个datafile3中的数据文件并包装它
+func NewDataFile(path string, dataLen uint32, codec Codec) (DataFile, error) {
+	if dataLen <= headerLen {
+		return nil, errors.New("invalid data length")
+	}
+	df, err := datafile3.NewDataFile(path, dataLen)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(df, codec)
+}
+
+// 包装一个已经创建的数据文件，之后只能通过返回的DataFile读写它
+func Wrap[D ~[]byte](df Base[D], codec Codec) (DataFile, error) {
+	if df.DataLen() <= headerLen {
+		return nil, errors.New("invalid data length")
+	}
+	if codec == nil {
+		codec = None
+	}
+	if _, ok := lookupCodec(codec.ID()); !ok {
+		return nil, fmt.Errorf("codec %q is not registered", codec.Name())
+	}
+	return &myDataFile[D]{df: df, codec: codec}, nil
+}
+
+func (cdf *myDataFile[D]) Read() (rsn int64, d Data, err error) {
+	rsn, block, err := cdf.df.Read()
+	if err != nil {
+		return
+	}
+	d, reason := decodeBlock(block)
+	if reason != "" {
+		return rsn, nil, &ErrCorruptBlock{Rsn: rsn, Reason: reason}
+	}
+	return
+}
+
+func (cdf *myDataFile[D]) Write(d Data) (wsn int64, err error) {
+	block, err := encodeBlock(cdf.codec, d, cdf.df.DataLen())
+	if err != nil {
+		return
+	}
+	return cdf.df.Write(block)
+}
+
+func (cdf *myDataFile[D]) ReadBatch(max int, buf []byte) (firstRsn int64, ds []Data, err error) {
+	dataLen := int(cdf.df.DataLen())
+	firstRsn, n, err := cdf.df.ReadBatch(max, buf)
+	if err != nil {
+		return
+	}
+	ds = make([]Data, n)
+	for i := range ds {
+		d, reason := decodeBlock(buf[i*dataLen : (i+1)*dataLen])
+		if reason != "" {
+			if err == nil {
+				err = &ErrCorruptBlock{Rsn: firstRsn + int64(i), Reason: reason}
+			}
+			continue
+		}
+		ds[i] = d
+	}
+	return
+}
+
+func (cdf *myDataFile[D]) WriteBatch(ds []Data) (firstWsn int64, err error) {
+	blocks := make([]D, len(ds))
+	for i, d := range ds {
+		if blocks[i], err = encodeBlock(cdf.codec, d, cdf.df.DataLen()); err != nil {
+			return
+		}
+	}
+	return cdf.df.WriteBatch(blocks)
+}
+
+func (cdf *myDataFile[D]) Rsn() int64 {
+	return cdf.df.Rsn()
+}
+
+func (cdf *myDataFile[D]) Wsn() int64 {
+	return cdf.df.Wsn()
+}
+
+func (cdf *myDataFile[D]) DataLen() uint32 {
+	return cdf.df.DataLen()
+}
+
+func (cdf *myDataFile[D]) Codec() Codec {
+	return cdf.codec
+}
+
+// 编码数据并生成一个完整的数据块
+func encodeBlock(codec Codec, d []byte, dataLen uint32) ([]byte, error) {
+	if len(d) > MaxDecodedLen {
+		return nil, ErrBlockTooLarge
+	}
+	encoded, err := codec.Encode(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > int(dataLen)-headerLen {
+		return nil, ErrBlockTooLarge
+	}
+	block := make([]byte, dataLen)
+	block[4] = codec.ID()
+	binary.BigEndian.PutUint32(block[5:9], uint32(len(encoded)))
+	copy(block[headerLen:], encoded)
+	binary.BigEndian.PutUint32(block[0:4], crc32.Checksum(block[4:headerLen+len(encoded)], crcTable))
+	return block, nil
+}
+
+// 校验并解码一个数据块，数据块损坏时返回损坏的原因
+func decodeBlock(block []byte) ([]byte, string) {
+	if len(block) < headerLen {
+		return nil, "short block"
+	}
+	n := binary.BigEndian.Uint32(block[5:9])
+	if int64(n) > int64(len(block)-headerLen) {
+		return nil, fmt.Sprintf("invalid length %d", n)
+	}
+	sum := binary.BigEndian.Uint32(block[0:4])
+	if crc32.Checksum(block[4:headerLen+n], crcTable) != sum {
+		return nil, "checksum mismatch"
+	}
+	codec, ok := lookupCodec(block[4])
+	if !ok {
+		return nil, fmt.Sprintf("unknown codec %d", block[4])
+	}
+	d, err := codec.Decode(block[headerLen : headerLen+n])
+	if err != nil {
+		return nil, fmt.Sprintf("%s decode error: %s", codec.Name(), err)
+	}
+	return d, ""
+}
@@ -0,0 +1,247 @@
+package blockcodec
+
+import (
+	"basic/sync/datafile1"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 模拟我们存放在数据块中的JSON事件
+func genEvent(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"type":"page_view","user":{"id":"user-%d","name":"user-%d","email":"user-%d@example.com"},`+
+		`"page":{"url":"https://example.com/products/%d","referrer":"https://example.com/products/%d"},`+
+		`"agent":"Mozilla/5.0 (X11; Linux x86_64)","tags":["product","product-%d","category-%d"]}`,
+		i, i%7, i%7, i%7, i%13, i%11, i%13, i%5))
+}
+
+func TestCodecs(t *testing.T) {
+	random := make([]byte, 3000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		genEvent(1),
+		bytes.Repeat([]byte("abcabcabd"), 1000),
+		bytes.Repeat([]byte{0}, 70000),
+		random,
+	}
+	for _, codec := range []Codec{None, Gzip, Flate, LZ} {
+		for i, input := range inputs {
+			encoded, err := codec.Encode(input)
+			if err != nil {
+				t.Fatalf("ERROR: %s encode error on input %d: %s", codec.Name(), i, err)
+			}
+			decoded, err := codec.Decode(encoded)
+			if err != nil {
+				t.Fatalf("ERROR: %s decode error on input %d: %s", codec.Name(), i, err)
+			}
+			if !bytes.Equal(decoded, input) {
+				t.Fatalf("ERROR: %s round trip of input %d is wrong!", codec.Name(), i)
+			}
+		}
+	}
+	// 损坏的输入只能返回错误，不能导致panic
+	encoded, _ := LZ.Encode(bytes.Repeat([]byte("abcabcabd"), 100))
+	for i := range encoded {
+		corrupted := append([]byte(nil), encoded...)
+		corrupted[i] ^= 0xff
+		LZ.Decode(corrupted)
+		LZ.Decode(encoded[:i])
+	}
+}
+
+func TestDataFile(t *testing.T) {
+	for _, codec := range []Codec{None, Gzip, Flate, LZ} {
+		df, err := NewDataFile(filepath.Join(t.TempDir(), "data"), 512, codec)
+		if err != nil {
+			t.Fatalf("Can not create the data file: %s", err)
+		}
+		for i := 0; i < 10; i++ {
+			if _, err := df.Write(genEvent(i)); err != nil {
+				t.Fatalf("ERROR: %s write error: %s", codec.Name(), err)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			rsn, d, err := df.Read()
+			if err != nil {
+				t.Fatalf("ERROR: %s read error: %s", codec.Name(), err)
+			}
+			if rsn != int64(i) || !bytes.Equal(d, genEvent(i)) {
+				t.Fatalf("ERROR: %s read (%d, %q), expected (%d, %q)", codec.Name(), rsn, d, i, genEvent(i))
+			}
+		}
+	}
+}
+
+// 压缩之后可以使用更小的数据块
+func TestCompression(t *testing.T) {
+	event := genEvent(12345)
+	for _, codec := range []Codec{Flate, LZ} {
+		encoded, err := codec.Encode(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s: %d => %d bytes", codec.Name(), len(event), len(encoded))
+		if len(encoded) >= len(event) {
+			t.Errorf("ERROR: %s does not compress the JSON event (%d => %d bytes)!", codec.Name(), len(event), len(encoded))
+		}
+	}
+	dataLen := uint32(headerLen + len(event) - 1)
+	if _, err := encodeBlock(None, event, dataLen); err != ErrBlockTooLarge {
+		t.Fatalf("ERROR: Writing a too large event returned %v, expected %v", err, ErrBlockTooLarge)
+	}
+	if _, err := encodeBlock(Flate, event, dataLen); err != nil {
+		t.Fatalf("ERROR: The compressed event should fit in the data block: %s", err)
+	}
+}
+
+/**
+模拟被中断的写入：直接修改文件中的第二个数据块，读取它的时候应该返回带有序列号的ErrCorruptBlock，而其它数据块不受影响。
+*/
+func TestCorruptBlock(t *testing.T) {
+	const dataLen = 512
+	for _, corrupt := range []struct {
+		name   string
+		offset int64
+		data   []byte
+	}{
+		{"zeroed", 0, make([]byte, dataLen)},
+		{"torn", headerLen + 10, bytes.Repeat([]byte{0xff}, dataLen-headerLen-10)},
+		{"length", 5, []byte{0xff, 0xff, 0xff, 0xff}},
+	} {
+		path := filepath.Join(t.TempDir(), "data")
+		df, err := NewDataFile(path, dataLen, LZ)
+		if err != nil {
+			t.Fatalf("Can not create the data file: %s", err)
+		}
+		for i := 0; i < 3; i++ {
+			if _, err := df.Write(genEvent(i)); err != nil {
+				t.Fatalf("ERROR: Write error: %s", err)
+			}
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(corrupt.data, dataLen+corrupt.offset); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		for i := 0; i < 3; i++ {
+			rsn, d, err := df.Read()
+			if i != 1 {
+				if err != nil || !bytes.Equal(d, genEvent(i)) {
+					t.Fatalf("ERROR: [%s] Read returned (%d, %q, %v), expected (%d, %q, nil)", corrupt.name, rsn, d, err, i, genEvent(i))
+				}
+				continue
+			}
+			var cbErr *ErrCorruptBlock
+			if !errors.As(err, &cbErr) || cbErr.Rsn != 1 || d != nil {
+				t.Fatalf("ERROR: [%s] Read returned (%q, %v), expected a corrupt block error of rsn 1", corrupt.name, d, err)
+			}
+			t.Logf("[%s] %s", corrupt.name, err)
+		}
+	}
+}
+
+type testCodec struct{ noneCodec }
+
+func (testCodec) ID() byte     { return 100 }
+func (testCodec) Name() string { return "test" }
+
+func TestRegisterCodec(t *testing.T) {
+	if _, err := NewDataFile(filepath.Join(t.TempDir(), "data"), 64, testCodec{}); err == nil {
+		t.Fatal("ERROR: An unregistered codec should be rejected!")
+	}
+	if err := RegisterCodec(testCodec{}); err != nil {
+		t.Fatalf("ERROR: RegisterCodec error: %s", err)
+	}
+	t.Cleanup(func() { unregisterCodec(testCodec{}.ID()) })
+	if err := RegisterCodec(testCodec{}); err == nil {
+		t.Fatal("ERROR: A codec id should not be registered twice!")
+	}
+	if _, err := NewDataFile(filepath.Join(t.TempDir(), "data"), 64, testCodec{}); err != nil {
+		t.Fatalf("ERROR: Can not create the data file with a registered codec: %s", err)
+	}
+	if err := RegisterCodec(reservedCodec{}); err == nil {
+		unregisterCodec(reservedCodec{}.ID())
+		t.Fatal("ERROR: A reserved codec id should be rejected!")
+	}
+}
+
+type reservedCodec struct{ noneCodec }
+
+func (reservedCodec) ID() byte     { return reservedCodecIDs - 1 }
+func (reservedCodec) Name() string { return "reserved" }
+
+// 很小的数据块被解压成超过MaxDecodedLen的数据时返回错误，而不是把它们都读到内存中
+func TestDecompressionBomb(t *testing.T) {
+	zeros := make([]byte, 4*MaxDecodedLen)
+	for _, codec := range []Codec{Gzip, Flate, LZ} {
+		if _, err := encodeBlock(codec, zeros, 1<<20); err != ErrBlockTooLarge {
+			t.Fatalf("ERROR: %s encoding too large data returned %v, expected %v", codec.Name(), err, ErrBlockTooLarge)
+		}
+		// 绕过encodeBlock的检查，直接编码
+		encoded, err := codec.Encode(zeros)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := codec.Decode(encoded); err != errDecodedTooLarge {
+			t.Fatalf("ERROR: %s decoded %d bytes from %d bytes: %v", codec.Name(), len(zeros), len(encoded), err)
+		}
+	}
+}
+
+// 包装其它的数据文件实现，并批量读写
+func TestWrapBatch(t *testing.T) {
+	base, err := datafile1.NewDataFile(filepath.Join(t.TempDir(), "data"), 512)
+	if err != nil {
+		t.Fatalf("Can not create the data file: %s", err)
+	}
+	df, err := Wrap(base, LZ)
+	if err != nil {
+		t.Fatalf("ERROR: Wrap error: %s", err)
+	}
+	ds := make([]Data, 5)
+	for i := range ds {
+		ds[i] = genEvent(i)
+	}
+	if firstWsn, err := df.WriteBatch(ds); err != nil || firstWsn != 0 {
+		t.Fatalf("ERROR: WriteBatch returned (%d, %v), expected (0, nil)", firstWsn, err)
+	}
+	if _, err := df.WriteBatch([]Data{genEvent(5), make([]byte, MaxDecodedLen+1)}); err != ErrBlockTooLarge {
+		t.Fatalf("ERROR: WriteBatch returned %v, expected %v", err, ErrBlockTooLarge)
+	}
+	if df.Wsn() != 5 {
+		t.Fatalf("ERROR: The wsn %d is not 5, a partial batch is written!", df.Wsn())
+	}
+	buf := make([]byte, 10*512)
+	firstRsn, read, err := df.ReadBatch(10, buf)
+	if err != nil || firstRsn != 0 || len(read) != len(ds) {
+		t.Fatalf("ERROR: ReadBatch returned (%d, %d blocks, %v), expected (0, %d blocks, nil)", firstRsn, len(read), err, len(ds))
+	}
+	for i, d := range read {
+		if !bytes.Equal(d, ds[i]) {
+			t.Fatalf("ERROR: The data block %d is %q, expected %q", i, d, ds[i])
+		}
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	event := genEvent(12345)
+	for _, codec := range []Codec{None, Gzip, Flate, LZ} {
+		b.Run(codec.Name(), func(b *testing.B) {
+			b.SetBytes(int64(len(event)))
+			for i := 0; i < b.N; i++ {
+				encoded, _ := codec.Encode(event)
+				codec.Decode(encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package blockcodec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 数据块内容的编解码器
+type Codec interface {
+	// 编解码器的标识，它会被写入每个数据块的头部，读取时据此选择解码器。0~15被内置的编解码器保留
+	ID() byte
+	// 编解码器的名称
+	Name() string
+	// 编码（压缩）
+	Encode(src []byte) ([]byte, error)
+	// 解码（解压缩）
+	Decode(src []byte) ([]byte, error)
+}
+
+// 内置的编解码器
+var (
+	// 不压缩
+	None Codec = noneCodec{}
+	// gzip格式，额外的头部和尾部使它不适合很小的数据块
+	Gzip Codec = gzipCodec{}
+	// 没有任何头部的DEFLATE格式
+	Flate Codec = flateCodec{}
+	// 类似snappy的LZ77格式，压缩率不如DEFLATE，但速度快得多
+	LZ Codec = lzCodec{}
+)
+
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[byte]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{None, Gzip, Flate, LZ} {
+		codecs[c.ID()] = c
+	}
+}
+
+// 被内置的编解码器保留的标识的数量
+const reservedCodecIDs = 16
+
+// 注册一个编解码器，读取数据块时会根据数据块头部的标识找到它。它的标识不能是被保留的0~15
+func RegisterCodec(c Codec) error {
+	if c.ID() < reservedCodecIDs {
+		return fmt.Errorf("codec id %d is reserved for the built-in codecs", c.ID())
+	}
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	if old, ok := codecs[c.ID()]; ok {
+		return fmt.Errorf("codec id %d is already registered by %q", c.ID(), old.Name())
+	}
+	codecs[c.ID()] = c
+	return nil
+}
+
+// 取消注册一个编解码器，它只用于测试中恢复注册表
+func unregisterCodec(id byte) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	delete(codecs, id)
+}
+
+func lookupCodec(id byte) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	c, ok := codecs[id]
+	return c, ok
+}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() byte                          { return 0 }
+func (noneCodec) Name() string                      { return "none" }
+func (noneCodec) Encode(src []byte) ([]byte, error) { return src, nil }
+func (noneCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte     { return 1 }
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readDecoded(r)
+}
+
+type flateCodec struct{}
+
+func (flateCodec) ID() byte     { return 2 }
+func (flateCodec) Name() string { return "flate" }
+
+func (flateCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decode(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readDecoded(r)
+}
+
+// 解码之后的数据超过了MaxDecodedLen
+var errDecodedTooLarge = fmt.Errorf("decoded data exceeds %d bytes", MaxDecodedLen)
+
+// 读取解压之后的数据，最多读取MaxDecodedLen个字节，超过时返回错误，而不是把所有的数据都读到内存中
+func readDecoded(r io.Reader) ([]byte, error) {
+	d, err := io.ReadAll(io.LimitReader(r, MaxDecodedLen+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > MaxDecodedLen {
+		return nil, errDecodedTooLarge
+	}
+	return d, nil
+}
+
+/**
+类似snappy的LZ77压缩格式：
+开头是用uvarint编码的原始数据长度，后面是一系列的元素，每个元素以一个标签字节开头，标签字节的低2位表示元素的类型：
+00 字面量：高6位是长度减1（1~60）；高6位为60时，长度减1的值存放在随后的一个字节中；为61时，存放在随后的两个字节中（小端）。之后是字面量本身
+01 复制：高6位是长度减4（4~67），随后的两个字节（小端）是向前的偏移量（1~65535）
+*/
+type lzCodec struct{}
+
+const (
+	lzTagLiteral = 0
+	lzTagCopy    = 1
+	lzMinMatch   = 4
+	lzMaxMatch   = 67
+	lzMaxOffset  = 1<<16 - 1
+	lzHashBits   = 12
+)
+
+var errLZCorrupt = errors.New("lz: corrupt input")
+
+func (lzCodec) ID() byte     { return 3 }
+func (lzCodec) Name() string { return "lz" }
+
+func (lzCodec) Encode(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)/2+16)
+	var lenBuf [binary.MaxVarintLen64]byte
+	dst = append(dst, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(src)))]...)
+	// 记录每个4字节序列最近一次出现的位置（加1，0表示没有出现过）
+	var table [1 << lzHashBits]int
+	literalStart := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		h := lzHash(src[i:])
+		candidate := table[h] - 1
+		table[h] = i + 1
+		if candidate < 0 || i-candidate > lzMaxOffset || !bytes.Equal(src[candidate:candidate+lzMinMatch], src[i:i+lzMinMatch]) {
+			i++
+			continue
+		}
+		// 找到了匹配，先输出之前的字面量，再尽可能地延长匹配
+		dst = lzAppendLiteral(dst, src[literalStart:i])
+		n := lzMinMatch
+		for i+n < len(src) && n < lzMaxMatch && src[candidate+n] == src[i+n] {
+			n++
+		}
+		offset := i - candidate
+		dst = append(dst, byte((n-lzMinMatch)<<2|lzTagCopy), byte(offset), byte(offset>>8))
+		i += n
+		literalStart = i
+	}
+	dst = lzAppendLiteral(dst, src[literalStart:])
+	return dst, nil
+}
+
+func (lzCodec) Decode(src []byte) ([]byte, error) {
+	n, l := binary.Uvarint(src)
+	if l <= 0 || n > uint64(len(src))*(lzMaxMatch/3+1) {
+		return nil, errLZCorrupt
+	}
+	if n > MaxDecodedLen {
+		return nil, errDecodedTooLarge
+	}
+	src = src[l:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+		switch tag & 3 {
+		case lzTagLiteral:
+			length := int(tag >> 2)
+			switch length {
+			case 60:
+				if len(src) < 1 {
+					return nil, errLZCorrupt
+				}
+				length = int(src[0])
+				src = src[1:]
+			case 61:
+				if len(src) < 2 {
+					return nil, errLZCorrupt
+				}
+				length = int(src[0]) | int(src[1])<<8
+				src = src[2:]
+			}
+			length++
+			if length > len(src) {
+				return nil, errLZCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+		case lzTagCopy:
+			if len(src) < 2 {
+				return nil, errLZCorrupt
+			}
+			length := int(tag>>2) + lzMinMatch
+			offset := int(src[0]) | int(src[1])<<8
+			src = src[2:]
+			if offset == 0 || offset > len(dst) {
+				return nil, errLZCorrupt
+			}
+			// 复制的区域可能与正在写入的区域重叠，所以只能逐个字节地复制
+			start := len(dst) - offset
+			for k := 0; k < length; k++ {
+				dst = append(dst, dst[start+k])
+			}
+		default:
+			return nil, errLZCorrupt
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errLZCorrupt
+	}
+	return dst, nil
+}
+
+func lzHash(p []byte) uint32 {
+	v := uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+	return (v * 0x1e35a7bd) >> (32 - lzHashBits)
+}
+
+func lzAppendLiteral(dst []byte, lit []byte) []byte {
+	for len(lit) > 0 {
+		n := len(lit)
+		if n > 1<<16 {
+			n = 1 << 16
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte((n-1)<<2|lzTagLiteral))
+		case n <= 256:
+			dst = append(dst, 60<<2|lzTagLiteral, byte(n-1))
+		default:
+			dst = append(dst, 61<<2|lzTagLiteral, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, lit[:n]...)
+		lit = lit[n:]
+	}
+	return dst
+}
@@ -2,7 +2,6 @@ package datafile2
 
 import (
 	"errors"
-	"io"
 	"os"
 	"sync"
 )
@@ -18,10 +17,15 @@ import (
 
 // 数据文件的接口类型
 type DataFile interface {
-	// 读取一个数据块
+	// 读取一个数据块，它因为写入失败而不存在时返回ErrNotWritten
 	Read() (rsn int64, d Data, err error)
 	// 写入一个数据块
 	Write(d Data) (wsn int64, err error)
+	// 批量读取数据块，最多读取max个连续的数据块并依次填充到调用方提供的缓冲区buf中，返回第一个数据块的序列号和实际读取的数据块数量。
+	// 第i个数据块位于buf[i*DataLen():(i+1)*DataLen()]，buf的长度决定了本次最多能读取多少个数据块。其中有写入失败的数据块时返回ErrNotWritten
+	ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error)
+	// 批量写入数据块，这些数据块会占用连续的偏移量并且只通过一次系统调用写入，返回第一个数据块的序列号
+	WriteBatch(ds []Data) (firstWsn int64, err error)
 	// 获取最后读取的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被读取的数据块的数量
 	Rsn() int64
 	// 获取最后写入的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被写入的数据块的数量
@@ -42,6 +46,12 @@ type myDataFile struct {
 	wOffset int64
 	// 读操作需要用到的偏移量
 	rOffset int64
+	// 已提交的偏移量，在它之前的数据块都已被完整地写入，读操作只会读取这个偏移量之前的数据块。受fMutex保护
+	cOffset int64
+	// 已写入但还未提交的数据块，键是偏移量，值是长度。受fMutex保护
+	pending map[int64]int64
+	// 写入失败的数据块，键是偏移量，值是长度。它们同样会被提交，但是读操作读到它们时会返回ErrNotWritten。受fMutex保护
+	failed map[int64]int64
 	// 写操作用到的互斥锁
 	wMutex sync.Mutex
 	// 读操作需要用到的互斥锁
@@ -52,6 +62,12 @@ type myDataFile struct {
 
 type Data []byte
 
+// 数据块因为写入失败而不存在时返回的错误
+var ErrNotWritten = errors.New("data block not written")
+
+// 在写操作预留偏移量之后、真正写入之前调用，测试通过它来控制多个写操作完成的先后顺序，它返回错误时模拟写入失败
+var testHookWrite = func(offset int64) error { return nil }
+
 func NewDataFile(path string, dataLen uint32) (DataFile, error) {
 	f, err := os.Create(path)
 	if err != nil {
@@ -63,6 +79,8 @@ func NewDataFile(path string, dataLen uint32) (DataFile, error) {
 	// wOffset和rOffset的零值就是0，fMutex、wMutex和rMutex的零值就是可用的锁
 	df := &myDataFile{
 		f:       f,
+		pending: make(map[int64]int64),
+		failed:  make(map[int64]int64),
 		dataLen: dataLen,
 	}
 	df.rCond = sync.NewCond(df.fMutex.RLocker())
@@ -82,20 +100,22 @@ func (df *myDataFile) Read() (rsn int64, d Data, err error) {
 	bytes := make([]byte, df.dataLen)
 	df.fMutex.RLock()
 	defer df.fMutex.RUnlock()
-	for {
-		_, err = df.f.ReadAt(bytes, offset)
-		if err != nil {
-			// 出现EOF的时候继续尝试获取同一个数据块，知道获取成功为止。这是为了避免在读Goroutine多于写Goroutine的情况下出现漏读的问题
-			if err == io.EOF {
-				// 等待直到写操作发送通知唤醒
-				df.rCond.Wait()
-				continue
-			}
-			return
-		}
-		d = bytes
+	// 数据块还未被提交的时候继续等待，直到它被提交为止。这是为了避免在读Goroutine多于写Goroutine的情况下出现漏读的问题，
+	// 同时也避免了在后面的数据块先被写入的情况下读到前面还未被写入的空洞
+	for offset+int64(df.dataLen) > df.cOffset {
+		// 等待直到写操作发送通知唤醒
+		df.rCond.Wait()
+	}
+	if !df.written(offset, offset+int64(df.dataLen)) {
+		err = ErrNotWritten
+		return
+	}
+	_, err = df.f.ReadAt(bytes, offset)
+	if err != nil {
 		return
 	}
+	d = bytes
+	return
 }
 
 func (df *myDataFile) Write(d Data) (wsn int64, err error) {
@@ -108,17 +128,102 @@ func (df *myDataFile) Write(d Data) (wsn int64, err error) {
 
 	// 写入一个数据块
 	wsn = offset / int64(df.dataLen)
-	var bytes []byte
-	if len(d) > int(df.dataLen) {
-		bytes = d[0:df.dataLen]
-	} else {
-		bytes = d
+	bytes := packBatch([]Data{d}, df.dataLen)
+	// 在预留的偏移量上写入数据块，而不是追加到文件的当前位置，这样数据块在文件中的位置就与它的序列号一一对应了
+	if err = testHookWrite(offset); err == nil {
+		_, err = df.f.WriteAt(bytes, offset)
+	}
+	df.commit(offset, int64(len(bytes)), err != nil)
+	return
+}
+
+func (df *myDataFile) ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error) {
+	n = batchLen(max, buf, df.dataLen)
+	if n == 0 {
+		err = errors.New("invalid batch size or buffer length")
+		return
 	}
+	// 一次预留连续的n个数据块。为了不让当前读操作为了凑满一批数据而长时间阻塞，最多只预留已被写入的数据块的数量（至少预留一个）
+	var offset int64
+	df.rMutex.Lock()
+	offset = df.rOffset
+	df.wMutex.Lock()
+	if available := int((df.wOffset - offset) / int64(df.dataLen)); available < n {
+		n = available
+	}
+	df.wMutex.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	df.rOffset += int64(n) * int64(df.dataLen)
+	df.rMutex.Unlock()
+	// 通过一次ReadAt读取全部数据块，直接填充到调用方提供的缓冲区中，不再为每个数据块分配内存
+	firstRsn = offset / int64(df.dataLen)
+	bytes := buf[:n*int(df.dataLen)]
+	df.fMutex.RLock()
+	defer df.fMutex.RUnlock()
+	for offset+int64(len(bytes)) > df.cOffset {
+		// 等待直到写操作发送通知唤醒
+		df.rCond.Wait()
+	}
+	// 这一批中有写入失败的数据块时，整批数据块都被跳过了，n是被跳过的数据块的数量
+	if !df.written(offset, offset+int64(len(bytes))) {
+		err = ErrNotWritten
+		return
+	}
+	_, err = df.f.ReadAt(bytes, offset)
+	return
+}
+
+func (df *myDataFile) WriteBatch(ds []Data) (firstWsn int64, err error) {
+	if len(ds) == 0 {
+		err = errors.New("empty data batch")
+		return
+	}
+	// 一次预留连续的偏移量，保证同一批数据块不会与其它写操作的数据块穿插
+	size := int64(len(ds)) * int64(df.dataLen)
+	var offset int64
+	df.wMutex.Lock()
+	offset = df.wOffset
+	df.wOffset += size
+	df.wMutex.Unlock()
+
+	// 把所有数据块拼接到一个缓冲区中，只进行一次写操作
+	firstWsn = offset / int64(df.dataLen)
+	bytes := packBatch(ds, df.dataLen)
+	if err = testHookWrite(offset); err == nil {
+		_, err = df.f.WriteAt(bytes, offset)
+	}
+	df.commit(offset, size, err != nil)
+	return
+}
+
+/**
+提交一段已写入的数据。多个写操作是并发地写入文件的，它们完成的顺序与预留偏移量的顺序不一定相同，所以这里先把已写入的数据记录下来，
+只有当它前面的数据都已被提交之后才会推进已提交的偏移量。写入失败的数据也要提交，否则后面的数据块将永远不能被读取，
+但是它会被记录下来，读操作读到它时返回ErrNotWritten，而不是读到一个空洞。
+*/
+func (df *myDataFile) commit(offset int64, size int64, failed bool) {
 	df.fMutex.Lock()
 	defer df.fMutex.Unlock()
-	_, err = df.f.Write(bytes)
-	df.rCond.Signal()
-	return
+	df.pending[offset] = size
+	if failed {
+		df.failed[offset] = size
+	}
+	advanced := false
+	for {
+		size, ok := df.pending[df.cOffset]
+		if !ok {
+			break
+		}
+		delete(df.pending, df.cOffset)
+		df.cOffset += size
+		advanced = true
+	}
+	if advanced {
+		// 等待的读操作读取的数据块各不相同，所以需要广播通知
+		df.rCond.Broadcast()
+	}
 }
 
 /**
@@ -141,3 +246,34 @@ func (df *myDataFile) Wsn() int64 {
 func (df *myDataFile) DataLen() uint32 {
 	return df.dataLen
 }
+
+// [offset, end)中的数据块是否都被成功地写入了，调用方必须持有读锁
+func (df *myDataFile) written(offset int64, end int64) bool {
+	for start, size := range df.failed {
+		if start < end && offset < start+size {
+			return false
+		}
+	}
+	return true
+}
+
+// 计算一次批量读取的数据块的数量，它既不能超过max，也不能超过缓冲区所能容纳的数据块的数量
+func batchLen(max int, buf []byte, dataLen uint32) int {
+	n := len(buf) / int(dataLen)
+	if max < n {
+		n = max
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// 把多个数据块拼接成一个缓冲区，每个数据块都占用dataLen个字节，超出的部分会被截断，不足的部分以0填充
+func packBatch(ds []Data, dataLen uint32) []byte {
+	bytes := make([]byte, len(ds)*int(dataLen))
+	for i, d := range ds {
+		copy(bytes[i*int(dataLen):(i+1)*int(dataLen)], d)
+	}
+	return bytes
+}
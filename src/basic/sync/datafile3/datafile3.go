@@ -2,7 +2,6 @@ package datafile3
 
 import (
 	"errors"
-	"io"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -14,10 +13,15 @@ import (
 
 // 数据文件的接口类型
 type DataFile interface {
-	// 读取一个数据块
+	// 读取一个数据块，它因为写入失败而不存在时返回ErrNotWritten
 	Read() (rsn int64, d Data, err error)
 	// 写入一个数据块
 	Write(d Data) (wsn int64, err error)
+	// 批量读取数据块，最多读取max个连续的数据块并依次填充到调用方提供的缓冲区buf中，返回第一个数据块的序列号和实际读取的数据块数量。
+	// 第i个数据块位于buf[i*DataLen():(i+1)*DataLen()]，buf的长度决定了本次最多能读取多少个数据块。其中有写入失败的数据块时返回ErrNotWritten
+	ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error)
+	// 批量写入数据块，这些数据块会占用连续的偏移量并且只通过一次系统调用写入，返回第一个数据块的序列号
+	WriteBatch(ds []Data) (firstWsn int64, err error)
 	// 获取最后读取的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被读取的数据块的数量
 	Rsn() int64
 	// 获取最后写入的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被写入的数据块的数量
@@ -34,16 +38,56 @@ type myDataFile struct {
 	fMutex sync.RWMutex
 	// 读操作需要用到的条件变量
 	rCond *sync.Cond
-	// 写操作需要用到的偏移量
-	wOffset int64
+	// 最后一个被预留的数据段。它可能暂时落后于真正的最后一个数据段，见reserve方法
+	tail atomic.Pointer[segment]
+	// 最后一个被提交的数据段，在它之前的数据块都已被写入，读操作只会读取它的结束偏移量之前的数据块
+	head atomic.Pointer[segment]
+	// 写入失败的数据段组成的链表，读操作读到它们时返回ErrNotWritten
+	failed atomic.Pointer[failure]
 	// 读操作需要用到的偏移量
 	rOffset int64
+	// 正在等待数据块被提交的读操作的数量，没有读操作等待的时候写操作就不必加锁发送通知了
+	waiters int32
 	// 数据块长度
 	dataLen uint32
 }
 
+/**
+一次写操作预留的数据段。所有的数据段按照偏移量的顺序组成一个单向链表：预留就是通过CAS把新的数据段追加到链表的末尾，
+新数据段的偏移量就是前一个数据段的结束偏移量，所以预留偏移量和排队等待提交是同一个原子操作。
+*/
+type segment struct {
+	offset int64
+	size   int64
+	// 写入的状态，它只会从segmentPending变为segmentDone
+	state int32
+	next  atomic.Pointer[segment]
+}
+
+const (
+	segmentPending int32 = iota
+	segmentDone
+)
+
+func (seg *segment) end() int64 {
+	return seg.offset + seg.size
+}
+
+// 写入失败的数据段
+type failure struct {
+	offset int64
+	size   int64
+	next   *failure
+}
+
 type Data []byte
 
+// 数据块因为写入失败而不存在时返回的错误
+var ErrNotWritten = errors.New("data block not written")
+
+// 在写操作预留偏移量之后、真正写入之前调用，测试通过它来控制多个写操作完成的先后顺序，它返回错误时模拟写入失败
+var testHookWrite = func(offset int64) error { return nil }
+
 func NewDataFile(path string, dataLen uint32) (DataFile, error) {
 	f, err := os.Create(path)
 	if err != nil {
@@ -52,12 +96,16 @@ func NewDataFile(path string, dataLen uint32) (DataFile, error) {
 	if dataLen == 0 {
 		return nil, errors.New("invalid data length")
 	}
-	// wOffset和rOffset的零值就是0，fMutex、wMutex和rMutex的零值就是可用的锁
+	// rOffset的零值就是0，fMutex的零值就是可用的锁
 	df := &myDataFile{
 		f:       f,
 		dataLen: dataLen,
 	}
 	df.rCond = sync.NewCond(df.fMutex.RLocker())
+	// 链表的头部是一个已提交的空数据段
+	sentinel := &segment{state: segmentDone}
+	df.tail.Store(sentinel)
+	df.head.Store(sentinel)
 	return df, nil
 }
 
@@ -77,49 +125,182 @@ func (df *myDataFile) Read() (rsn int64, d Data, err error) {
 	bytes := make([]byte, df.dataLen)
 	df.fMutex.RLock()
 	defer df.fMutex.RUnlock()
-	for {
-		_, err = df.f.ReadAt(bytes, offset)
-		if err != nil {
-			// 出现EOF的时候继续尝试获取同一个数据块，知道获取成功为止。这是为了避免在读Goroutine多于写Goroutine的情况下出现漏读的问题
-			if err == io.EOF {
-				// 等待知道写操作发送通知唤醒
-				df.rCond.Wait()
-				continue
-			}
-			return
-		}
-		d = bytes
+	// 数据块还未被提交的时候继续等待，直到它被提交为止。这是为了避免在读Goroutine多于写Goroutine的情况下出现漏读的问题，
+	// 同时也避免了在后面的数据块先被写入的情况下读到前面还未被写入的空洞
+	df.waitCommitted(offset + int64(df.dataLen))
+	if !df.written(offset, offset+int64(df.dataLen)) {
+		err = ErrNotWritten
 		return
 	}
+	_, err = df.f.ReadAt(bytes, offset)
+	if err != nil {
+		return
+	}
+	d = bytes
+	return
 }
 
 func (df *myDataFile) Write(d Data) (wsn int64, err error) {
-	// 读取并更新写偏移量
+	// 通过CAS预留偏移量
+	seg := df.reserve(int64(df.dataLen))
+
+	// 写入一个数据块
+	wsn = seg.offset / int64(df.dataLen)
+	bytes := packBatch([]Data{d}, df.dataLen)
+	// 在预留的偏移量上写入数据块，而不是追加到文件的当前位置，这样数据块在文件中的位置就与它的序列号一一对应了
+	if err = testHookWrite(seg.offset); err == nil {
+		_, err = df.f.WriteAt(bytes, seg.offset)
+	}
+	df.commit(seg, err != nil)
+	return
+}
+
+func (df *myDataFile) ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error) {
+	n = batchLen(max, buf, df.dataLen)
+	if n == 0 {
+		err = errors.New("invalid batch size or buffer length")
+		return
+	}
+	// 通过CAS一次预留连续的数据块。为了不让当前读操作为了凑满一批数据而长时间阻塞，最多只预留已被写入的数据块的数量（至少预留一个）
 	var offset int64
+	var size int64
 	for {
-		// 这样读取的原因是：在32位计算机上对64位整数进行操作的时候存在并发安全问题
-		offset = atomic.LoadInt64(&df.wOffset)
-		// 通过CAS更新
-		if atomic.CompareAndSwapInt64(&df.wOffset, offset, offset+int64(df.dataLen)) {
+		offset = atomic.LoadInt64(&df.rOffset)
+		count := n
+		if available := int((df.last().end() - offset) / int64(df.dataLen)); available < count {
+			count = available
+		}
+		if count < 1 {
+			count = 1
+		}
+		size = int64(count) * int64(df.dataLen)
+		if atomic.CompareAndSwapInt64(&df.rOffset, offset, offset+size) {
+			n = count
 			break
 		}
 	}
+	// 通过一次ReadAt读取全部数据块，直接填充到调用方提供的缓冲区中，不再为每个数据块分配内存
+	firstRsn = offset / int64(df.dataLen)
+	bytes := buf[:size]
+	df.fMutex.RLock()
+	defer df.fMutex.RUnlock()
+	df.waitCommitted(offset + size)
+	// 这一批中有写入失败的数据块时，整批数据块都被跳过了，n是被跳过的数据块的数量
+	if !df.written(offset, offset+size) {
+		err = ErrNotWritten
+		return
+	}
+	_, err = df.f.ReadAt(bytes, offset)
+	return
+}
 
-	// 写入一个数据块
-	wsn = offset / int64(df.dataLen)
-	var bytes []byte
-	if len(d) > int(df.dataLen) {
-		bytes = d[0:df.dataLen]
-	} else {
-		bytes = d
+func (df *myDataFile) WriteBatch(ds []Data) (firstWsn int64, err error) {
+	if len(ds) == 0 {
+		err = errors.New("empty data batch")
+		return
 	}
-	df.fMutex.Lock()
-	defer df.fMutex.Unlock()
-	_, err = df.f.Write(bytes)
-	df.rCond.Signal()
+	// 通过CAS一次预留连续的偏移量，保证同一批数据块不会与其它写操作的数据块穿插
+	seg := df.reserve(int64(len(ds)) * int64(df.dataLen))
+
+	// 把所有数据块拼接到一个缓冲区中，只进行一次写操作
+	firstWsn = seg.offset / int64(df.dataLen)
+	bytes := packBatch(ds, df.dataLen)
+	if err = testHookWrite(seg.offset); err == nil {
+		_, err = df.f.WriteAt(bytes, seg.offset)
+	}
+	df.commit(seg, err != nil)
 	return
 }
 
+/**
+预留长度为size的数据段。这是一个无锁队列的入队操作：只有链表真正的最后一个数据段的next为nil，通过CAS把它设置为新的数据段就完成了预留。
+CAS成功之后再推进tail，在此之前其它写操作可能看到落后的tail，它们会先帮忙推进tail再重试，所以不会有写操作因为等待另一个写操作而被阻塞
+*/
+func (df *myDataFile) reserve(size int64) *segment {
+	seg := &segment{size: size}
+	for {
+		tail := df.tail.Load()
+		if next := tail.next.Load(); next != nil {
+			df.tail.CompareAndSwap(tail, next)
+			continue
+		}
+		seg.offset = tail.end()
+		if tail.next.CompareAndSwap(nil, seg) {
+			df.tail.CompareAndSwap(tail, seg)
+			return seg
+		}
+	}
+}
+
+// 真正的最后一个被预留的数据段
+func (df *myDataFile) last() *segment {
+	seg := df.tail.Load()
+	for next := seg.next.Load(); next != nil; next = seg.next.Load() {
+		seg = next
+	}
+	return seg
+}
+
+/**
+等待直到end之前的数据都已被提交，调用方必须持有读锁。
+必须先增加等待者的数量再检查已提交的偏移量：如果写操作在推进偏移量之后没有看到等待者，那么这里就一定能看到推进之后的偏移量。
+*/
+func (df *myDataFile) waitCommitted(end int64) {
+	atomic.AddInt32(&df.waiters, 1)
+	defer atomic.AddInt32(&df.waiters, -1)
+	for end > df.head.Load().end() {
+		// 等待直到写操作发送通知唤醒
+		df.rCond.Wait()
+	}
+}
+
+// [offset, end)中的数据块是否都被成功地写入了
+func (df *myDataFile) written(offset int64, end int64) bool {
+	for f := df.failed.Load(); f != nil; f = f.next {
+		if f.offset < end && offset < f.offset+f.size {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+提交一段已写入的数据。多个写操作是并发地写入文件的，它们完成的顺序与预留偏移量的顺序不一定相同，所以这里先把数据段标记为已完成，
+然后从head开始，只要下一个数据段已经完成就通过CAS把head推进到它上面。每个写操作都是先标记再推进，所以最后一个完成的写操作一定能看到
+它前面的所有数据段都已完成，已提交的偏移量不会因为写操作之间的竞争而停滞；CAS则保证了head只会向前移动。
+写入失败的数据也要提交，否则后面的数据块将永远不能被读取，但是它会先被记录下来，读操作读到它时返回ErrNotWritten，而不是读到一个空洞。
+*/
+func (df *myDataFile) commit(seg *segment, failed bool) {
+	if failed {
+		f := &failure{offset: seg.offset, size: seg.size}
+		for {
+			f.next = df.failed.Load()
+			if df.failed.CompareAndSwap(f.next, f) {
+				break
+			}
+		}
+	}
+	atomic.StoreInt32(&seg.state, segmentDone)
+	advanced := false
+	for {
+		head := df.head.Load()
+		next := head.next.Load()
+		if next == nil || atomic.LoadInt32(&next.state) != segmentDone {
+			break
+		}
+		if df.head.CompareAndSwap(head, next) {
+			advanced = true
+		}
+	}
+	if !advanced || atomic.LoadInt32(&df.waiters) == 0 {
+		return
+	}
+	// 这里的加锁是为了保证不会在读操作检查已提交的偏移量之后、调用Wait方法之前发送通知，否则这个通知将会丢失
+	df.fMutex.Lock()
+	df.rCond.Broadcast()
+	df.fMutex.Unlock()
+}
+
 /**
 这里读取需要加锁的原因是：在32位计算机上对64位整数进行操作的时候存在并发安全问题。
 原因就是：线程切换带来的原子性问题。
@@ -131,10 +312,30 @@ func (df *myDataFile) Rsn() int64 {
 }
 
 func (df *myDataFile) Wsn() int64 {
-	offset := atomic.LoadInt64(&df.wOffset)
-	return offset / int64(df.dataLen)
+	return df.last().end() / int64(df.dataLen)
 }
 
 func (df *myDataFile) DataLen() uint32 {
 	return df.dataLen
 }
+
+// 计算一次批量读取的数据块的数量，它既不能超过max，也不能超过缓冲区所能容纳的数据块的数量
+func batchLen(max int, buf []byte, dataLen uint32) int {
+	n := len(buf) / int(dataLen)
+	if max < n {
+		n = max
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// 把多个数据块拼接成一个缓冲区，每个数据块都占用dataLen个字节，超出的部分会被截断，不足的部分以0填充
+func packBatch(ds []Data, dataLen uint32) []byte {
+	bytes := make([]byte, len(ds)*int(dataLen))
+	for i, d := range ds {
+		copy(bytes[i*int(dataLen):(i+1)*int(dataLen)], d)
+	}
+	return bytes
+}
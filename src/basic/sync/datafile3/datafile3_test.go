@@ -0,0 +1,54 @@
+package datafile3
+
+import (
+	"basic/sync/datafiletest"
+	"testing"
+)
+
+// 把DataFile适配成datafiletest.DataFile，通过共同的测试套件验证它遵守了数据文件的约定
+type adapter struct{ DataFile }
+
+func newAdapter(path string, dataLen uint32) (datafiletest.DataFile, error) {
+	df, err := NewDataFile(path, dataLen)
+	if err != nil {
+		return nil, err
+	}
+	return adapter{df}, nil
+}
+
+func (df adapter) Read() (int64, []byte, error) { return df.DataFile.Read() }
+
+func (df adapter) Write(d []byte) (int64, error) { return df.DataFile.Write(d) }
+
+func (df adapter) WriteBatch(ds [][]byte) (int64, error) {
+	batch := make([]Data, len(ds))
+	for i, d := range ds {
+		batch[i] = d
+	}
+	return df.DataFile.WriteBatch(batch)
+}
+
+func TestConformance(t *testing.T) {
+	datafiletest.TestDataFile(t, newAdapter)
+}
+
+func TestHooks(t *testing.T) {
+	datafiletest.TestHooks(t, newAdapter, datafiletest.Hooks{
+		SetWrite: func(hook func(offset int64) error) {
+			if hook == nil {
+				hook = func(offset int64) error { return nil }
+			}
+			testHookWrite = hook
+		},
+		ErrNotWritten: ErrNotWritten,
+	})
+}
+
+func TestStress(t *testing.T) {
+	datafiletest.Stress(t, newAdapter, datafiletest.FlagStressConfig())
+}
+
+// go test -run=none -bench=. -benchmem basic/sync/datafile3
+func Benchmark(b *testing.B) {
+	datafiletest.BenchmarkDataFile(b, newAdapter)
+}
@@ -0,0 +1,371 @@
+//go:build linux
+
+package datafile4
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+/**
+基于内存映射（mmap）的数据文件。
+把文件映射到进程的地址空间之后，读写数据块就变成了对内存的读写，不再需要为每个数据块都进行一次ReadAt/WriteAt系统调用，这对于读多写少的场景非常有利。
+文件会随着写入的数据增长，每次增长一个映射段。已经建立的映射段在数据文件被关闭之前永远不会被解除映射，所以通过ReadNoCopy方法得到的数据块
+在Close之前一直是有效的。
+为了保证数据块不会跨越两个映射段，映射段的长度总是数据块长度和内存页大小的公倍数。
+*/
+
+// 数据文件的接口类型
+type DataFile interface {
+	// 读取一个数据块，它因为写入失败而不存在时返回ErrNotWritten
+	Read() (rsn int64, d Data, err error)
+	// 读取一个数据块，但不复制数据。返回的数据块直接引用了映射的内存，调用方不能修改它，并且在数据文件被关闭之后不能再使用它
+	ReadNoCopy() (rsn int64, d Data, err error)
+	// 写入一个数据块
+	Write(d Data) (wsn int64, err error)
+	// 批量读取数据块，最多读取max个连续的数据块并依次填充到调用方提供的缓冲区buf中，返回第一个数据块的序列号和实际读取的数据块数量。
+	// 第i个数据块位于buf[i*DataLen():(i+1)*DataLen()]，buf的长度决定了本次最多能读取多少个数据块。其中有写入失败的数据块时返回ErrNotWritten
+	ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error)
+	// 批量写入数据块，这些数据块会占用连续的偏移量，返回第一个数据块的序列号
+	WriteBatch(ds []Data) (firstWsn int64, err error)
+	// 获取最后读取的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被读取的数据块的数量
+	Rsn() int64
+	// 获取最后写入的数据块的序列号,这里所说的序列号相当于一个计数值，从1开始，得到当前已被写入的数据块的数量
+	Wsn() int64
+	// 获取数据块的长度
+	DataLen() uint32
+	// 关闭数据文件。它会解除所有的内存映射，并把文件截断为已提交的数据的长度
+	Close() error
+}
+
+// 映射段的最小长度
+const minSegmentLen = 1 << 20
+
+// 数据文件已被关闭时返回的错误
+var ErrClosed = errors.New("data file closed")
+
+// 数据块因为写入失败而不存在时返回的错误
+var ErrNotWritten = errors.New("data block not written")
+
+// 数据文件的实现类型
+type myDataFile struct {
+	// 文件
+	f *os.File
+	// 被用于映射段、已提交偏移量和关闭状态的读写锁
+	fMutex sync.RWMutex
+	// 读操作需要用到的条件变量
+	rCond *sync.Cond
+	// 已建立的映射段，第i个映射段对应文件中[i*segLen, (i+1)*segLen)的部分
+	segments [][]byte
+	// 映射段的长度
+	segLen int64
+	// 写操作需要用到的偏移量
+	wOffset int64
+	// 读操作需要用到的偏移量
+	rOffset int64
+	// 已提交的偏移量，在它之前的数据块都已被完整地写入，读操作只会读取这个偏移量之前的数据块
+	cOffset int64
+	// 已写入但还未提交的数据块，键是偏移量，值是长度
+	pending map[int64]int64
+	// 写入失败的数据块，键是偏移量，值是长度。它们同样会被提交，但是读操作读到它们时会返回ErrNotWritten
+	failed map[int64]int64
+	// 数据文件是否已被关闭
+	closed bool
+	// 数据块长度
+	dataLen uint32
+}
+
+type Data []byte
+
+// 在写操作预留偏移量之后、真正写入之前调用，测试通过它来控制多个写操作完成的先后顺序，它返回错误时模拟写入失败
+var testHookWrite = func(offset int64) error { return nil }
+
+func NewDataFile(path string, dataLen uint32) (DataFile, error) {
+	if dataLen == 0 {
+		return nil, errors.New("invalid data length")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	df := &myDataFile{
+		f:       f,
+		segLen:  segmentLen(int64(dataLen), int64(os.Getpagesize())),
+		pending: make(map[int64]int64),
+		failed:  make(map[int64]int64),
+		dataLen: dataLen,
+	}
+	df.rCond = sync.NewCond(df.fMutex.RLocker())
+	return df, nil
+}
+
+// 计算映射段的长度：数据块长度和内存页大小的最小公倍数，并且不小于minSegmentLen
+func segmentLen(dataLen int64, pageSize int64) int64 {
+	a, b := dataLen, pageSize
+	for b != 0 {
+		a, b = b, a%b
+	}
+	l := dataLen / a * pageSize
+	if l < minSegmentLen {
+		l *= (minSegmentLen + l - 1) / l
+	}
+	return l
+}
+
+func (df *myDataFile) Read() (rsn int64, d Data, err error) {
+	rsn, d, err = df.ReadNoCopy()
+	if err != nil {
+		return
+	}
+	// 复制一份，使调用方可以随意使用返回的数据块
+	d = append(Data(nil), d...)
+	return
+}
+
+func (df *myDataFile) ReadNoCopy() (rsn int64, d Data, err error) {
+	var offset int64
+	for {
+		offset = atomic.LoadInt64(&df.rOffset)
+		if atomic.CompareAndSwapInt64(&df.rOffset, offset, offset+int64(df.dataLen)) {
+			break
+		}
+	}
+	rsn = offset / int64(df.dataLen)
+	df.fMutex.RLock()
+	defer df.fMutex.RUnlock()
+	if err = df.waitCommitted(offset, offset+int64(df.dataLen)); err != nil {
+		return
+	}
+	// 映射段的长度是数据块长度的整数倍，所以一个数据块不会跨越两个映射段。这里限制了切片的容量，以免调用方通过append覆盖后面的数据块
+	seg := df.segments[offset/df.segLen]
+	start := offset % df.segLen
+	end := start + int64(df.dataLen)
+	d = seg[start:end:end]
+	return
+}
+
+func (df *myDataFile) Write(d Data) (wsn int64, err error) {
+	return df.WriteBatch([]Data{d})
+}
+
+func (df *myDataFile) ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error) {
+	n = len(buf) / int(df.dataLen)
+	if max < n {
+		n = max
+	}
+	if n <= 0 {
+		err = errors.New("invalid batch size or buffer length")
+		return
+	}
+	// 通过CAS一次预留连续的数据块。为了不让当前读操作为了凑满一批数据而长时间阻塞，最多只预留已被写入的数据块的数量（至少预留一个）
+	var offset int64
+	var size int64
+	for {
+		offset = atomic.LoadInt64(&df.rOffset)
+		count := n
+		if available := int((atomic.LoadInt64(&df.wOffset) - offset) / int64(df.dataLen)); available < count {
+			count = available
+		}
+		if count < 1 {
+			count = 1
+		}
+		size = int64(count) * int64(df.dataLen)
+		if atomic.CompareAndSwapInt64(&df.rOffset, offset, offset+size) {
+			n = count
+			break
+		}
+	}
+	firstRsn = offset / int64(df.dataLen)
+	df.fMutex.RLock()
+	defer df.fMutex.RUnlock()
+	if err = df.waitCommitted(offset, offset+size); err != nil {
+		return
+	}
+	df.copyOut(buf[:size], offset)
+	return
+}
+
+func (df *myDataFile) WriteBatch(ds []Data) (firstWsn int64, err error) {
+	if len(ds) == 0 {
+		err = errors.New("empty data batch")
+		return
+	}
+	// 通过CAS一次预留连续的偏移量，保证同一批数据块不会与其它写操作的数据块穿插
+	size := int64(len(ds)) * int64(df.dataLen)
+	var offset int64
+	for {
+		offset = atomic.LoadInt64(&df.wOffset)
+		if atomic.CompareAndSwapInt64(&df.wOffset, offset, offset+size) {
+			break
+		}
+	}
+	firstWsn = offset / int64(df.dataLen)
+	if err = testHookWrite(offset); err == nil {
+		err = df.grow(offset + size)
+	}
+	if err != nil {
+		df.commit(offset, size, true)
+		return
+	}
+	// 多个写操作可以同时持有读锁，并发地把数据块复制到映射的内存中
+	df.fMutex.RLock()
+	if df.closed {
+		err = ErrClosed
+	} else {
+		for i, d := range ds {
+			// 超出数据块长度的部分会被截断。每个偏移量只会被写入一次，而扩展出来的文件内容都是0，所以不足的部分自然就以0填充了
+			if len(d) > int(df.dataLen) {
+				d = d[:df.dataLen]
+			}
+			df.copyIn(d, offset+int64(i)*int64(df.dataLen))
+		}
+	}
+	df.fMutex.RUnlock()
+	df.commit(offset, size, err != nil)
+	return
+}
+
+// 等待直到end之前的数据都已被提交，并检查[offset, end)中的数据块是否都被成功地写入了，调用方必须持有读锁
+func (df *myDataFile) waitCommitted(offset int64, end int64) error {
+	for end > df.cOffset {
+		if df.closed {
+			return ErrClosed
+		}
+		// 等待直到写操作发送通知唤醒
+		df.rCond.Wait()
+	}
+	if df.closed {
+		return ErrClosed
+	}
+	// 写入失败的数据块也会被提交。不能只检查它是否已被映射：后面的写操作扩展文件之后，它对应的部分虽然被映射了，但内容都是0
+	for start, size := range df.failed {
+		if start < end && offset < start+size {
+			return ErrNotWritten
+		}
+	}
+	return nil
+}
+
+// 确保文件和映射段足以容纳end之前的数据
+func (df *myDataFile) grow(end int64) error {
+	df.fMutex.RLock()
+	enough := int64(len(df.segments))*df.segLen >= end
+	df.fMutex.RUnlock()
+	if enough {
+		return nil
+	}
+	df.fMutex.Lock()
+	defer df.fMutex.Unlock()
+	if df.closed {
+		return ErrClosed
+	}
+	// 加锁之后需要再检查一次，其它写操作可能已经扩展过了
+	count := int64(len(df.segments))
+	newCount := (end + df.segLen - 1) / df.segLen
+	if count >= newCount {
+		return nil
+	}
+	if err := df.f.Truncate(newCount * df.segLen); err != nil {
+		return err
+	}
+	for i := count; i < newCount; i++ {
+		seg, err := syscall.Mmap(int(df.f.Fd()), i*df.segLen, int(df.segLen),
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			return os.NewSyscallError("mmap", err)
+		}
+		df.segments = append(df.segments, seg)
+	}
+	return nil
+}
+
+// 把p复制到映射的内存中从offset开始的位置，p可能跨越多个映射段
+func (df *myDataFile) copyIn(p []byte, offset int64) {
+	for len(p) > 0 {
+		seg := df.segments[offset/df.segLen]
+		n := copy(seg[offset%df.segLen:], p)
+		p = p[n:]
+		offset += int64(n)
+	}
+}
+
+// 把映射的内存中从offset开始的数据复制到p中，p可能跨越多个映射段
+func (df *myDataFile) copyOut(p []byte, offset int64) {
+	for len(p) > 0 {
+		seg := df.segments[offset/df.segLen]
+		n := copy(p, seg[offset%df.segLen:])
+		p = p[n:]
+		offset += int64(n)
+	}
+}
+
+/**
+提交一段已写入的数据。多个写操作是并发地写入的，它们完成的顺序与预留偏移量的顺序不一定相同，所以这里先把已写入的数据记录下来，
+只有当它前面的数据都已被提交之后才会推进已提交的偏移量。写入失败的数据也要提交，否则后面的数据块将永远不能被读取，
+但是它会被记录下来，读操作读到它时返回ErrNotWritten，而不是读到一个空洞。
+*/
+func (df *myDataFile) commit(offset int64, size int64, failed bool) {
+	df.fMutex.Lock()
+	defer df.fMutex.Unlock()
+	df.pending[offset] = size
+	if failed {
+		df.failed[offset] = size
+	}
+	advanced := false
+	for {
+		size, ok := df.pending[df.cOffset]
+		if !ok {
+			break
+		}
+		delete(df.pending, df.cOffset)
+		df.cOffset += size
+		advanced = true
+	}
+	if advanced {
+		// 等待的读操作读取的数据块各不相同，所以需要广播通知
+		df.rCond.Broadcast()
+	}
+}
+
+func (df *myDataFile) Rsn() int64 {
+	offset := atomic.LoadInt64(&df.rOffset)
+	return offset / int64(df.dataLen)
+}
+
+func (df *myDataFile) Wsn() int64 {
+	offset := atomic.LoadInt64(&df.wOffset)
+	return offset / int64(df.dataLen)
+}
+
+func (df *myDataFile) DataLen() uint32 {
+	return df.dataLen
+}
+
+func (df *myDataFile) Close() error {
+	df.fMutex.Lock()
+	defer df.fMutex.Unlock()
+	if df.closed {
+		return ErrClosed
+	}
+	df.closed = true
+	// 唤醒所有正在等待的读操作，它们会返回ErrClosed
+	df.rCond.Broadcast()
+	var err error
+	for _, seg := range df.segments {
+		if e := syscall.Munmap(seg); e != nil && err == nil {
+			err = os.NewSyscallError("munmap", e)
+		}
+	}
+	df.segments = nil
+	// 去掉映射段末尾还未被使用的部分
+	if e := df.f.Truncate(df.cOffset); e != nil && err == nil {
+		err = e
+	}
+	if e := df.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+//go:build linux
+
+package datafile4
+
+import (
+	"basic/sync/datafiletest"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testDataLen = 64
+
+// 把DataFile适配成datafiletest.DataFile，通过共同的测试套件验证它遵守了数据文件的约定
+type adapter struct{ DataFile }
+
+func newAdapter(path string, dataLen uint32) (datafiletest.DataFile, error) {
+	df, err := NewDataFile(path, dataLen)
+	if err != nil {
+		return nil, err
+	}
+	return adapter{df}, nil
+}
+
+func (df adapter) Read() (int64, []byte, error) { return df.DataFile.Read() }
+
+func (df adapter) Write(d []byte) (int64, error) { return df.DataFile.Write(d) }
+
+func (df adapter) WriteBatch(ds [][]byte) (int64, error) {
+	batch := make([]Data, len(ds))
+	for i, d := range ds {
+		batch[i] = d
+	}
+	return df.DataFile.WriteBatch(batch)
+}
+
+func TestConformance(t *testing.T) {
+	datafiletest.TestDataFile(t, newAdapter)
+}
+
+func TestHooks(t *testing.T) {
+	datafiletest.TestHooks(t, newAdapter, datafiletest.Hooks{
+		SetWrite: func(hook func(offset int64) error) {
+			if hook == nil {
+				hook = func(offset int64) error { return nil }
+			}
+			testHookWrite = hook
+		},
+		ErrNotWritten: ErrNotWritten,
+	})
+}
+
+func TestStress(t *testing.T) {
+	datafiletest.Stress(t, newAdapter, datafiletest.FlagStressConfig())
+}
+
+// go test -run=none -bench=. -benchmem basic/sync/datafile4
+func Benchmark(b *testing.B) {
+	datafiletest.BenchmarkDataFile(b, newAdapter)
+}
+
+func newTestDataFile(tb testing.TB) DataFile {
+	df, err := NewDataFile(filepath.Join(tb.TempDir(), "data"), testDataLen)
+	if err != nil {
+		tb.Fatalf("Can not create the data file: %s", err)
+	}
+	tb.Cleanup(func() { df.Close() })
+	return df
+}
+
+func genData(i int) Data {
+	d := make(Data, testDataLen)
+	copy(d, fmt.Sprintf("data-%d", i))
+	return d
+}
+
+func TestReadNoCopy(t *testing.T) {
+	df := newTestDataFile(t)
+	d := genData(0)
+	if _, err := df.Write(d); err != nil {
+		t.Fatalf("ERROR: Write error: %s", err)
+	}
+	rsn, ref, err := df.ReadNoCopy()
+	if err != nil || rsn != 0 || !bytes.Equal(ref, d) {
+		t.Fatalf("ERROR: ReadNoCopy returned (%d, %q, %v), expected (0, %q, nil)", rsn, ref, err, d)
+	}
+	// 返回的数据块的容量被限制为数据块长度，append不会覆盖后面的数据块
+	if cap(ref) != testDataLen {
+		t.Fatalf("ERROR: The capacity of the data block %d is not %d!", cap(ref), testDataLen)
+	}
+}
+
+/**
+写入超过一个映射段的数据，验证映射段的扩展，以及关闭之后文件被截断为已提交的数据的长度、等待中的读操作被唤醒。
+*/
+func TestGrowAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	df, err := NewDataFile(path, testDataLen)
+	if err != nil {
+		t.Fatalf("Can not create the data file: %s", err)
+	}
+	total := minSegmentLen/testDataLen*2 + 10
+	ds := make([]Data, total)
+	for i := range ds {
+		ds[i] = genData(i)
+	}
+	if _, err := df.WriteBatch(ds); err != nil {
+		t.Fatalf("ERROR: WriteBatch error: %s", err)
+	}
+	buf := make([]byte, 1000*testDataLen)
+	for read := 0; read < total; {
+		firstRsn, n, err := df.ReadBatch(total-read, buf)
+		if err != nil {
+			t.Fatalf("ERROR: ReadBatch error: %s", err)
+		}
+		for i := 0; i < n; i++ {
+			if !bytes.Equal(buf[i*testDataLen:(i+1)*testDataLen], ds[firstRsn+int64(i)]) {
+				t.Fatalf("ERROR: The data block %d is wrong!", firstRsn+int64(i))
+			}
+		}
+		read += n
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := df.Read()
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := df.Close(); err != nil {
+		t.Fatalf("ERROR: Close error: %s", err)
+	}
+	if err := <-errs; err != ErrClosed {
+		t.Fatalf("ERROR: The waiting Read returned %v, expected %v", err, ErrClosed)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("ERROR: Can not stat the data file: %s", err)
+	}
+	if fi.Size() != int64(total*testDataLen) {
+		t.Fatalf("ERROR: The size of the data file %d is not %d!", fi.Size(), total*testDataLen)
+	}
+	if _, err := df.Write(ds[0]); err != ErrClosed {
+		t.Fatalf("ERROR: Write after Close returned %v, expected %v", err, ErrClosed)
+	}
+}
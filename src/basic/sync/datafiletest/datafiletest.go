@@ -0,0 +1,607 @@
+package datafiletest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+数据文件的一致性测试套件。
+datafile1、datafile2、datafile3和datafile4各自定义了自己的DataFile接口和Data类型，它们的方法签名因此并不相同。每个包的测试只需要把它们适配成
+这里的DataFile接口，就可以用同一套测试来验证它们是否都遵守了相同的约定，并用同一套基准测试来比较互斥锁、条件变量、原子操作和内存映射这几种实现方式。
+*/
+
+// 被测试的数据文件需要实现的接口，与各个datafile包中的DataFile接口相同，只是数据块的类型统一为[]byte
+type DataFile interface {
+	Read() (rsn int64, d []byte, err error)
+	Write(d []byte) (wsn int64, err error)
+	ReadBatch(max int, buf []byte) (firstRsn int64, n int, err error)
+	WriteBatch(ds [][]byte) (firstWsn int64, err error)
+	Rsn() int64
+	Wsn() int64
+	DataLen() uint32
+}
+
+// 创建被测试的数据文件的函数
+type NewDataFile func(path string, dataLen uint32) (DataFile, error)
+
+// 压力测试的配置
+type StressConfig struct {
+	// 写Goroutine的数量
+	Writers int
+	// 读Goroutine的数量
+	Readers int
+	// 每个写Goroutine写入的数据块的数量
+	Blocks int
+	// 每个写Goroutine每次批量写入的数据块的数量，小于等于1时逐个写入
+	BatchSize int
+	// 数据块的长度
+	DataLen uint32
+}
+
+// 默认的压力测试配置
+var DefaultStressConfig = StressConfig{
+	Writers:   8,
+	Readers:   8,
+	Blocks:    500,
+	BatchSize: 4,
+	DataLen:   64,
+}
+
+/**
+压力测试的规模可以通过命令行标记调整，比如：
+go test basic/sync/datafile3 -run=Stress -writers=32 -readers=64 -blocks=10000 -batch=16
+*/
+var (
+	writers   = flag.Int("writers", DefaultStressConfig.Writers, "the number of writer goroutines in the stress test")
+	readers   = flag.Int("readers", DefaultStressConfig.Readers, "the number of reader goroutines in the stress test")
+	blocks    = flag.Int("blocks", DefaultStressConfig.Blocks, "the number of data blocks written by each writer in the stress test")
+	batchSize = flag.Int("batch", DefaultStressConfig.BatchSize, "the number of data blocks in each write batch in the stress test")
+	dataLen   = flag.Uint("datalen", uint(DefaultStressConfig.DataLen), "the data length in the stress test")
+)
+
+// 由命令行标记决定的压力测试配置，-short时每个写Goroutine只写入50个数据块
+func FlagStressConfig() StressConfig {
+	cfg := StressConfig{
+		Writers:   *writers,
+		Readers:   *readers,
+		Blocks:    *blocks,
+		BatchSize: *batchSize,
+		DataLen:   uint32(*dataLen),
+	}
+	if testing.Short() {
+		cfg.Blocks = 50
+	}
+	return cfg
+}
+
+const testDataLen = 64
+
+func newDataFile(tb testing.TB, newFunc NewDataFile, dataLen uint32) DataFile {
+	df, err := newFunc(filepath.Join(tb.TempDir(), "data"), dataLen)
+	if err != nil {
+		tb.Fatalf("Can not create the data file: %s", err)
+	}
+	// 有的实现（比如基于内存映射的实现）需要被关闭
+	if closer, ok := df.(io.Closer); ok {
+		tb.Cleanup(func() { closer.Close() })
+	}
+	return df
+}
+
+// 生成一个可以通过内容识别出它是第几个数据块的数据块
+func genData(id int, dataLen uint32) []byte {
+	d := make([]byte, dataLen)
+	copy(d, fmt.Sprintf("block-%d", id))
+	return d
+}
+
+// 对数据文件运行全部的一致性测试
+func TestDataFile(t *testing.T, newFunc NewDataFile) {
+	t.Run("SequenceNumbers", func(t *testing.T) { testSequenceNumbers(t, newFunc) })
+	t.Run("Payload", func(t *testing.T) { testPayload(t, newFunc) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, newFunc) })
+	t.Run("BlockOnEOF", func(t *testing.T) { testBlockOnEOF(t, newFunc) })
+	t.Run("NoDuplicateOrSkippedReads", func(t *testing.T) { testNoDuplicateOrSkippedReads(t, newFunc) })
+}
+
+// 序列号从0开始，每次读写都会使其加1
+func testSequenceNumbers(t *testing.T, newFunc NewDataFile) {
+	df := newDataFile(t, newFunc, testDataLen)
+	if df.DataLen() != testDataLen {
+		t.Fatalf("ERROR: The data length %d is not %d!", df.DataLen(), testDataLen)
+	}
+	if df.Wsn() != 0 || df.Rsn() != 0 {
+		t.Fatalf("ERROR: The initial (wsn, rsn) is (%d, %d), expected (0, 0)!", df.Wsn(), df.Rsn())
+	}
+	for i := 0; i < 10; i++ {
+		wsn, err := df.Write(genData(i, testDataLen))
+		if err != nil || wsn != int64(i) {
+			t.Fatalf("ERROR: Write returned (%d, %v), expected (%d, nil)", wsn, err, i)
+		}
+		if df.Wsn() != int64(i+1) {
+			t.Fatalf("ERROR: The wsn %d is not %d!", df.Wsn(), i+1)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		rsn, _, err := df.Read()
+		if err != nil || rsn != int64(i) {
+			t.Fatalf("ERROR: Read returned (%d, %v), expected (%d, nil)", rsn, err, i)
+		}
+		if df.Rsn() != int64(i+1) {
+			t.Fatalf("ERROR: The rsn %d is not %d!", df.Rsn(), i+1)
+		}
+	}
+}
+
+// 读到的数据块与写入的数据块一致，过长的数据会被截断，过短的数据会以0填充
+func testPayload(t *testing.T, newFunc NewDataFile) {
+	df := newDataFile(t, newFunc, testDataLen)
+	full := genData(0, testDataLen)
+	long := append(genData(1, testDataLen), "overflow"...)
+	short := []byte("short")
+	for _, d := range [][]byte{full, long, short} {
+		if _, err := df.Write(d); err != nil {
+			t.Fatalf("ERROR: Write error: %s", err)
+		}
+	}
+	expected := [][]byte{full, long[:testDataLen], append(short, make([]byte, testDataLen-len(short))...)}
+	for i, e := range expected {
+		_, d, err := df.Read()
+		if err != nil {
+			t.Fatalf("ERROR: Read error: %s", err)
+		}
+		if !bytes.Equal(d, e) {
+			t.Fatalf("ERROR: The data block %d is %q, expected %q!", i, d, e)
+		}
+	}
+}
+
+// 批量读写与逐个读写可以混合使用，序列号和数据块都是连续的
+func testBatch(t *testing.T, newFunc NewDataFile) {
+	df := newDataFile(t, newFunc, testDataLen)
+	total := 20
+	ds := make([][]byte, total)
+	for i := range ds {
+		ds[i] = genData(i, testDataLen)
+	}
+	if _, err := df.Write(ds[0]); err != nil {
+		t.Fatalf("ERROR: Write error: %s", err)
+	}
+	firstWsn, err := df.WriteBatch(ds[1:])
+	if err != nil || firstWsn != 1 {
+		t.Fatalf("ERROR: WriteBatch returned (%d, %v), expected (1, nil)", firstWsn, err)
+	}
+	if _, err := df.WriteBatch(nil); err == nil {
+		t.Fatal("ERROR: WriteBatch should fail with an empty batch!")
+	}
+	if _, _, err := df.ReadBatch(1, make([]byte, testDataLen-1)); err == nil {
+		t.Fatal("ERROR: ReadBatch should fail with a buffer smaller than one data block!")
+	}
+	_, d, err := df.Read()
+	if err != nil || !bytes.Equal(d, ds[0]) {
+		t.Fatalf("ERROR: Read returned (%q, %v), expected (%q, nil)", d, err, ds[0])
+	}
+	buf := make([]byte, 3*testDataLen)
+	for rsn := int64(1); rsn < int64(total); {
+		firstRsn, n, err := df.ReadBatch(100, buf)
+		if err != nil {
+			t.Fatalf("ERROR: ReadBatch error: %s", err)
+		}
+		if firstRsn != rsn || n < 1 || n > 3 {
+			t.Fatalf("ERROR: ReadBatch returned (%d, %d), expected (%d, 1~3)", firstRsn, n, rsn)
+		}
+		for i := 0; i < n; i++ {
+			if d := buf[i*testDataLen : (i+1)*testDataLen]; !bytes.Equal(d, ds[rsn+int64(i)]) {
+				t.Fatalf("ERROR: The data block %d is %q, expected %q!", rsn+int64(i), d, ds[rsn+int64(i)])
+			}
+		}
+		rsn += int64(n)
+	}
+}
+
+// 没有可读的数据块时，读操作会一直等待，直到有数据块被写入
+func testBlockOnEOF(t *testing.T, newFunc NewDataFile) {
+	df := newDataFile(t, newFunc, testDataLen)
+	type result struct {
+		rsn int64
+		d   []byte
+		err error
+	}
+	results := make(chan result, 2)
+	go func() {
+		rsn, d, err := df.Read()
+		results <- result{rsn, d, err}
+	}()
+	go func() {
+		buf := make([]byte, 4*testDataLen)
+		rsn, n, err := df.ReadBatch(4, buf)
+		results <- result{rsn, buf[:n*testDataLen], err}
+	}()
+	select {
+	case r := <-results:
+		t.Fatalf("ERROR: Read returned (%d, %q, %v) from an empty data file!", r.rsn, r.d, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ds := [][]byte{genData(0, testDataLen), genData(1, testDataLen)}
+	for _, d := range ds {
+		if _, err := df.Write(d); err != nil {
+			t.Fatalf("ERROR: Write error: %s", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil || len(r.d) != testDataLen || !bytes.Equal(r.d, ds[r.rsn]) {
+				t.Fatalf("ERROR: Read returned (%d, %q, %v) after the data block is written!", r.rsn, r.d, r.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ERROR: Read is still blocked after the data block is written!")
+		}
+	}
+}
+
+// 多个读操作并发地读取时，每个数据块都只会被读取一次
+func testNoDuplicateOrSkippedReads(t *testing.T, newFunc NewDataFile) {
+	Stress(t, newFunc, StressConfig{Writers: 1, Readers: 8, Blocks: 400, BatchSize: 1, DataLen: testDataLen})
+}
+
+// 通过包内部的测试钩子进行的测试所需要的信息
+type Hooks struct {
+	// 设置写操作的钩子，它在写操作预留偏移量之后、真正写入之前被调用，返回错误时模拟写入失败。hook为nil时恢复默认的钩子
+	SetWrite func(hook func(offset int64) error)
+	// 读取写入失败的数据块时返回的错误
+	ErrNotWritten error
+}
+
+// 对数据文件运行需要测试钩子的测试
+func TestHooks(t *testing.T, newFunc NewDataFile, hooks Hooks) {
+	t.Run("WriteOrder", func(t *testing.T) { testWriteOrder(t, newFunc, hooks) })
+	t.Run("WriteFailure", func(t *testing.T) { testWriteFailure(t, newFunc, hooks) })
+}
+
+/**
+让先预留偏移量的写操作最后完成：后面的数据块已经写入之后，读取前面的数据块的操作既不能读到空洞，也不能读到后面的数据块。
+*/
+func testWriteOrder(t *testing.T, newFunc NewDataFile, hooks Hooks) {
+	df := newDataFile(t, newFunc, testDataLen)
+	release := make(chan struct{})
+	hooks.SetWrite(func(offset int64) error {
+		if offset == 0 {
+			<-release
+		}
+		return nil
+	})
+	defer hooks.SetWrite(nil)
+
+	type result struct {
+		sn  int64
+		d   []byte
+		err error
+	}
+	first, second := genData(0, testDataLen), genData(1, testDataLen)
+	firstResult := make(chan result, 1)
+	go func() {
+		wsn, err := df.Write(first)
+		firstResult <- result{sn: wsn, err: err}
+	}()
+	// 等待第一个写操作预留偏移量
+	for df.Wsn() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// 第二个写操作返回时它的数据块已经被写入了，此时第一个数据块还是一个空洞
+	wsn, err := df.Write(second)
+	if err != nil || wsn != 1 {
+		t.Fatalf("ERROR: Write returned (%d, %v), expected (1, nil)", wsn, err)
+	}
+	readResult := make(chan result, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			rsn, d, err := df.Read()
+			readResult <- result{rsn, d, err}
+		}
+	}()
+	select {
+	case r := <-readResult:
+		t.Fatalf("ERROR: Read the data block %d (%q) before it is written!", r.sn, r.d)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if r := <-firstResult; r.err != nil || r.sn != 0 {
+		t.Fatalf("ERROR: Write returned (%d, %v), expected (0, nil)", r.sn, r.err)
+	}
+	for i, expected := range [][]byte{first, second} {
+		r := <-readResult
+		if r.err != nil || r.sn != int64(i) || !bytes.Equal(r.d, expected) {
+			t.Fatalf("ERROR: Read returned (%d, %q, %v), expected (%d, %q, nil)", r.sn, r.d, r.err, i, expected)
+		}
+	}
+}
+
+/**
+写入失败的数据块也会被提交，后面的数据块仍然可以被读取，但是读取失败的数据块时返回ErrNotWritten，而不是读到一个空洞。
+*/
+func testWriteFailure(t *testing.T, newFunc NewDataFile, hooks Hooks) {
+	df := newDataFile(t, newFunc, testDataLen)
+	failure := errors.New("injected failure")
+	hooks.SetWrite(func(offset int64) error {
+		if offset == testDataLen || offset == 3*testDataLen {
+			return failure
+		}
+		return nil
+	})
+	defer hooks.SetWrite(nil)
+
+	for i := 0; i < 3; i++ {
+		wsn, err := df.Write(genData(i, testDataLen))
+		if expected := i == 1; (err == failure) != expected || wsn != int64(i) {
+			t.Fatalf("ERROR: Write returned (%d, %v) for the data block %d", wsn, err, i)
+		}
+	}
+	if _, err := df.WriteBatch([][]byte{genData(3, testDataLen), genData(4, testDataLen)}); err != failure {
+		t.Fatalf("ERROR: WriteBatch returned %v, expected %v", err, failure)
+	}
+	if _, err := df.Write(genData(5, testDataLen)); err != nil {
+		t.Fatalf("ERROR: Write error: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		rsn, d, err := df.Read()
+		if i == 1 {
+			if rsn != 1 || err != hooks.ErrNotWritten {
+				t.Fatalf("ERROR: Read returned (%d, %q, %v), expected (1, nil, %v)", rsn, d, err, hooks.ErrNotWritten)
+			}
+			continue
+		}
+		if expected := genData(i, testDataLen); rsn != int64(i) || err != nil || !bytes.Equal(d, expected) {
+			t.Fatalf("ERROR: Read returned (%d, %q, %v), expected (%d, %q, nil)", rsn, d, err, i, expected)
+		}
+	}
+	buf := make([]byte, 2*testDataLen)
+	if firstRsn, n, err := df.ReadBatch(2, buf); firstRsn != 3 || n != 2 || err != hooks.ErrNotWritten {
+		t.Fatalf("ERROR: ReadBatch returned (%d, %d, %v), expected (3, 2, %v)", firstRsn, n, err, hooks.ErrNotWritten)
+	}
+	if rsn, d, err := df.Read(); rsn != 5 || err != nil || !bytes.Equal(d, genData(5, testDataLen)) {
+		t.Fatalf("ERROR: Read returned (%d, %q, %v), expected (5, %q, nil)", rsn, d, err, genData(5, testDataLen))
+	}
+}
+
+/**
+压力测试：多个写Goroutine和多个读Goroutine并发地读写同一个数据文件。
+读Goroutine交替地逐个读取和批量读取，最后检查每个序列号都恰好被写入和读取了一次，并且读到的数据块正是以该序列号写入的数据块。
+*/
+func Stress(t *testing.T, newFunc NewDataFile, cfg StressConfig) {
+	if cfg.Writers < 1 || cfg.Readers < 1 || cfg.Blocks < 1 || cfg.DataLen == 0 {
+		t.Fatalf("Invalid stress config: %+v", cfg)
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	df := newDataFile(t, newFunc, cfg.DataLen)
+	total := cfg.Writers * cfg.Blocks
+	written := make([][]byte, total)
+	read := make([][]byte, total)
+	var mutex sync.Mutex
+	record := func(dest [][]byte, sn int64, d []byte) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if sn < 0 || sn >= int64(total) {
+			t.Errorf("ERROR: The sequence number %d is out of range [0, %d)!", sn, total)
+			return false
+		}
+		if dest[sn] != nil {
+			t.Errorf("ERROR: The sequence number %d is used twice!", sn)
+			return false
+		}
+		dest[sn] = d
+		return true
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(cfg.Writers)
+	for w := 0; w < cfg.Writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < cfg.Blocks; i += cfg.BatchSize {
+				n := cfg.Blocks - i
+				if n > cfg.BatchSize {
+					n = cfg.BatchSize
+				}
+				ds := make([][]byte, n)
+				for j := range ds {
+					ds[j] = genData(w*cfg.Blocks+i+j, cfg.DataLen)
+				}
+				var firstWsn int64
+				var err error
+				if n == 1 {
+					firstWsn, err = df.Write(ds[0])
+				} else {
+					firstWsn, err = df.WriteBatch(ds)
+				}
+				if err != nil {
+					t.Errorf("ERROR: Write error: %s", err)
+					return
+				}
+				for j, d := range ds {
+					if !record(written, firstWsn+int64(j), d) {
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	// 读操作预留的数据块数量是不确定的，所以由读Goroutine共同认领剩余的数据块数量，以免有读操作因为等待永远不会被写入的数据块而被阻塞
+	remaining := total
+	claim := func(max int) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if max > remaining {
+			max = remaining
+		}
+		remaining -= max
+		return max
+	}
+	unclaim := func(n int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		remaining += n
+	}
+	wg.Add(cfg.Readers)
+	for r := 0; r < cfg.Readers; r++ {
+		go func(r int) {
+			defer wg.Done()
+			buf := make([]byte, 4*int(cfg.DataLen))
+			for round := 0; ; round++ {
+				if round%2 == 0 || r%2 == 0 {
+					if claim(1) == 0 {
+						return
+					}
+					rsn, d, err := df.Read()
+					if err != nil {
+						t.Errorf("ERROR: Read error: %s", err)
+						return
+					}
+					if !record(read, rsn, d) {
+						return
+					}
+					continue
+				}
+				max := claim(4)
+				if max == 0 {
+					return
+				}
+				firstRsn, n, err := df.ReadBatch(max, buf)
+				if err != nil {
+					t.Errorf("ERROR: ReadBatch error: %s", err)
+					return
+				}
+				// 实际读取的数据块可能比认领的少，把多认领的归还
+				unclaim(max - n)
+				for i := 0; i < n; i++ {
+					d := append([]byte(nil), buf[i*int(cfg.DataLen):(i+1)*int(cfg.DataLen)]...)
+					if !record(read, firstRsn+int64(i), d) {
+						return
+					}
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	for sn := 0; sn < total; sn++ {
+		if read[sn] == nil {
+			t.Fatalf("ERROR: The data block %d is skipped!", sn)
+		}
+		if !bytes.Equal(read[sn], written[sn]) {
+			t.Fatalf("ERROR: The data block %d is %q, expected %q!", sn, read[sn], written[sn])
+		}
+	}
+	if df.Wsn() != int64(total) || df.Rsn() != int64(total) {
+		t.Fatalf("ERROR: The (wsn, rsn) is (%d, %d), expected (%d, %d)!", df.Wsn(), df.Rsn(), total, total)
+	}
+}
+
+/**
+基准测试：通过RunParallel让多个Goroutine同时写入或读取同一个数据文件，这样才能体现出不同的同步方式在竞争下的差异；
+WriteBatch和ReadBatch每次操作一批数据块，与逐个读写对比。每个数据块算作一次操作。
+比较各个实现时把它们放在同一次运行中：
+go test -run=none -bench=. -cpu=1,8 basic/sync/datafile1 basic/sync/datafile2 basic/sync/datafile3 basic/sync/datafile4
+datafile1和datafile2用互斥锁预留偏移量、提交数据；datafile3的写操作则完全不加锁，预留和提交都是对无锁队列的CAS，
+只有在有读操作等待时才需要加锁发送通知，所以-cpu大于1时的Write结果衡量的正是原子操作与锁的差别。不过每次读写的大部分时间都花在了ReadAt/WriteAt
+系统调用上，这个差别只占很小的一部分；去掉了系统调用的datafile4则不在同一个量级上。结果与机器有关，请在本机上运行后再下结论。
+*/
+func BenchmarkDataFile(b *testing.B, newFunc NewDataFile) {
+	b.Run("Write", func(b *testing.B) {
+		df := newDataFile(b, newFunc, testDataLen)
+		d := genData(0, testDataLen)
+		b.SetBytes(testDataLen)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := df.Write(d); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+	b.Run("WriteBatch", func(b *testing.B) {
+		df := newDataFile(b, newFunc, testDataLen)
+		ds := genBatch(benchBatchSize)
+		b.SetBytes(testDataLen)
+		b.ResetTimer()
+		for i := 0; i < b.N; i += benchBatchSize {
+			n := b.N - i
+			if n > benchBatchSize {
+				n = benchBatchSize
+			}
+			if _, err := df.WriteBatch(ds[:n]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Read", func(b *testing.B) {
+		df := newDataFile(b, newFunc, testDataLen)
+		prepare(b, df, b.N)
+		b.SetBytes(testDataLen)
+		b.ResetTimer()
+		// pb.Next()返回true的总次数正好是b.N，所以读操作不会等待永远不会被写入的数据块
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, _, err := df.Read(); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+	b.Run("ReadBatch", func(b *testing.B) {
+		df := newDataFile(b, newFunc, testDataLen)
+		prepare(b, df, b.N)
+		buf := make([]byte, benchBatchSize*testDataLen)
+		b.SetBytes(testDataLen)
+		b.ResetTimer()
+		for i := 0; i < b.N; {
+			_, n, err := df.ReadBatch(b.N-i, buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			i += n
+		}
+	})
+}
+
+// 基准测试中一批数据块的数量
+const benchBatchSize = 64
+
+func genBatch(n int) [][]byte {
+	ds := make([][]byte, n)
+	for i := range ds {
+		ds[i] = genData(i, testDataLen)
+	}
+	return ds
+}
+
+// 预先写入n个数据块，以免读操作因为等待而被阻塞
+func prepare(b *testing.B, df DataFile, n int) {
+	ds := genBatch(1024)
+	for i := 0; i < n; i += len(ds) {
+		m := n - i
+		if m > len(ds) {
+			m = len(ds)
+		}
+		if _, err := df.WriteBatch(ds[:m]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package workerpool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+/**
+有界的工作池，也是一个带权重的信号量。
+channel.go中的fetchPerson用goTicket通道限制同时运行的Goroutine的数量：接收一个票才能启动Goroutine，Goroutine结束时再把票发送回去。
+但是要知道所有的Goroutine都结束了，只能不停地检查len(goTicket)是否等于票的总数，也就是忙等待。
+Pool记录正在运行的任务的数量，最后一个任务结束时通过条件变量唤醒Wait，所以Wait不需要轮询。
+每个任务占用一定的权重，正在运行的任务的权重之和不超过池的大小。等待的任务按照先来先服务的顺序获得权重：
+排在前面的任务权重较大而暂时无法运行时，后面的任务即使权重较小也要等待，以免大的任务被饿死。
+池的大小可以在运行时调整；变小时已经在运行的任务不受影响，新的任务要等到占用的权重降到新的大小以下才能运行。
+权重大于池的大小的任务永远无法运行，提交时立即返回ErrTooHeavy；池变小之后，这样的等待者也以ErrTooHeavy返回，而不是一直等待下去。
+任务中的panic被捕获并转换成*PanicError，不会导致整个进程崩溃。
+*/
+
+var (
+	// 池已经被关闭
+	ErrClosed = errors.New("workerpool: pool closed")
+	// 任务的权重大于池的大小
+	ErrTooHeavy = errors.New("workerpool: weight exceeds the pool size")
+)
+
+// 任务中的panic
+type PanicError struct {
+	// 传给panic的值
+	Value interface{}
+	// 发生panic时的调用栈
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("workerpool: task panicked: %v", e.Value)
+}
+
+// 等待获得权重的调用方
+type waiter struct {
+	weight int
+	// 获得权重之后是否作为一个任务运行
+	task bool
+	// 获得权重或者失败时被关闭
+	ready chan struct{}
+	// 失败的原因：池被关闭或者变得比weight小
+	err error
+}
+
+type Pool struct {
+	mu sync.Mutex
+	// 最后一个任务结束时广播
+	idle    *sync.Cond
+	size    int
+	used    int
+	running int
+	waiters list.List
+	closed  bool
+	panics  []error
+	onPanic func(err *PanicError)
+}
+
+// 工作池的可选项
+type Option func(p *Pool)
+
+// 设置任务panic时的回调函数，它在发生panic的Goroutine中被调用
+func WithPanicHandler(fn func(err *PanicError)) Option {
+	return func(p *Pool) {
+		p.onPanic = fn
+	}
+}
+
+// 创建大小为size的工作池，size必须大于0
+func New(size int, opts ...Option) *Pool {
+	if size <= 0 {
+		panic("workerpool: non-positive size")
+	}
+	p := &Pool{size: size}
+	p.idle = sync.NewCond(&p.mu)
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// 提交一个权重为1的任务，没有空闲的权重时阻塞，直到任务开始运行、ctx被取消或者池被关闭
+func (p *Pool) Submit(ctx context.Context, task func()) error {
+	return p.SubmitWeighted(ctx, 1, task)
+}
+
+/**
+提交一个权重为weight的任务，没有足够的空闲权重时阻塞。返回nil时任务已经在一个新的Goroutine中开始运行了，
+它结束时释放占用的权重。weight大于池的大小时返回ErrTooHeavy
+*/
+func (p *Pool) SubmitWeighted(ctx context.Context, weight int, task func()) error {
+	if err := p.acquire(ctx, weight, true); err != nil {
+		return err
+	}
+	go p.run(weight, task)
+	return nil
+}
+
+// 尝试提交一个权重为1的任务，不阻塞。没有空闲的权重或者池已经被关闭时返回false
+func (p *Pool) TrySubmit(task func()) bool {
+	return p.TrySubmitWeighted(1, task)
+}
+
+// 尝试提交一个权重为weight的任务，不阻塞。weight大于池的大小时返回false
+func (p *Pool) TrySubmitWeighted(weight int, task func()) bool {
+	if !p.tryAcquire(weight, true) {
+		return false
+	}
+	go p.run(weight, task)
+	return true
+}
+
+/**
+像信号量一样获得weight的权重，不运行任务，用完之后必须调用Release释放。
+它与任务共享池的大小，但是不被Wait等待。weight大于池的大小时返回ErrTooHeavy
+*/
+func (p *Pool) Acquire(ctx context.Context, weight int) error {
+	return p.acquire(ctx, weight, false)
+}
+
+// 尝试获得weight的权重，不阻塞
+func (p *Pool) TryAcquire(weight int) bool {
+	return p.tryAcquire(weight, false)
+}
+
+// 释放Acquire获得的权重。释放的权重比获得的多时panic
+func (p *Pool) Release(weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.release(weight)
+}
+
+func (p *Pool) acquire(ctx context.Context, weight int, task bool) error {
+	if weight <= 0 {
+		panic("workerpool: non-positive weight")
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	if weight > p.size {
+		p.mu.Unlock()
+		return ErrTooHeavy
+	}
+	if p.grant(weight, task) {
+		p.mu.Unlock()
+		return nil
+	}
+	w := &waiter{weight: weight, task: task, ready: make(chan struct{})}
+	elem := p.waiters.PushBack(w)
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-w.ready:
+		// 在被取消的同时因为池被关闭或者缩小而失败，这个错误比ctx的错误更能说明原因
+		if w.err != nil {
+			return w.err
+		}
+		// 在被取消的同时获得了权重，把它还回去
+		if task {
+			p.finish()
+		}
+		p.release(weight)
+	default:
+		front := p.waiters.Front() == elem
+		p.waiters.Remove(elem)
+		// 排在最前面的等待者离开之后，后面的等待者也许可以获得权重了
+		if front {
+			p.notify()
+		}
+	}
+	return ctx.Err()
+}
+
+func (p *Pool) tryAcquire(weight int, task bool) bool {
+	if weight <= 0 {
+		panic("workerpool: non-positive weight")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.closed && p.grant(weight, task)
+}
+
+// 没有等待者并且空闲的权重足够时获得权重。调用时必须持有锁
+func (p *Pool) grant(weight int, task bool) bool {
+	if p.waiters.Len() > 0 || p.size-p.used < weight {
+		return false
+	}
+	p.used += weight
+	if task {
+		p.running++
+	}
+	return true
+}
+
+// 按照先来先服务的顺序把空闲的权重分配给等待者。调用时必须持有锁
+func (p *Pool) notify() {
+	for {
+		elem := p.waiters.Front()
+		if elem == nil {
+			return
+		}
+		w := elem.Value.(*waiter)
+		if p.size-p.used < w.weight {
+			return
+		}
+		p.used += w.weight
+		if w.task {
+			p.running++
+		}
+		p.waiters.Remove(elem)
+		close(w.ready)
+	}
+}
+
+// 使等待者离开队列并返回err。调用时必须持有锁
+func (p *Pool) fail(elem *list.Element, err error) {
+	w := p.waiters.Remove(elem).(*waiter)
+	w.err = err
+	close(w.ready)
+}
+
+// 调用时必须持有锁
+func (p *Pool) release(weight int) {
+	p.used -= weight
+	if p.used < 0 {
+		panic("workerpool: released more than held")
+	}
+	p.notify()
+}
+
+// 一个任务结束。调用时必须持有锁
+func (p *Pool) finish() {
+	p.running--
+	if p.running == 0 {
+		p.idle.Broadcast()
+	}
+}
+
+func (p *Pool) run(weight int, task func()) {
+	defer func() {
+		var perr *PanicError
+		if v := recover(); v != nil {
+			perr = &PanicError{Value: v, Stack: debug.Stack()}
+			if p.onPanic != nil {
+				p.onPanic(perr)
+			}
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if perr != nil {
+			p.panics = append(p.panics, perr)
+		}
+		p.release(weight)
+		p.finish()
+	}()
+	task()
+}
+
+/**
+阻塞直到所有已经开始运行的任务都结束。任务在SubmitWeighted返回nil时开始运行，所以在所有的Submit都返回之后调用Wait，
+就能确定所有提交的任务都结束了。
+返回上一次Wait之后发生的所有panic，它们是*PanicError，没有panic时返回nil
+*/
+func (p *Pool) Wait() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.running > 0 {
+		p.idle.Wait()
+	}
+	panics := p.panics
+	p.panics = nil
+	return errors.Join(panics...)
+}
+
+/**
+调整池的大小，size必须大于0。变大时等待的任务可能立即开始运行；变小时权重大于新的大小的等待者返回ErrTooHeavy，
+它们离开队列之后，排在后面的等待者也可能立即开始运行
+*/
+func (p *Pool) Resize(size int) {
+	if size <= 0 {
+		panic("workerpool: non-positive size")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = size
+	for elem := p.waiters.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*waiter).weight > size {
+			p.fail(elem, ErrTooHeavy)
+		}
+		elem = next
+	}
+	p.notify()
+}
+
+// 池的大小
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// 正在运行的任务的数量
+func (p *Pool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+/**
+关闭池：之后的提交和获得权重返回ErrClosed或者false，正在等待的调用方也立即返回ErrClosed。
+已经在运行的任务不受影响，可以继续用Wait等待它们
+*/
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for p.waiters.Len() > 0 {
+		p.fail(p.waiters.Front(), ErrClosed)
+	}
+}
@@ -0,0 +1,257 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 同时运行的任务的权重之和不超过池的大小
+func TestSubmit(t *testing.T) {
+	p := New(3)
+	var current, peak, done int64
+	for i := 0; i < 30; i++ {
+		weight := i%2 + 1
+		err := p.SubmitWeighted(context.Background(), weight, func() {
+			n := atomic.AddInt64(&current, int64(weight))
+			for {
+				old := atomic.LoadInt64(&peak)
+				if n <= old || atomic.CompareAndSwapInt64(&peak, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&current, -int64(weight))
+			atomic.AddInt64(&done, 1)
+		})
+		if err != nil {
+			t.Fatalf("Submit Error: %s", err)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait Error: %s", err)
+	}
+	if done != 30 || peak > 3 || p.Running() != 0 {
+		t.Fatalf("ERROR: %d tasks are done, the peak weight is %d, %d are running", done, peak, p.Running())
+	}
+}
+
+func TestTrySubmit(t *testing.T) {
+	p := New(2)
+	release := make(chan struct{})
+	block := func() { <-release }
+	if !p.TrySubmit(block) || !p.TrySubmit(block) {
+		t.Fatal("ERROR: A task cannot be submitted to a free pool!")
+	}
+	if p.TrySubmit(block) || p.TryAcquire(1) {
+		t.Fatal("ERROR: A task is submitted to a full pool!")
+	}
+	close(release)
+	p.Wait()
+	if !p.TrySubmitWeighted(2, func() {}) {
+		t.Fatal("ERROR: A task cannot be submitted after Wait!")
+	}
+	p.Wait()
+}
+
+// 排在前面的大任务等待时，后面的小任务也要等待
+func TestFIFO(t *testing.T) {
+	p := New(2)
+	if err := p.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan int, 2)
+	go func() {
+		p.Acquire(context.Background(), 2)
+		acquired <- 2
+	}()
+	for p.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if p.TryAcquire(1) {
+		t.Fatal("ERROR: A small acquisition overtakes a waiting large one!")
+	}
+	go func() {
+		p.Acquire(context.Background(), 1)
+		acquired <- 1
+	}()
+	for p.waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Release(1)
+	if first := <-acquired; first != 2 {
+		t.Fatalf("ERROR: The acquisition of %d comes first", first)
+	}
+	p.Release(2)
+	<-acquired
+	p.Release(1)
+}
+
+func TestCancel(t *testing.T) {
+	p := New(1)
+	p.Acquire(context.Background(), 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("ERROR: Submit returns %v", err)
+	}
+	if n := p.waiting(); n != 0 {
+		t.Fatalf("ERROR: %d waiters are left", n)
+	}
+	p.Release(1)
+	if !p.TryAcquire(1) {
+		t.Fatal("ERROR: The weight of a canceled acquisition is leaked!")
+	}
+}
+
+// 在被取消的同时因为池被关闭而失败时返回ErrClosed，而不是ctx的错误
+func TestCancelAndClose(t *testing.T) {
+	p := New(1)
+	p.Acquire(context.Background(), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error)
+	go func() {
+		acquired <- p.Acquire(ctx, 1)
+	}()
+	for p.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 持有锁时取消，等待者发现ctx被取消之后阻塞在锁上，这时再像Close一样使它失败
+	p.mu.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	p.closed = true
+	p.fail(p.waiters.Front(), ErrClosed)
+	p.mu.Unlock()
+	if err := <-acquired; err != ErrClosed {
+		t.Fatalf("ERROR: Acquire returns %v", err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	p := New(1)
+	release := make(chan struct{})
+	var started int64
+	task := func() {
+		atomic.AddInt64(&started, 1)
+		<-release
+	}
+	p.Submit(context.Background(), task)
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.Submit(context.Background(), task)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt64(&started) != 1 {
+		t.Fatal("ERROR: A task is run in a full pool!")
+	}
+	p.Resize(2)
+	if err := <-submitted; err != nil {
+		t.Fatalf("Submit Error: %s", err)
+	}
+	// 变小之后，运行中的任务结束之前不能提交新的任务
+	p.Resize(1)
+	if p.Size() != 1 || p.TrySubmit(task) {
+		t.Fatal("ERROR: A task is submitted to a shrunk pool!")
+	}
+	close(release)
+	p.Wait()
+	if !p.TrySubmit(func() {}) {
+		t.Fatal("ERROR: A task cannot be submitted after the pool is drained!")
+	}
+	p.Wait()
+}
+
+// 权重大于池的大小的任务永远无法运行，立即返回ErrTooHeavy
+func TestTooHeavy(t *testing.T) {
+	p := New(2)
+	if err := p.SubmitWeighted(context.Background(), 3, func() {}); err != ErrTooHeavy {
+		t.Fatalf("ERROR: SubmitWeighted returns %v", err)
+	}
+	if err := p.Acquire(context.Background(), 3); err != ErrTooHeavy || p.TrySubmitWeighted(3, func() {}) {
+		t.Fatalf("ERROR: Acquire returns %v", err)
+	}
+
+	// 池变小之后，无法满足的等待者返回ErrTooHeavy，排在它后面的等待者不再被它阻塞
+	p = New(3)
+	p.Acquire(context.Background(), 2)
+	heavy, light := make(chan error), make(chan error)
+	go func() {
+		heavy <- p.Acquire(context.Background(), 3)
+	}()
+	for p.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		light <- p.Acquire(context.Background(), 1)
+	}()
+	for p.waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Resize(2)
+	if err := <-heavy; err != ErrTooHeavy {
+		t.Fatalf("ERROR: The heavy waiter returns %v", err)
+	}
+	p.Release(1)
+	if err := <-light; err != nil {
+		t.Fatalf("ERROR: The light waiter returns %v", err)
+	}
+	p.Release(2)
+}
+
+func TestPanic(t *testing.T) {
+	var handled int64
+	p := New(2, WithPanicHandler(func(err *PanicError) {
+		atomic.AddInt64(&handled, 1)
+	}))
+	for i := 0; i < 3; i++ {
+		p.Submit(context.Background(), func() { panic("oops") })
+	}
+	p.Submit(context.Background(), func() {})
+	err := p.Wait()
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Value != "oops" || len(perr.Stack) == 0 || handled != 3 {
+		t.Fatalf("ERROR: Wait returns %v, %d panics are handled", err, handled)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Fatalf("ERROR: %d panics are returned", n)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("ERROR: The panics are returned again: %s", err)
+	}
+	// panic的任务释放了它的权重
+	if !p.TrySubmitWeighted(2, func() {}) {
+		t.Fatal("ERROR: The weight of a panicked task is leaked!")
+	}
+	p.Wait()
+}
+
+func TestClose(t *testing.T) {
+	p := New(1)
+	release := make(chan struct{})
+	p.Submit(context.Background(), func() { <-release })
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.Submit(context.Background(), func() {})
+	}()
+	for p.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Close()
+	if err := <-submitted; err != ErrClosed {
+		t.Fatalf("ERROR: The waiting Submit returns %v", err)
+	}
+	if p.TrySubmit(func() {}) || p.Submit(context.Background(), func() {}) != ErrClosed {
+		t.Fatal("ERROR: A task is submitted to a closed pool!")
+	}
+	close(release)
+	p.Wait()
+}
+
+func (p *Pool) waiting() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waiters.Len()
+}